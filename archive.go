@@ -0,0 +1,192 @@
+package nim
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archives written by Export are tar streams. A leading global header
+// records the format version and the export time; every entry is a regular
+// file named after its key holding the payload, with the absolute expiry in
+// a PAX record. Tombstones are empty files marked by a PAX record.
+const (
+	archiveVersion      = "1"
+	archiveVersionPAX   = "NIM.version"
+	archiveExportedPAX  = "NIM.exported"
+	archiveExpiryPAX    = "NIM.expiry"
+	archiveTombstonePAX = "NIM.tombstone"
+	archiveGlobalHeader = "nim-archive"
+)
+
+// ImportOptions controls Client.Import.
+type ImportOptions struct {
+	// SkipExpired drops entries whose expiry, after any rebasing, has
+	// passed.
+	SkipExpired bool
+	// Rebase shifts every expiry by the time elapsed since the export, so
+	// entries keep the TTL they had left when exported.
+	Rebase bool
+}
+
+// Export writes the live entries under prefix in the local backend to w as
+// a tar archive, tombstones included, and reports how many it wrote.
+func (c *Client) Export(w io.Writer, prefix string) (int, error) {
+	keys, err := c.backend.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	tw := tar.NewWriter(w)
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeXGlobalHeader,
+		Name:     archiveGlobalHeader,
+		PAXRecords: map[string]string{
+			archiveVersionPAX:  archiveVersion,
+			archiveExportedPAX: strconv.FormatInt(now.UnixNano(), 10),
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, key := range keys {
+		b, info, ok, err := c.backend.Get(key)
+		if err != nil {
+			return written, err
+		}
+		if !ok || info.expired(now) {
+			continue
+		}
+
+		hdr := &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       key,
+			Mode:       0o644,
+			ModTime:    now,
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{},
+		}
+		if isTombstone(b) {
+			b = nil
+			hdr.PAXRecords[archiveTombstonePAX] = "1"
+		}
+		hdr.Size = int64(len(b))
+		if !info.Expiry.IsZero() {
+			hdr.PAXRecords[archiveExpiryPAX] = strconv.FormatInt(info.Expiry.UnixNano(), 10)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return written, err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	return written, tw.Close()
+}
+
+// Import stores the entries of an archive written by Export, replacing
+// existing values, and reports how many it stored. Entries are written one
+// at a time, so a failed import leaves the entries before the failure in
+// place. An entry that is not a regular file or whose name is not a valid
+// key fails the import with ErrCacheArchiveInvalid.
+func (c *Client) Import(r io.Reader, opts ImportOptions) (int, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = ErrCacheArchiveInvalid
+		}
+		return 0, err
+	}
+	if hdr.Typeflag != tar.TypeXGlobalHeader || hdr.PAXRecords[archiveVersionPAX] != archiveVersion {
+		return 0, fmt.Errorf("%w: missing nim archive header", ErrCacheArchiveInvalid)
+	}
+	exported, err := parseNanos(hdr.PAXRecords[archiveExportedPAX])
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	imported := 0
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if err := validArchiveEntry(hdr); err != nil {
+			return imported, err
+		}
+		if hdr.Size > int64(c.maxBytes) {
+			return imported, fmt.Errorf("%w: %s has %d bytes, max %d bytes", ErrCacheValueTooLarge, hdr.Name, hdr.Size, c.maxBytes)
+		}
+
+		var expiry time.Time
+		if s, ok := hdr.PAXRecords[archiveExpiryPAX]; ok {
+			if expiry, err = parseNanos(s); err != nil {
+				return imported, err
+			}
+			if opts.Rebase {
+				expiry = now.Add(expiry.Sub(exported))
+			}
+		}
+		if opts.SkipExpired && !expiry.IsZero() && now.After(expiry) {
+			continue
+		}
+
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return imported, err
+		}
+		if hdr.PAXRecords[archiveTombstonePAX] != "" {
+			b = tombstoneValue
+		} else if err := checkValue(b); err != nil {
+			return imported, fmt.Errorf("%w: entry %q: %w", ErrCacheArchiveInvalid, hdr.Name, err)
+		}
+		sp := span{op: opImport, key: hdr.Name}
+		if err := c.putBytes(&sp, b, expiry); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+}
+
+// validArchiveEntry refuses entries Export does not write: anything but a
+// regular file, and names that are not valid keys, such as paths.
+func validArchiveEntry(hdr *tar.Header) error {
+	if hdr.Typeflag != tar.TypeReg {
+		return fmt.Errorf("%w: %q is not a regular file", ErrCacheArchiveInvalid, hdr.Name)
+	}
+	if strings.ContainsRune(hdr.Name, 0) {
+		return fmt.Errorf("%w: %q holds a NUL byte", ErrCacheArchiveInvalid, hdr.Name)
+	}
+	if err := ValidateKey(hdr.Name); err != nil {
+		return fmt.Errorf("%w: entry %q: %w", ErrCacheArchiveInvalid, hdr.Name, err)
+	}
+	if v := hdr.PAXRecords[archiveTombstonePAX]; v != "" && (v != "1" || hdr.Size != 0) {
+		return fmt.Errorf("%w: bad tombstone %q", ErrCacheArchiveInvalid, hdr.Name)
+	}
+	return nil
+}
+
+func parseNanos(s string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: bad time %q", ErrCacheArchiveInvalid, s)
+	}
+	return time.Unix(0, nanos), nil
+}
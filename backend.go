@@ -0,0 +1,59 @@
+package nim
+
+import "time"
+
+// Backend persists raw payloads for a Client. Keys handed to a Backend are
+// already validated. Get and Stat report entries regardless of expiry; the
+// Client enforces TTLs and removes expired entries lazily. Put and Expire are
+// only called while the caller holds the key's lock from Lock or TryLock,
+// whereas Delete acquires that lock itself and removes every key nested below
+// key as well.
+type Backend interface {
+	Get(key string) ([]byte, EntryInfo, bool, error)
+	Stat(key string) (EntryInfo, bool, error)
+	Put(key string, data []byte, expiry time.Time) error
+	Expire(key string, expiry time.Time) error
+	Delete(key string) error
+	List(prefix string) ([]string, error)
+	Lock(key string) (Unlocker, error)
+	TryLock(key string) (Unlocker, bool, error)
+}
+
+// LockedDeleter is implemented by backends that can run Delete while the
+// caller already holds the key's lock. The Client prefers it, so that the
+// lock wait of a removal is traced and counted like that of any write.
+type LockedDeleter interface {
+	DeleteLocked(key string) error
+}
+
+// Committer is implemented by backends that can apply a batch of writes
+// atomically. Client.Txn requires it. Commit is called with the locks of all
+// keys in ops held.
+type Committer interface {
+	Commit(ops []TxnOp) error
+}
+
+type Unlocker interface {
+	Unlock() error
+}
+
+// EntryInfo describes a stored entry. Expiry is zero for entries without a
+// TTL. Version changes whenever the payload or expiry of the entry changes.
+type EntryInfo struct {
+	Expiry  time.Time
+	Size    int64
+	Version uint64
+}
+
+// TxnOp is a single staged change handed to Committer.Commit. A removal only
+// deletes the entry at Key, leaving nested keys untouched.
+type TxnOp struct {
+	Expiry time.Time
+	Key    string
+	Data   []byte
+	Remove bool
+}
+
+func (i EntryInfo) expired(now time.Time) bool {
+	return !i.Expiry.IsZero() && now.After(i.Expiry)
+}
@@ -0,0 +1,521 @@
+package nim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AnomalyKind classifies damage found by Client.Check.
+type AnomalyKind string
+
+const (
+	// AnomalyTempFile is a payload temp file left by an interrupted write.
+	AnomalyTempFile AnomalyKind = "temp-file"
+	// AnomalyTTLTemp is an expiry symlink that was never renamed into place.
+	AnomalyTTLTemp AnomalyKind = "ttl-temp"
+	// AnomalyExtraExpiry is an expiry symlink next to a newer one. Reads use
+	// the newest, and repair removes the others.
+	AnomalyExtraExpiry AnomalyKind = "extra-expiry"
+	// AnomalyMissingCache is a key directory without a cache file that holds
+	// an expiry symlink or no nested keys.
+	AnomalyMissingCache AnomalyKind = "missing-cache"
+	// AnomalyOrphanLock is a lock file whose key directory no longer exists.
+	AnomalyOrphanLock AnomalyKind = "orphan-lock"
+)
+
+// Anomaly is a single problem found by Check. Key is empty for lock files
+// inside Config.LockDir, which cannot be mapped back to a key.
+type Anomaly struct {
+	Kind     AnomalyKind `json:"kind"`
+	Key      string      `json:"key,omitempty"`
+	Path     string      `json:"path"`
+	Repaired bool        `json:"repaired"`
+}
+
+// CheckReport is the result of Check. Busy lists the keys skipped because
+// another writer held their lock.
+type CheckReport struct {
+	Anomalies []Anomaly `json:"anomalies"`
+	Busy      []string  `json:"busy,omitempty"`
+	Dirs      int       `json:"dirs"`
+}
+
+// Check walks the root of the file backend looking for leftovers of crashed
+// writers and, with repair, removes them. Each key directory is examined
+// under its lock, so writes in flight are not mistaken for damage; keys
+// locked for the whole walk are reported in Busy. Only the default file
+// backend supports it.
+func (c *Client) Check(ctx context.Context, repair bool) (CheckReport, error) {
+	if c.files == nil {
+		return CheckReport{}, fmt.Errorf("%w: Check", ErrCacheBackendUnsupported)
+	}
+
+	chk := &checker{ctx: ctx, files: c.files, repair: repair}
+	if _, err := chk.dir(c.files.rootPath); err != nil {
+		return chk.report, err
+	}
+	if err := chk.lockFiles(); err != nil {
+		return chk.report, err
+	}
+
+	if repair {
+		for _, a := range chk.report.Anomalies {
+			if a.Repaired && a.Key != "" {
+				c.l1.invalidate(a.Key)
+			}
+		}
+	}
+	return chk.report, nil
+}
+
+type checker struct {
+	ctx    context.Context
+	files  *fileBackend
+	locks  []string
+	report CheckReport
+	repair bool
+}
+
+// dir checks the key directories below dirPath before dirPath itself, so a
+// namespace emptied by repair is removed in the same walk. It reports
+// whether dirPath still exists.
+func (chk *checker) dir(dirPath string) (bool, error) {
+	if err := chk.ctx.Err(); err != nil {
+		return false, err
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var (
+		nested bool
+		locks  []string
+	)
+	for _, entry := range entries {
+		path := filepath.Join(dirPath, entry.Name())
+		switch {
+		case entry.IsDir():
+			if dirPath == chk.files.rootPath && entry.Name() == internalDirName {
+				continue
+			}
+			exists, err := chk.dir(path)
+			if err != nil {
+				return false, err
+			}
+			nested = nested || exists
+		case entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), cacheLockSuffix):
+			locks = append(locks, path)
+		}
+	}
+	chk.locks = append(chk.locks, locks...)
+
+	// A lock file that outlived the walk below belongs to a nested key that
+	// may be about to be written.
+	for _, lockPath := range locks {
+		if _, err := os.Lstat(lockPath); err == nil {
+			nested = true
+		}
+	}
+
+	if dirPath == chk.files.rootPath {
+		return true, nil
+	}
+	chk.report.Dirs++
+	return chk.keyDir(dirPath, nested)
+}
+
+func (chk *checker) keyDir(dirPath string, nested bool) (bool, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if len(dirAnomalies(entries, nested)) == 0 {
+		return true, nil
+	}
+
+	key, _ := chk.files.keyFromDir(dirPath)
+	lock, ok, err := chk.files.locks.tryLock(chk.files.lockPath(dirPath), dirPath, nil)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		chk.report.Busy = append(chk.report.Busy, key)
+		return true, nil
+	}
+	defer func() {
+		_ = lock.unlock()
+	}()
+
+	// Re-read under the lock: what looked like damage may have been a write
+	// that has since finished.
+	entries, err = os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	exists := true
+	for _, a := range dirAnomalies(entries, nested) {
+		a.Key = key
+		if a.Path == "" {
+			a.Path = dirPath
+		} else {
+			a.Path = filepath.Join(dirPath, a.Path)
+		}
+		switch {
+		case !chk.repair:
+		case a.Kind == AnomalyMissingCache:
+			if exists, err = removeKeyDir(dirPath); err != nil {
+				return false, err
+			}
+			// Files nim does not know about keep the directory in place.
+			a.Repaired = nested || !exists
+		default:
+			if err := os.Remove(a.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return false, err
+			}
+			a.Repaired = true
+		}
+		chk.report.Anomalies = append(chk.report.Anomalies, a)
+	}
+	return exists, nil
+}
+
+// dirAnomalies inspects the entries of one key directory. Paths are relative
+// to the directory; the missing cache anomaly, reported last, has none.
+func dirAnomalies(entries []os.DirEntry, nested bool) []Anomaly {
+	var (
+		anomalies []Anomaly
+		links     int
+		hasCache  bool
+	)
+	current, _ := currentExpiryLink(entries)
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case name == cacheFileName && entry.Type().IsRegular():
+			hasCache = true
+		case entry.Type().IsRegular() && matchTemp(name):
+			anomalies = append(anomalies, Anomaly{Kind: AnomalyTempFile, Path: name})
+		case entry.Type()&os.ModeSymlink != 0 && strings.HasPrefix(name, cacheTTLTempPref):
+			anomalies = append(anomalies, Anomaly{Kind: AnomalyTTLTemp, Path: name})
+		default:
+			if _, ok := expiryLinkNanos(entry); !ok {
+				continue
+			}
+			links++
+			if entry != current {
+				anomalies = append(anomalies, Anomaly{Kind: AnomalyExtraExpiry, Path: name})
+			}
+		}
+	}
+
+	if !hasCache && (links > 0 || !nested) {
+		anomalies = append(anomalies, Anomaly{Kind: AnomalyMissingCache})
+	}
+	return anomalies
+}
+
+func matchTemp(name string) bool {
+	ok, _ := filepath.Match(cacheTempPattern, name)
+	return ok
+}
+
+// removeKeyDir removes the expiry symlinks of a key directory without a
+// cache file, and the directory itself unless something else is left in it.
+func removeKeyDir(dirPath string) (bool, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if _, ok := expiryLinkNanos(entry); !ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dirPath, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return true, err
+		}
+	}
+
+	if err := os.Remove(dirPath); err != nil {
+		if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+			return true, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+// lockFiles reports lock files that guard no key directory: those next to
+// a missing directory or, with Config.LockDir, every lock file in the key
+// tree and those in LockDir matching no directory. Lock files currently held
+// are not reported.
+func (chk *checker) lockFiles() error {
+	var orphans []Anomaly
+	for _, lockPath := range chk.locks {
+		dirPath := strings.TrimSuffix(lockPath, cacheLockSuffix)
+		if chk.files.lockRoot == "" {
+			if _, err := os.Lstat(dirPath); !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+		key, _ := chk.files.keyFromDir(dirPath)
+		orphans = append(orphans, Anomaly{Kind: AnomalyOrphanLock, Key: key, Path: lockPath})
+	}
+
+	if chk.files.lockRoot != "" {
+		inUse, err := chk.lockDirPaths()
+		if err != nil {
+			return err
+		}
+		err = filepath.WalkDir(chk.files.lockRoot, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := chk.ctx.Err(); err != nil {
+				return err
+			}
+			if d.Type().IsRegular() && strings.HasSuffix(d.Name(), cacheLockSuffix) && !inUse[path] {
+				orphans = append(orphans, Anomaly{Kind: AnomalyOrphanLock, Path: path})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, a := range orphans {
+		ok, err := probeLockFile(a.Path, chk.repair)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		a.Repaired = chk.repair
+		chk.report.Anomalies = append(chk.report.Anomalies, a)
+	}
+	return nil
+}
+
+// lockDirPaths returns the LockDir lock paths of every directory in the key
+// tree.
+func (chk *checker) lockDirPaths() (map[string]bool, error) {
+	internalPath := filepath.Join(chk.files.rootPath, internalDirName)
+	paths := make(map[string]bool)
+	err := filepath.WalkDir(chk.files.rootPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path == internalPath {
+			return filepath.SkipDir
+		}
+		if path != chk.files.rootPath {
+			paths[chk.files.lockPath(path)] = true
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// probeLockFile reports whether lockPath exists and is not held, unlinking
+// it if remove is set. Acquirers that opened it before the unlink notice the
+// inode change in claimLockFile and retry.
+func probeLockFile(lockPath string, remove bool) (bool, error) {
+	// tryLockFile would create it again.
+	if _, err := os.Lstat(lockPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	lock, ok, err := tryLockFile(lockPath)
+	if err != nil || !ok {
+		return false, err
+	}
+	defer func() {
+		_ = lock.unlock()
+	}()
+
+	if !remove {
+		return true, nil
+	}
+	if err := os.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
+
+// Recover removes payload and expiry temp files older than olderThan that
+// crashed writers left in key directories, along with transaction work
+// directories abandoned before their intent was recorded, and reports how
+// many it removed. Key directories whose lock is held are skipped. Setting
+// Config.RecoverTempAge runs it from New. Only the default file backend
+// supports it.
+func (c *Client) Recover(ctx context.Context, olderThan time.Duration) (int, error) {
+	if c.files == nil {
+		return 0, fmt.Errorf("%w: Recover", ErrCacheBackendUnsupported)
+	}
+	return c.files.recoverTemps(ctx, olderThan)
+}
+
+func (f *fileBackend) recoverTemps(ctx context.Context, olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	internalPath := filepath.Join(f.rootPath, internalDirName)
+
+	removed := 0
+	err := filepath.WalkDir(f.rootPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || path == f.rootPath {
+			return nil
+		}
+		if path == internalPath {
+			return filepath.SkipDir
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := f.recoverDirTemps(path, cutoff)
+		removed += n
+		return err
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	n, err := f.recoverTxnTemps(cutoff)
+	return removed + n, err
+}
+
+func (f *fileBackend) recoverDirTemps(dirPath string, cutoff time.Time) (int, error) {
+	stale, err := staleTemps(dirPath, cutoff)
+	if err != nil || len(stale) == 0 {
+		return 0, err
+	}
+
+	lock, ok, err := f.locks.tryLock(f.lockPath(dirPath), dirPath, nil)
+	if err != nil || !ok {
+		return 0, err
+	}
+	defer func() {
+		_ = lock.unlock()
+	}()
+
+	// The writer that held the lock may have renamed them since.
+	stale, err = staleTemps(dirPath, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// staleTemps lists the temp files in dirPath last modified before cutoff.
+func staleTemps(dirPath string, cutoff time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var stale []string
+	for _, entry := range entries {
+		name := entry.Name()
+		temp := entry.Type().IsRegular() && matchTemp(name) ||
+			entry.Type()&os.ModeSymlink != 0 && strings.HasPrefix(name, cacheTTLTempPref)
+		if !temp {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		stale = append(stale, filepath.Join(dirPath, name))
+	}
+	return stale, nil
+}
+
+// recoverTxnTemps removes transaction work directories that were never
+// renamed into place. Their owner holds the lock inside until the rename.
+func (f *fileBackend) recoverTxnTemps(cutoff time.Time) (int, error) {
+	txnRoot := filepath.Join(f.rootPath, internalDirName, txnDirName)
+	entries, err := os.ReadDir(txnRoot)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(txnTempPattern, entry.Name()); !ok || !entry.IsDir() {
+			continue
+		}
+		if info, err := entry.Info(); err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		workDir := filepath.Join(txnRoot, entry.Name())
+		lock, ok, err := tryLockFile(filepath.Join(workDir, txnLockFileName))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return removed, err
+		}
+		if !ok {
+			continue
+		}
+		err = os.RemoveAll(workDir)
+		_ = lock.unlock()
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -2,132 +2,623 @@ package nim
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
-	"errors"
 	"fmt"
-	"os"
+	"log/slog"
 	"path/filepath"
 	"time"
 )
 
 type Client struct {
-	rootPath string
-	maxBytes int
+	backend      Backend
+	remote       RemoteTier
+	files        *fileBackend
+	l1           *l1Cache
+	stats        *clientStats
+	events       *eventHub
+	log          *slog.Logger
+	tracer       Tracer
+	maxBytes     int
+	writeThrough bool
 }
 
 type Config struct {
-	RootPath string
-	MaxBytes int
+	Backend            Backend
+	Remote             RemoteTier
+	Metrics            Metrics
+	Logger             *slog.Logger
+	Tracer             Tracer
+	RootPath           string
+	LockDir            string
+	MaxBytes           int
+	LockLease          time.Duration
+	L1Entries          int
+	RecoverTempAge     time.Duration
+	L1Verify           bool
+	RemoteWriteThrough bool
+	SnapshotBarrier    bool
 }
 
 func New(cfg Config) (*Client, error) {
 	if cfg.MaxBytes <= 0 {
 		cfg.MaxBytes = defaultMaxCacheBytes
 	}
-	if cfg.RootPath == "" {
-		return nil, ErrCacheRootPathEmpty
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.DiscardHandler)
 	}
 
-	if err := os.MkdirAll(cfg.RootPath, 0o755); err != nil {
-		return nil, err
+	events := &eventHub{log: cfg.Logger}
+	c := &Client{
+		backend:      cfg.Backend,
+		remote:       cfg.Remote,
+		l1:           newL1Cache(cfg.L1Entries, cfg.L1Verify, cfg.Logger, events),
+		stats:        &clientStats{metrics: cfg.Metrics},
+		events:       events,
+		log:          cfg.Logger,
+		tracer:       cfg.Tracer,
+		maxBytes:     cfg.MaxBytes,
+		writeThrough: cfg.RemoteWriteThrough && cfg.Remote != nil,
+	}
+	if c.backend == nil {
+		files, err := newFileBackend(cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.backend = files
+		c.files = files
 	}
 
-	return &Client{rootPath: cfg.RootPath, maxBytes: cfg.MaxBytes}, nil
+	return c, nil
 }
 
 func (c *Client) Set(key string, v any, ttl time.Duration) error {
-	switch val := v.(type) {
-	case []byte:
-		return setBytes(c, key, ttl, val)
-	case string:
-		return setBytes(c, key, ttl, []byte(val))
-	default:
-		var buf bytes.Buffer
-		if err := gob.NewEncoder(&buf).Encode(v); err != nil {
-			return fmt.Errorf("failed to encode value for Set: %w", err)
-		}
-		return setBytes(c, key, ttl, buf.Bytes())
+	return c.SetContext(context.Background(), key, v, ttl)
+}
+
+// SetContext is Set with a context that is passed to Config.Tracer.
+func (c *Client) SetContext(ctx context.Context, key string, v any, ttl time.Duration) (err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpSet, key)
+	defer sp.end(&err)
+
+	data, err := encodeValue(v)
+	if err != nil {
+		return err
 	}
+	if err := checkValue(data); err != nil {
+		return err
+	}
+	return c.setBytes(&sp, ttl, data)
 }
 
 func (c *Client) Remove(key string) error {
-	dirPath, err := c.keyDir(key)
+	return c.RemoveContext(context.Background(), key)
+}
+
+// RemoveContext is Remove with a context that is passed to Config.Tracer.
+func (c *Client) RemoveContext(ctx context.Context, key string) (err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpRemove, key)
+	defer sp.end(&err)
+
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	removed, err := c.removeLocal(&sp)
 	if err != nil {
 		return err
 	}
+	if removed {
+		c.stats.remove()
+		c.events.emit(EventRemove, ReasonDelete, key, time.Time{})
+	}
+	if c.writeThrough {
+		return c.remote.Delete(key)
+	}
+	return nil
+}
+
+func (c *Client) Get(key string, out any) (bool, error) {
+	return c.GetContext(context.Background(), key, out)
+}
+
+// GetContext is Get with a context that is passed to Config.Tracer.
+func (c *Client) GetContext(ctx context.Context, key string, out any) (found bool, err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpGet, key)
+	defer sp.end(&err)
+
+	b, ok, err := c.getBytes(&sp)
+	if err != nil || !ok || isTombstone(b) {
+		return false, err
+	}
+
+	if err := decodeValue(b, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Client) Exists(key string) (bool, error) {
+	return c.ExistsContext(context.Background(), key)
+}
+
+// ExistsContext is Exists with a context that is passed to Config.Tracer.
+func (c *Client) ExistsContext(ctx context.Context, key string) (_ bool, err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpExists, key)
+	defer sp.end(&err)
+
+	_, ok, err := c.stat(&sp)
+	return ok, err
+}
 
-	if _, err := os.Stat(dirPath); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
+// Stat reports the expiry, size and version of a live entry without reading
+// its payload, falling back to the remote tier like Get.
+func (c *Client) Stat(key string) (EntryInfo, bool, error) {
+	return c.StatContext(context.Background(), key)
+}
+
+// StatContext is Stat with a context that is passed to Config.Tracer.
+func (c *Client) StatContext(ctx context.Context, key string) (_ EntryInfo, _ bool, err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpStat, key)
+	defer sp.end(&err)
+
+	return c.stat(&sp)
+}
+
+func (c *Client) stat(sp *span) (EntryInfo, bool, error) {
+	key := sp.key
+	if err := ValidateKey(key); err != nil {
+		return EntryInfo{}, false, err
+	}
+	info, ok := c.l1.stat(c.backend, key)
+	if !ok {
+		var err error
+		if info, ok, err = c.backend.Stat(key); err != nil {
+			return EntryInfo{}, false, err
 		}
-		return err
+	}
+	if ok && !info.expired(time.Now()) {
+		c.stats.read(true)
+		absent, err := c.tombstoned(key, info)
+		if err != nil || absent {
+			sp.outcome = OutcomeAbsent
+			return EntryInfo{}, false, err
+		}
+		sp.read(nil, info.Size, true)
+		return info, true, nil
+	}
+	if ok {
+		c.stats.expired()
+		c.removeExpired(sp)
 	}
 
-	lock, err := c.lockKey(dirPath)
+	b, info, ok, err := c.readRemote(sp)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
-		}
-		return err
+		return EntryInfo{}, false, err
+	}
+	c.stats.read(ok)
+	sp.read(b, info.Size, ok)
+	if !ok || isTombstone(b) {
+		return EntryInfo{}, false, nil
 	}
-	defer func() {
-		_ = lock.unlock()
-	}()
+	return info, true, nil
+}
 
-	if err := os.RemoveAll(dirPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+// Entry returns the raw payload of a live entry together with its info.
+func (c *Client) Entry(key string) ([]byte, EntryInfo, bool, error) {
+	return c.EntryContext(context.Background(), key)
+}
+
+// EntryContext is Entry with a context that is passed to Config.Tracer.
+func (c *Client) EntryContext(ctx context.Context, key string) (_ []byte, _ EntryInfo, _ bool, err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpEntry, key)
+	defer sp.end(&err)
+
+	if err := ValidateKey(key); err != nil {
+		return nil, EntryInfo{}, false, err
 	}
 
-	return nil
+	b, info, ok, err := c.getEntry(&sp)
+	if err != nil || !ok || isTombstone(b) {
+		return nil, EntryInfo{}, false, err
+	}
+	return b, info, true, nil
 }
 
-func (c *Client) Get(key string, out any) (bool, error) {
-	b, ok, err := getBytes(c, key)
-	if err != nil || !ok {
-		return ok, err
+// Expire changes the TTL of a live entry without rewriting its payload; a
+// ttl <= 0 removes the expiry. It reports whether the entry existed.
+func (c *Client) Expire(key string, ttl time.Duration) (bool, error) {
+	return c.ExpireContext(context.Background(), key, ttl)
+}
+
+// ExpireContext is Expire with a context that is passed to Config.Tracer.
+func (c *Client) ExpireContext(ctx context.Context, key string, ttl time.Duration) (_ bool, err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpExpire, key)
+	defer sp.end(&err)
+
+	if err := ValidateKey(key); err != nil {
+		return false, err
 	}
+	defer c.l1.invalidate(key)
 
-	switch v := out.(type) {
-	case *[]byte:
-		*v = b
-		return true, nil
-	case *string:
-		*v = string(b)
+	lock, err := c.lockKey(&sp)
+	if err != nil {
+		return false, err
+	}
+	defer c.unlockKey(&sp, lock)
+
+	sp.outcome = OutcomeMiss
+	info, ok, err := c.backend.Stat(key)
+	if err != nil || !ok || info.expired(time.Now()) {
+		return false, err
+	}
+	sp.outcome = OutcomeOK
+
+	expiry := expiryFromTTL(ttl)
+	if err := c.backend.Expire(key, expiry); err != nil {
+		return false, err
+	}
+	if !c.writeThrough {
 		return true, nil
-	default:
-		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(out); err != nil {
-			return false, fmt.Errorf("failed to decode cached value into target: %w", err)
+	}
+
+	// The remote tier has no expire call, so the payload is sent again.
+	b, _, ok, err := c.backend.Get(key)
+	if err != nil || !ok {
+		return true, err
+	}
+	return true, c.remote.Set(key, b, expiry)
+}
+
+// MaxBytes returns the largest encoded value the client stores, from
+// Config.MaxBytes.
+func (c *Client) MaxBytes() int {
+	return c.maxBytes
+}
+
+// DiskPath returns the file holding the payload of key, for handing entries
+// to programs that read files directly. The path is only valid while the
+// entry exists, and only the default file backend supports it.
+func (c *Client) DiskPath(key string) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	if c.files == nil {
+		return "", fmt.Errorf("%w: DiskPath", ErrCacheBackendUnsupported)
+	}
+
+	dirPath, err := c.files.keyDir(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dirPath, cacheFileName), nil
+}
+
+// Keys returns the live keys starting with prefix, sorted. Tombstones are
+// left out.
+func (c *Client) Keys(prefix string) ([]string, error) {
+	keys, err := c.backend.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	live := keys[:0]
+	for _, key := range keys {
+		info, ok, err := c.backend.Stat(key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || info.expired(now) {
+			continue
+		}
+		absent, err := c.tombstoned(key, info)
+		if err != nil {
+			return nil, err
+		}
+		if !absent {
+			live = append(live, key)
 		}
-		return true, nil
 	}
+	return live, nil
 }
 
-func (c *Client) Exists(key string) (bool, error) {
-	dirPath, err := c.keyDir(key)
+// Purge removes the expired entries under prefix from the local backend and
+// reports how many it removed. Nested namespaces below an expired key are
+// kept. The backend must implement Committer.
+func (c *Client) Purge(prefix string) (int, error) {
+	if _, ok := c.backend.(Committer); !ok {
+		return 0, fmt.Errorf("%w: Purge", ErrCacheBackendUnsupported)
+	}
+	keys, err := c.backend.List(prefix)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
 
-	cachePath := filepath.Join(dirPath, cacheFileName)
-	info, err := os.Stat(cachePath)
+	removed := 0
+	for _, key := range keys {
+		info, ok, err := c.backend.Stat(key)
+		if err != nil {
+			return removed, err
+		}
+		if !ok || !info.expired(time.Now()) {
+			continue
+		}
+
+		err = c.txn([]string{key}, ReasonPurge, func(tx *Tx) error {
+			// The entry may have been rewritten before the lock was taken.
+			info, ok, err := c.backend.Stat(key)
+			if err != nil || !ok || !info.expired(time.Now()) {
+				return err
+			}
+			removed++
+			return tx.Remove(key)
+		})
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (c *Client) getBytes(sp *span) ([]byte, bool, error) {
+	if err := ValidateKey(sp.key); err != nil {
+		return nil, false, err
+	}
+
+	b, _, ok, err := c.getEntry(sp)
+	return b, ok, err
+}
+
+func (c *Client) getEntry(sp *span) ([]byte, EntryInfo, bool, error) {
+	b, info, ok, err := c.readEntry(sp)
+	if err == nil {
+		sp.read(b, info.Size, ok)
+	}
+	return b, info, ok, err
+}
+
+func (c *Client) readEntry(sp *span) ([]byte, EntryInfo, bool, error) {
+	key := sp.key
+	if b, info, ok := c.l1.get(c.backend, key); ok {
+		c.stats.read(true)
+		return b, info, true, nil
+	}
+	gen := c.l1.generation()
+
+	b, info, ok, err := c.backend.Get(key)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return false, nil
+		return nil, EntryInfo{}, false, err
+	}
+	if ok && !info.expired(time.Now()) {
+		c.stats.read(true)
+		c.l1.add(key, b, info, gen)
+		return b, info, true, nil
+	}
+	if ok {
+		c.stats.expired()
+		c.removeExpired(sp)
+	}
+
+	b, info, ok, err = c.readRemote(sp)
+	if err == nil {
+		c.stats.read(ok)
+	}
+	return b, info, ok, err
+}
+
+// readRemote consults the remote tier after a local miss and fills the local
+// backend with what it finds, keeping the remote expiry.
+func (c *Client) readRemote(sp *span) ([]byte, EntryInfo, bool, error) {
+	key := sp.key
+	if c.remote == nil {
+		return nil, EntryInfo{}, false, nil
+	}
+
+	b, expiry, ok, err := c.remote.Get(key)
+	if err != nil || !ok {
+		return nil, EntryInfo{}, false, err
+	}
+	info := EntryInfo{Expiry: expiry, Size: int64(len(b))}
+	if info.expired(time.Now()) {
+		return nil, EntryInfo{}, false, nil
+	}
+
+	if err := c.fillLocal(sp, b, expiry); err != nil {
+		return nil, EntryInfo{}, false, err
+	}
+	if local, ok, err := c.backend.Stat(key); err == nil && ok {
+		info = local
+	}
+	return b, info, true, nil
+}
+
+func (c *Client) fillLocal(sp *span, data []byte, expiry time.Time) error {
+	if len(data) > c.maxBytes {
+		return nil
+	}
+
+	lock, err := c.lockKey(sp)
+	if err != nil {
+		return err
+	}
+	defer c.unlockKey(sp, lock)
+
+	// A local write that landed while the remote was queried wins.
+	key := sp.key
+	info, ok, err := c.backend.Stat(key)
+	if err != nil {
+		return err
+	}
+	if ok && !info.expired(time.Now()) {
+		return nil
+	}
+
+	if err := c.backend.Put(key, data, expiry); err != nil {
+		return err
+	}
+	c.events.emit(EventSet, ReasonFill, key, expiry)
+	return nil
+}
+
+// removeLocal deletes the key of sp and every key nested below it from the
+// local backend, taking the key lock through lockKey when the backend allows.
+// removed reports whether the key or any key below it was stored; without
+// LockedDeleter that is checked before Delete takes the lock.
+func (c *Client) removeLocal(sp *span) (removed bool, err error) {
+	defer c.l1.invalidateTree(sp.key)
+
+	d, ok := c.backend.(LockedDeleter)
+	if !ok {
+		if removed, err = c.storedBelow(sp.key); err != nil {
+			return false, err
 		}
+		return removed, c.backend.Delete(sp.key)
+	}
+	lock, err := c.lockKey(sp)
+	if err != nil {
+		return false, err
+	}
+	defer c.unlockKey(sp, lock)
+
+	if removed, err = c.storedBelow(sp.key); err != nil {
 		return false, err
 	}
-	if info.IsDir() {
-		return false, fmt.Errorf("%w: %s", ErrCachePathIsDir, cachePath)
+	return removed, d.DeleteLocked(sp.key)
+}
+
+// storedBelow reports whether key or any key nested below it is stored,
+// expired or not.
+func (c *Client) storedBelow(key string) (bool, error) {
+	_, ok, err := c.backend.Stat(key)
+	if err != nil || ok {
+		return ok, err
+	}
+	nested, err := c.backend.List(key + "::")
+	return len(nested) > 0, err
+}
+
+func (c *Client) setBytes(sp *span, ttl time.Duration, data []byte) error {
+	return c.putBytes(sp, data, expiryFromTTL(ttl))
+}
+
+func (c *Client) putBytes(sp *span, data []byte, expiry time.Time) error {
+	key := sp.key
+	if err := c.validateCacheSize(len(data)); err != nil {
+		return err
+	}
+	if err := ValidateKey(key); err != nil {
+		return err
 	}
+	defer c.l1.invalidate(key)
 
-	expired, err := c.isExpired(dirPath)
+	lock, err := c.lockKey(sp)
 	if err != nil {
-		return false, err
+		return err
 	}
-	if expired {
-		_ = c.Remove(key)
-		return false, nil
+	defer c.unlockKey(sp, lock)
+
+	if err := c.backend.Put(key, data, expiry); err != nil {
+		return err
 	}
+	c.stats.set(len(data))
+	c.events.emit(EventSet, ReasonWrite, key, expiry)
+	sp.size = int64(len(data))
+	if c.writeThrough {
+		return c.remote.Set(key, data, expiry)
+	}
+	return nil
+}
 
-	return true, nil
+// lockKey takes the backend lock on the key of sp, recording the wait as a
+// nested operation.
+func (c *Client) lockKey(sp *span) (_ Unlocker, err error) {
+	lsp := sp.child(OpLock)
+	defer lsp.end(&err)
+
+	start := time.Now()
+	lock, err := c.backend.Lock(sp.key)
+	if err == nil {
+		c.waited(sp.op, sp.key, start)
+	}
+	return lock, err
+}
+
+func (c *Client) unlockKey(sp *span, lock Unlocker) {
+	if err := lock.Unlock(); err != nil {
+		c.log.Warn("failed to release key lock", "op", sp.op, "key", sp.key, "err", err)
+	}
+}
+
+func (c *Client) waited(op Op, key string, start time.Time) {
+	d := c.stats.waited(start)
+	if d >= slowLockWait {
+		c.log.Warn("slow lock acquisition", "op", op, "key", key, "wait", d)
+	}
+}
+
+// removeExpired drops an entry found expired on read. Failing to do so only
+// leaves it for the next reader.
+func (c *Client) removeExpired(sp *span) {
+	removed, err := c.removeLocal(sp)
+	if err != nil {
+		c.log.Warn("failed to remove expired entry", "op", sp.op, "key", sp.key, "err", err)
+		return
+	}
+	if !removed {
+		return
+	}
+	c.log.Debug("removed expired entry", "op", sp.op, "key", sp.key)
+	c.events.emit(EventExpire, ReasonRead, sp.key, time.Time{})
+}
+
+func (c *Client) validateCacheSize(dataLen int) error {
+	if dataLen > c.maxBytes {
+		return fmt.Errorf("%w: got %d bytes, max %d bytes", ErrCacheValueTooLarge, dataLen, c.maxBytes)
+	}
+	return nil
+}
+
+func expiryFromTTL(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func encodeValue(v any) ([]byte, error) {
+	switch val := v.(type) {
+	case []byte:
+		return val, nil
+	case string:
+		return []byte(val), nil
+	default:
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+			return nil, fmt.Errorf("failed to encode value for Set: %w", codecError{err})
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+func decodeValue(b []byte, out any) error {
+	switch v := out.(type) {
+	case *[]byte:
+		*v = b
+		return nil
+	case *string:
+		*v = string(b)
+		return nil
+	default:
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode cached value into target: %w", codecError{err})
+		}
+		return nil
+	}
 }
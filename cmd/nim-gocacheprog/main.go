@@ -0,0 +1,45 @@
+// Command nim-gocacheprog is a GOCACHEPROG program backed by a nim root:
+//
+//	GOCACHEPROG="nim-gocacheprog -root /var/cache/nim-go" go build ./...
+package main
+
+import (
+	"flag"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/gocacheprog"
+)
+
+// maxOutputBytes allows for large build outputs such as linked binaries,
+// within what an int holds on 32-bit platforms.
+const maxOutputBytes = min(4<<30, math.MaxInt)
+
+func main() {
+	root := flag.String("root", defaultRoot(), "cache root path")
+	flag.Parse()
+
+	rootPath, err := filepath.Abs(*root)
+	if err != nil {
+		log.Fatalf("nim-gocacheprog: %v", err)
+	}
+	client, err := nim.New(nim.Config{RootPath: rootPath, MaxBytes: maxOutputBytes})
+	if err != nil {
+		log.Fatalf("nim-gocacheprog: %v", err)
+	}
+
+	if err := gocacheprog.Serve(client, os.Stdin, os.Stdout); err != nil {
+		log.Fatalf("nim-gocacheprog: %v", err)
+	}
+}
+
+func defaultRoot() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".nim-gocache"
+	}
+	return filepath.Join(dir, "nim-gocacheprog")
+}
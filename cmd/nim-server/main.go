@@ -0,0 +1,130 @@
+// Command nim-server serves a nim cache over HTTP, and optionally over the
+// Redis protocol on a unix socket and the memcached text protocol. See
+// packages server, resp and memcached.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/memcached"
+	"github.com/brownhounds/nim/resp"
+	"github.com/brownhounds/nim/server"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "listen address")
+	root := flag.String("root", "./.cache", "cache root path")
+	lockDir := flag.String("lock-dir", "", "directory for lock files (default: next to keys)")
+	maxBytes := flag.Int("max-bytes", 0, "max value size in bytes (default: 10 MiB)")
+	l1Entries := flag.Int("l1-entries", 0, "entries kept in the in-memory tier (0 disables it)")
+	respSocket := flag.String("resp-socket", "", "also serve the Redis protocol on this unix socket")
+	memcachedAddr := flag.String("memcached-addr", "", "also serve the memcached text protocol on this TCP address")
+	flag.Parse()
+
+	client, err := nim.New(nim.Config{
+		RootPath:  *root,
+		LockDir:   *lockDir,
+		MaxBytes:  *maxBytes,
+		L1Entries: *l1Entries,
+		// Other processes may share the root, so memory hits are verified.
+		L1Verify: true,
+	})
+	if err != nil {
+		log.Fatalf("nim-server: %v", err)
+	}
+
+	if *respSocket != "" {
+		if err := serveRESP(client, *respSocket); err != nil {
+			log.Fatalf("nim-server: %v", err)
+		}
+	}
+
+	if *memcachedAddr != "" {
+		if err := serveMemcached(client, *memcachedAddr); err != nil {
+			log.Fatalf("nim-server: %v", err)
+		}
+	}
+
+	if err := run(*addr, server.NewHandler(client)); err != nil {
+		log.Fatalf("nim-server: %v", err)
+	}
+}
+
+func serveRESP(client *nim.Client, socketPath string) error {
+	// A socket left behind by a previous run would make Listen fail.
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		log.Printf("nim-server: serving RESP on %s", socketPath)
+		if err := resp.NewServer(client).Serve(l); err != nil {
+			log.Printf("nim-server: resp: %v", err)
+		}
+	}()
+	return nil
+}
+
+func serveMemcached(client *nim.Client, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		log.Printf("nim-server: serving memcached on %s", addr)
+		if err := memcached.NewServer(client).Serve(l); err != nil {
+			log.Printf("nim-server: memcached: %v", err)
+		}
+	}()
+	return nil
+}
+
+func run(addr string, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("nim-server: listening on %s", addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
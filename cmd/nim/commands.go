@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+// client opens the root; only set may create it.
+func (e *env) client(create bool) (*nim.Client, error) {
+	if !create {
+		if _, err := os.Stat(e.root); err != nil {
+			return nil, fmt.Errorf("%w: %s", errNoRoot, e.root)
+		}
+	}
+	return nim.New(nim.Config{RootPath: e.root, LockDir: e.lockDir})
+}
+
+func (e *env) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(e.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		return errUsage
+	}
+	return nil
+}
+
+func runGet(e *env, args []string) error {
+	fs := e.flags()
+	raw := fs.Bool("raw", false, "write the payload unchanged")
+	if err := parseFlags(fs, args, 1, 1); err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	b, _, ok, err := client.Entry(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return missing(client, key)
+	}
+
+	if *raw {
+		_, err = e.out.Write(b)
+		return err
+	}
+	_, err = io.WriteString(e.out, formatPayload(b))
+	return err
+}
+
+func runSet(e *env, args []string) error {
+	fs := e.flags()
+	ttl := fs.Duration("ttl", 0, "time to live (default: no expiry)")
+	absent := fs.Bool("absent", false, "record key as absent instead of storing a value")
+	if err := parseFlags(fs, args, 1, 2); err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+	if *absent != (fs.NArg() == 1) {
+		return errUsage
+	}
+
+	client, err := e.client(true)
+	if err != nil {
+		return err
+	}
+	if *absent {
+		return client.SetAbsent(key, *ttl)
+	}
+
+	value := []byte(fs.Arg(1))
+	if fs.Arg(1) == "-" {
+		if value, err = io.ReadAll(e.in); err != nil {
+			return err
+		}
+	}
+	return client.Set(key, value, *ttl)
+}
+
+func runRemove(e *env, args []string) error {
+	fs := e.flags()
+	if err := parseFlags(fs, args, 1, -1); err != nil {
+		return err
+	}
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	for _, key := range fs.Args() {
+		if err := client.Remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runList(e *env, args []string) error {
+	fs := e.flags()
+	long := fs.Bool("l", false, "also show size and expiry")
+	if err := parseFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	keys, err := client.Keys(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !*long {
+		for _, key := range keys {
+			fmt.Fprintln(e.out, key)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(e.out, 0, 0, 2, ' ', 0)
+	now := time.Now()
+	for _, key := range keys {
+		info, ok, err := client.Stat(key)
+		if err != nil {
+			return err
+		}
+		// Expired between Keys and Stat.
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", formatSize(info.Size), formatExpiry(info.Expiry, now), key)
+	}
+	return tw.Flush()
+}
+
+func runTTL(e *env, args []string) error {
+	fs := e.flags()
+	if err := parseFlags(fs, args, 1, 2); err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 2 {
+		ttl, err := time.ParseDuration(fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		ok, err := client.Expire(key, ttl)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s", errNotFound, key)
+		}
+	}
+
+	info, ok, err := client.Stat(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return missing(client, key)
+	}
+	fmt.Fprintln(e.out, formatExpiry(info.Expiry, time.Now()))
+	return nil
+}
+
+func runStat(e *env, args []string) error {
+	fs := e.flags()
+	if err := parseFlags(fs, args, 1, 1); err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	kind := "value"
+	info, ok, err := client.Stat(key)
+	if err == nil && !ok {
+		kind = "tombstone"
+		info, ok, err = client.Tombstone(key)
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", errNotFound, key)
+	}
+	path, err := client.DiskPath(key)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(e.out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "key:\t%s\n", key)
+	fmt.Fprintf(tw, "kind:\t%s\n", kind)
+	fmt.Fprintf(tw, "size:\t%s (%d bytes)\n", formatSize(info.Size), info.Size)
+	fmt.Fprintf(tw, "version:\t%d\n", info.Version)
+	fmt.Fprintf(tw, "expires:\t%s\n", formatExpiry(info.Expiry, time.Now()))
+	fmt.Fprintf(tw, "path:\t%s\n", path)
+	return tw.Flush()
+}
+
+func runDiskUsage(e *env, args []string) error {
+	fs := e.flags()
+	if err := parseFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+	prefix := fs.Arg(0)
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	keys, err := client.Keys(prefix)
+	if err != nil {
+		return err
+	}
+
+	type usage struct {
+		name    string
+		size    int64
+		entries int
+	}
+	var (
+		groups []*usage
+		total  usage
+	)
+	byName := make(map[string]*usage)
+	for _, key := range keys {
+		info, ok, err := client.Stat(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		// Group by the first segment below the prefix.
+		rest := strings.TrimPrefix(key, prefix)
+		name := prefix + strings.SplitN(rest, "::", 2)[0]
+		g := byName[name]
+		if g == nil {
+			g = &usage{name: name}
+			byName[name] = g
+			groups = append(groups, g)
+		}
+		g.size += info.Size
+		g.entries++
+		total.size += info.Size
+		total.entries++
+	}
+
+	tw := tabwriter.NewWriter(e.out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%s\t%d\t\t%s\n", formatSize(g.size), g.entries, g.name)
+	}
+	fmt.Fprintf(tw, "%s\t%d\t\t%s\n", formatSize(total.size), total.entries, "total")
+	return tw.Flush()
+}
+
+func runGC(e *env, args []string) error {
+	fs := e.flags()
+	if err := parseFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	removed, err := client.Purge(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "removed %d expired entries\n", removed)
+	return nil
+}
+
+func runCheck(e *env, args []string) error {
+	fs := e.flags()
+	repair := fs.Bool("repair", false, "remove what is found")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	report, err := client.Check(context.Background(), *repair)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(e.out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(e.out, 0, 0, 2, ' ', 0)
+		for _, a := range report.Anomalies {
+			status := "found"
+			if a.Repaired {
+				status = "repaired"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", a.Kind, status, a.Path)
+		}
+		for _, key := range report.Busy {
+			fmt.Fprintf(tw, "busy\tskipped\t%s\n", key)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.out, "checked %d directories, %d problems\n", report.Dirs, len(report.Anomalies))
+	}
+
+	for _, a := range report.Anomalies {
+		if !a.Repaired {
+			return errDamaged
+		}
+	}
+	return nil
+}
+
+func runExport(e *env, args []string) error {
+	fs := e.flags()
+	if err := parseFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	client, err := e.client(false)
+	if err != nil {
+		return err
+	}
+	_, err = client.Export(e.out, fs.Arg(0))
+	return err
+}
+
+func runImport(e *env, args []string) error {
+	fs := e.flags()
+	rebase := fs.Bool("rebase", false, "keep the TTL entries had left when exported")
+	skipExpired := fs.Bool("skip-expired", false, "drop entries that have expired")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	client, err := e.client(true)
+	if err != nil {
+		return err
+	}
+	n, err := client.Import(e.in, nim.ImportOptions{Rebase: *rebase, SkipExpired: *skipExpired})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "imported %d entries\n", n)
+	return nil
+}
+
+// missing reports a key without a value, telling tombstones apart.
+func missing(client *nim.Client, key string) error {
+	info, ok, err := client.Tombstone(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("%w: %s is recorded as absent, expires %s", errNotFound, key, formatExpiry(info.Expiry, time.Now()))
+	}
+	return fmt.Errorf("%w: %s", errNotFound, key)
+}
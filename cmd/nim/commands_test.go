@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+func newTestEnv(t *testing.T) *env {
+	t.Helper()
+
+	return &env{root: filepath.Join(t.TempDir(), "cache")}
+}
+
+// run runs a command the way main does and returns what it printed.
+func (e *env) run(t *testing.T, name, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	e.out, e.in, e.name = &out, strings.NewReader(stdin), name
+	err := commands[name].run(e, args)
+	return out.String(), err
+}
+
+func (e *env) mustRun(t *testing.T, name string, args ...string) string {
+	t.Helper()
+
+	out, err := e.run(t, name, "", args...)
+	if err != nil {
+		t.Fatalf("nim %s %v error=%v", name, args, err)
+	}
+	return out
+}
+
+func TestCommandsRequireExistingRoot(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"get", "ls", "gc", "rm"} {
+		e := newTestEnv(t)
+		args := []string{"users::1"}
+		if name == "ls" || name == "gc" {
+			args = nil
+		}
+		if _, err := e.run(t, name, "", args...); !errors.Is(err, errNoRoot) {
+			t.Fatalf("nim %s error=%v want=%v", name, err, errNoRoot)
+		}
+	}
+}
+
+func TestCommandsSetGetTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		stdin string
+		want  string
+		set   []string
+		get   []string
+	}{
+		{name: "text", set: []string{"users::1", "alice"}, get: []string{"users::1"}, want: "alice\n"},
+		{name: "raw", set: []string{"users::1", "alice"}, get: []string{"-raw", "users::1"}, want: "alice"},
+		{name: "json", set: []string{"doc", `{"a":[1,2]}`}, get: []string{"doc"}, want: "{\n  \"a\": [\n    1,\n    2\n  ]\n}\n"},
+		{name: "stdin", stdin: "from\nstdin\n", set: []string{"in", "-"}, get: []string{"in"}, want: "from\nstdin\n"},
+		{name: "binary", set: []string{"bin", "\x00\x01"}, get: []string{"bin"}, want: "00000000  00 01                                             |..|\n"},
+		{name: "ttl", set: []string{"-ttl", "1h", "users::1", "v"}, get: []string{"users::1"}, want: "v\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			e := newTestEnv(t)
+			if _, err := e.run(t, "set", tc.stdin, tc.set...); err != nil {
+				t.Fatalf("nim set %v error=%v", tc.set, err)
+			}
+			if got := e.mustRun(t, "get", tc.get...); got != tc.want {
+				t.Fatalf("nim get %v=%q want=%q", tc.get, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommandGetShowsGobValues(t *testing.T) {
+	t.Parallel()
+
+	e := newTestEnv(t)
+	client, err := nim.New(nim.Config{RootPath: e.root})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if err := client.Set("users::1", dumpShape{Name: "alice", Points: []dumpPoint{{X: 1}}}, 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	want := "{\n  \"Name\": \"alice\",\n  \"Points\": [\n    {\n      \"X\": 1\n    }\n  ]\n}\n"
+	if got := e.mustRun(t, "get", "users::1"); got != want {
+		t.Fatalf("nim get=%q want=%q", got, want)
+	}
+}
+
+func TestCommandGetMissingTable(t *testing.T) {
+	t.Parallel()
+
+	e := newTestEnv(t)
+	e.mustRun(t, "set", "users::1", "alice")
+	e.mustRun(t, "set", "-absent", "-ttl", "1h", "users::404")
+
+	cases := []struct {
+		wantErr error
+		wantMsg string
+		args    []string
+	}{
+		{args: []string{"users::2"}, wantErr: errNotFound, wantMsg: "not found: users::2"},
+		{args: []string{"users::404"}, wantErr: errNotFound, wantMsg: "recorded as absent"},
+		{args: []string{"users::..::x"}, wantErr: nim.ErrCacheKeyInvalidSegment},
+		{args: nil, wantErr: errUsage},
+		{args: []string{"a", "b"}, wantErr: errUsage},
+	}
+	for _, tc := range cases {
+		_, err := e.run(t, "get", "", tc.args...)
+		if !errors.Is(err, tc.wantErr) || !strings.Contains(err.Error(), tc.wantMsg) {
+			t.Fatalf("nim get %v error=%v want %v containing %q", tc.args, err, tc.wantErr, tc.wantMsg)
+		}
+	}
+}
+
+func TestCommandSetUsageTable(t *testing.T) {
+	t.Parallel()
+
+	cases := [][]string{
+		nil,
+		{"-absent", "k", "v"},
+		{"k"},
+		{"-ttl", "soon", "k", "v"},
+		{"k", "v", "extra"},
+	}
+	for _, args := range cases {
+		e := newTestEnv(t)
+		if _, err := e.run(t, "set", "", args...); !errors.Is(err, errUsage) {
+			t.Fatalf("nim set %v error=%v want=%v", args, err, errUsage)
+		}
+	}
+}
+
+func TestCommandList(t *testing.T) {
+	t.Parallel()
+
+	e := newTestEnv(t)
+	for _, args := range [][]string{
+		{"users::1", "alice"},
+		{"users::1::avatar", "png"},
+		{"-ttl", "1h", "users::2", "bob"},
+		{"orders::1", "book"},
+		{"-absent", "users::404"},
+	} {
+		e.mustRun(t, "set", args...)
+	}
+
+	if got, want := e.mustRun(t, "ls"), "orders::1\nusers::1\nusers::1::avatar\nusers::2\n"; got != want {
+		t.Fatalf("nim ls=%q want=%q", got, want)
+	}
+	if got, want := e.mustRun(t, "ls", "users::1"), "users::1\nusers::1::avatar\n"; got != want {
+		t.Fatalf("nim ls users::1=%q want=%q", got, want)
+	}
+	if got := e.mustRun(t, "ls", "missing"); got != "" {
+		t.Fatalf("nim ls missing=%q want empty", got)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(e.mustRun(t, "ls", "-l", "users::2"), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("nim ls -l lines=%q want 1", lines)
+	}
+	fields := strings.Fields(lines[0])
+	if fields[0] != "3B" || fields[len(fields)-1] != "users::2" || !strings.Contains(lines[0], "(in ") {
+		t.Fatalf("nim ls -l=%q want size, expiry and key", lines[0])
+	}
+}
+
+func TestCommandGC(t *testing.T) {
+	t.Parallel()
+
+	e := newTestEnv(t)
+	e.mustRun(t, "set", "-ttl", "1ms", "users::1", "alice")
+	e.mustRun(t, "set", "-ttl", "1ms", "orders::1", "book")
+	e.mustRun(t, "set", "users::2", "bob")
+	time.Sleep(5 * time.Millisecond)
+
+	if got, want := e.mustRun(t, "gc", "users"), "removed 1 expired entries\n"; got != want {
+		t.Fatalf("nim gc users=%q want=%q", got, want)
+	}
+	if got, want := e.mustRun(t, "gc"), "removed 1 expired entries\n"; got != want {
+		t.Fatalf("nim gc=%q want=%q", got, want)
+	}
+	if got, want := e.mustRun(t, "gc"), "removed 0 expired entries\n"; got != want {
+		t.Fatalf("nim gc=%q want=%q", got, want)
+	}
+	if got, want := e.mustRun(t, "ls"), "users::2\n"; got != want {
+		t.Fatalf("nim ls=%q want=%q", got, want)
+	}
+
+	e.mustRun(t, "rm", "users")
+	if got := e.mustRun(t, "ls"); got != "" {
+		t.Fatalf("nim ls after rm=%q want empty", got)
+	}
+}
+
+func TestCommandExportImport(t *testing.T) {
+	t.Parallel()
+
+	src := newTestEnv(t)
+	src.mustRun(t, "set", "users::1", "alice")
+	src.mustRun(t, "set", "-ttl", "1h", "users::2", "bob")
+	archive := src.mustRun(t, "export", "users")
+
+	dst := newTestEnv(t)
+	out, err := dst.run(t, "import", archive)
+	if err != nil {
+		t.Fatalf("nim import error=%v", err)
+	}
+	if want := "imported 2 entries\n"; out != want {
+		t.Fatalf("nim import=%q want=%q", out, want)
+	}
+	if got, want := dst.mustRun(t, "get", "users::2"), "bob\n"; got != want {
+		t.Fatalf("nim get users::2=%q want=%q", got, want)
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import "errors"
+
+var (
+	errUsage     = errors.New("usage")
+	errNotFound  = errors.New("not found")
+	errNoRoot    = errors.New("cache root does not exist")
+	errDamaged   = errors.New("cache root has unrepaired problems")
+	errGobFormat = errors.New("not a gob stream")
+)
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// formatPayload renders a payload for a terminal, ending with a newline.
+func formatPayload(b []byte) string {
+	if json.Valid(b) {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, b, "", "  "); err == nil {
+			return buf.String() + "\n"
+		}
+	}
+	if v, err := dumpGob(b); err == nil {
+		if out, err := json.MarshalIndent(v, "", "  "); err == nil {
+			return string(out) + "\n"
+		}
+	}
+	if printable(b) {
+		if len(b) > 0 && b[len(b)-1] == '\n' {
+			return string(b)
+		}
+		return string(b) + "\n"
+	}
+	return hex.Dump(b)
+}
+
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatExpiry shows an expiry as local time with the distance from now.
+func formatExpiry(expiry, now time.Time) string {
+	if expiry.IsZero() {
+		return "never"
+	}
+	at := expiry.Local().Format(time.DateTime)
+	d := expiry.Sub(now).Round(time.Second)
+	if d < 0 {
+		return fmt.Sprintf("%s (expired %s ago)", at, -d)
+	}
+	return fmt.Sprintf("%s (in %s)", at, d)
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// gob streams describe their own types, so payloads written by Set can be
+// shown without the Go types that produced them. dumpGob walks the wire
+// format the way encoding/gob decodes it and returns a JSON-marshalable
+// tree; struct fields keep their declared order and zero fields, which gob
+// does not send, are left out.
+
+// Type ids predefined by encoding/gob.
+const (
+	gobBool      = 1
+	gobInt       = 2
+	gobUint      = 3
+	gobFloat     = 4
+	gobBytes     = 5
+	gobString    = 6
+	gobComplex   = 7
+	gobInterface = 8
+	gobFirstUser = 64
+)
+
+type gobKind int
+
+const (
+	kindArray gobKind = iota + 1
+	kindSlice
+	kindStruct
+	kindMap
+	kindEncoder
+)
+
+type gobField struct {
+	name string
+	id   int
+}
+
+type gobType struct {
+	name   string
+	fields []gobField
+	kind   gobKind
+	elem   int
+	key    int
+}
+
+// gobReader reads one message; stream holds the messages after it.
+type gobReader struct {
+	types  map[int]*gobType
+	stream *gobReader
+	buf    []byte
+	depth  int
+}
+
+// maxGobDepth bounds recursion on hostile or corrupt payloads.
+const maxGobDepth = 64
+
+func dumpGob(b []byte) (any, error) {
+	stream := &gobReader{buf: b, types: make(map[int]*gobType)}
+	for len(stream.buf) > 0 {
+		msg := &gobReader{types: stream.types, stream: stream}
+		if err := msg.nextMessage(); err != nil {
+			return nil, err
+		}
+
+		id, err := msg.int()
+		if err != nil {
+			return nil, err
+		}
+		if id < 0 {
+			if err := msg.typeDef(int(-id)); err != nil {
+				return nil, err
+			}
+			if len(msg.buf) != 0 {
+				return nil, errGobFormat
+			}
+			continue
+		}
+
+		v, err := msg.topValue(int(id))
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.buf) != 0 || len(stream.buf) != 0 {
+			return nil, errGobFormat
+		}
+		return v, nil
+	}
+	return nil, errGobFormat
+}
+
+// nextMessage moves r to the next message of the stream.
+func (r *gobReader) nextMessage() error {
+	n, err := r.stream.uint()
+	if err != nil {
+		return err
+	}
+	if n == 0 || n > uint64(len(r.stream.buf)) {
+		return errGobFormat
+	}
+	r.buf = r.stream.buf[:n]
+	r.stream.buf = r.stream.buf[n:]
+	return nil
+}
+
+func (r *gobReader) uint() (uint64, error) {
+	if len(r.buf) == 0 {
+		return 0, errGobFormat
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	if b < 0x80 {
+		return uint64(b), nil
+	}
+
+	n := -int(int8(b))
+	if n > 8 || n > len(r.buf) {
+		return 0, errGobFormat
+	}
+	var x uint64
+	for _, c := range r.buf[:n] {
+		x = x<<8 | uint64(c)
+	}
+	r.buf = r.buf[n:]
+	return x, nil
+}
+
+func (r *gobReader) int() (int64, error) {
+	u, err := r.uint()
+	if err != nil {
+		return 0, err
+	}
+	if u&1 != 0 {
+		return ^int64(u >> 1), nil
+	}
+	return int64(u >> 1), nil
+}
+
+func (r *gobReader) float() (float64, error) {
+	u, err := r.uint()
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(bits.ReverseBytes64(u)), nil
+}
+
+func (r *gobReader) bytes() ([]byte, error) {
+	n, err := r.uint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)) {
+		return nil, errGobFormat
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+// fields reads a struct encoding, calling fn with each field number present.
+func (r *gobReader) fields(fn func(field int) error) error {
+	field := -1
+	for {
+		delta, err := r.uint()
+		if err != nil {
+			return err
+		}
+		if delta == 0 {
+			return nil
+		}
+		if delta > math.MaxInt32 {
+			return errGobFormat
+		}
+		field += int(delta)
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+}
+
+// typeDef reads a wireType: one of its pointer fields is set, each holding
+// a CommonType{Name, Id} followed by the kind-specific fields.
+func (r *gobReader) typeDef(id int) error {
+	if id < gobFirstUser {
+		return errGobFormat
+	}
+	t := &gobType{}
+	err := r.fields(func(field int) error {
+		switch field {
+		case 0:
+			t.kind = kindArray
+			return r.compositeDef(t, func(f int) error {
+				switch f {
+				case 1:
+					return r.typeID(&t.elem)
+				case 2:
+					_, err := r.int()
+					return err
+				}
+				return errGobFormat
+			})
+		case 1:
+			t.kind = kindSlice
+			return r.compositeDef(t, func(f int) error {
+				if f == 1 {
+					return r.typeID(&t.elem)
+				}
+				return errGobFormat
+			})
+		case 2:
+			t.kind = kindStruct
+			return r.compositeDef(t, func(f int) error {
+				if f == 1 {
+					return r.fieldDefs(t)
+				}
+				return errGobFormat
+			})
+		case 3:
+			t.kind = kindMap
+			return r.compositeDef(t, func(f int) error {
+				switch f {
+				case 1:
+					return r.typeID(&t.key)
+				case 2:
+					return r.typeID(&t.elem)
+				}
+				return errGobFormat
+			})
+		case 4, 5, 6:
+			t.kind = kindEncoder
+			return r.compositeDef(t, func(int) error { return errGobFormat })
+		}
+		return errGobFormat
+	})
+	if err != nil {
+		return err
+	}
+	if t.kind == 0 {
+		return errGobFormat
+	}
+	r.types[id] = t
+	return nil
+}
+
+func (r *gobReader) compositeDef(t *gobType, rest func(field int) error) error {
+	return r.fields(func(field int) error {
+		if field != 0 {
+			return rest(field)
+		}
+		return r.fields(func(f int) error {
+			switch f {
+			case 0:
+				name, err := r.bytes()
+				t.name = string(name)
+				return err
+			case 1:
+				_, err := r.int()
+				return err
+			}
+			return errGobFormat
+		})
+	})
+}
+
+func (r *gobReader) fieldDefs(t *gobType) error {
+	n, err := r.uint()
+	if err != nil {
+		return err
+	}
+	if n > uint64(len(r.buf)) {
+		return errGobFormat
+	}
+	for range n {
+		var f gobField
+		err := r.fields(func(field int) error {
+			switch field {
+			case 0:
+				name, err := r.bytes()
+				f.name = string(name)
+				return err
+			case 1:
+				return r.typeID(&f.id)
+			}
+			return errGobFormat
+		})
+		if err != nil {
+			return err
+		}
+		t.fields = append(t.fields, f)
+	}
+	return nil
+}
+
+func (r *gobReader) typeID(out *int) error {
+	id, err := r.int()
+	if err != nil {
+		return err
+	}
+	if id <= 0 || id > math.MaxInt32 {
+		return errGobFormat
+	}
+	*out = int(id)
+	return nil
+}
+
+// topValue reads a message body: structs are sent as is, everything else as
+// a singleton field 0.
+func (r *gobReader) topValue(id int) (any, error) {
+	if t := r.types[id]; t != nil && t.kind == kindStruct {
+		return r.value(id)
+	}
+	delta, err := r.uint()
+	if err != nil || delta != 0 {
+		return nil, errGobFormat
+	}
+	return r.value(id)
+}
+
+func (r *gobReader) value(id int) (any, error) {
+	r.depth++
+	defer func() { r.depth-- }()
+	if r.depth > maxGobDepth {
+		return nil, errGobFormat
+	}
+
+	switch id {
+	case gobBool:
+		u, err := r.uint()
+		return u != 0, err
+	case gobInt:
+		return r.int()
+	case gobUint:
+		return r.uint()
+	case gobFloat:
+		f, err := r.float()
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			// JSON has no representation for these.
+			return fmt.Sprint(f), err
+		}
+		return f, nil
+	case gobBytes:
+		return r.bytes()
+	case gobString:
+		b, err := r.bytes()
+		return string(b), err
+	case gobComplex:
+		re, err := r.float()
+		if err != nil {
+			return nil, err
+		}
+		im, err := r.float()
+		return fmt.Sprint(complex(re, im)), err
+	case gobInterface:
+		return r.iface()
+	}
+
+	t := r.types[id]
+	if t == nil {
+		return nil, errGobFormat
+	}
+	switch t.kind {
+	case kindArray, kindSlice:
+		return r.list(t)
+	case kindMap:
+		return r.mapValue(t)
+	case kindStruct:
+		return r.structValue(t)
+	default:
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return encoderValue(b), nil
+	}
+}
+
+func (r *gobReader) list(t *gobType) (any, error) {
+	n, err := r.uint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)) {
+		return nil, errGobFormat
+	}
+	out := make([]any, 0, n)
+	for range n {
+		v, err := r.value(t.elem)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (r *gobReader) mapValue(t *gobType) (any, error) {
+	n, err := r.uint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)) {
+		return nil, errGobFormat
+	}
+	out := make(object, 0, n)
+	for range n {
+		k, err := r.value(t.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.value(t.elem)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := k.(string)
+		if !ok {
+			name = fmt.Sprint(k)
+		}
+		out = append(out, member{name: name, value: v})
+	}
+	return out, nil
+}
+
+func (r *gobReader) structValue(t *gobType) (any, error) {
+	out := object{}
+	err := r.fields(func(field int) error {
+		if field >= len(t.fields) {
+			return errGobFormat
+		}
+		v, err := r.value(t.fields[field].id)
+		if err != nil {
+			return err
+		}
+		out = append(out, member{name: t.fields[field].name, value: v})
+		return nil
+	})
+	return out, err
+}
+
+// iface reads an interface value: the registered type name, any type
+// definitions, the concrete type id and a length-prefixed value. A message
+// may end after a type definition, the value then continuing in the next.
+func (r *gobReader) iface() (any, error) {
+	name, err := r.bytes()
+	if err != nil || len(name) == 0 {
+		return nil, err
+	}
+
+	for {
+		if len(r.buf) == 0 {
+			if err := r.nextMessage(); err != nil {
+				return nil, err
+			}
+		}
+		id, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		if id >= 0 {
+			if _, err := r.uint(); err != nil {
+				return nil, err
+			}
+			return r.topValue(int(id))
+		}
+		if err := r.typeDef(int(-id)); err != nil {
+			return nil, err
+		}
+		if len(r.buf) > 0 {
+			if _, err := r.uint(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// encoderValue shows the payload of a GobEncoder, BinaryMarshaler or
+// TextMarshaler. Only time.Time is recognised; text is shown as a string
+// and anything else as bytes.
+func encoderValue(b []byte) any {
+	var t time.Time
+	if err := t.UnmarshalBinary(b); err == nil {
+		return t.Format(time.RFC3339Nano)
+	}
+	if printable(b) {
+		return string(b)
+	}
+	return b
+}
+
+type member struct {
+	value any
+	name  string
+}
+
+// object is a JSON object that keeps its members in order.
+type object []member
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(m.name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
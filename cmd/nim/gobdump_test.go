@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+type dumpPoint struct {
+	X, Y int
+}
+
+type dumpShape struct {
+	Tags   map[string]int
+	Extra  any
+	Name   string
+	Points []dumpPoint
+	Origin *dumpPoint
+	Data   []byte
+	Scale  float64
+	Closed bool
+}
+
+type dumpNamed struct {
+	Label string
+}
+
+type dumpTree struct {
+	Children []dumpTree
+	Name     string
+}
+
+func init() {
+	gob.Register(dumpNamed{})
+	gob.Register(dumpPoint{})
+	gob.Register(dumpShape{})
+}
+
+func encodeGob(t *testing.T, v any) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatalf("Encode(%#v) error=%v", v, err)
+	}
+	return buf.Bytes()
+}
+
+func TestDumpGobRoundTripTable(t *testing.T) {
+	t.Parallel()
+
+	when := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	cases := []struct {
+		value any
+		name  string
+		want  string
+	}{
+		{name: "string", value: "hello", want: `"hello"`},
+		{name: "negative int", value: -42, want: `-42`},
+		{name: "uint", value: uint64(1 << 40), want: `1099511627776`},
+		{name: "bool", value: true, want: `true`},
+		{name: "float", value: 2.5, want: `2.5`},
+		{name: "bytes", value: []byte{0, 1, 2}, want: `"AAEC"`},
+		{name: "slice", value: []int{1, 2, 3}, want: `[1,2,3]`},
+		{name: "array", value: [2]string{"a", "b"}, want: `["a","b"]`},
+		{name: "map", value: map[string]int{"a": 1}, want: `{"a":1}`},
+		{name: "map with int keys", value: map[int]string{7: "x"}, want: `{"7":"x"}`},
+		{name: "nested slices", value: [][]string{{"a"}, {"b", "c"}}, want: `[["a"],["b","c"]]`},
+		{name: "time", value: when, want: `"2024-05-01T12:30:00Z"`},
+		{
+			name: "struct",
+			value: dumpShape{
+				Name:   "tri",
+				Points: []dumpPoint{{X: 1, Y: 2}, {X: 3}},
+				Tags:   map[string]int{"sides": 3},
+				Origin: &dumpPoint{X: -1, Y: -1},
+				Data:   []byte("ok"),
+				Scale:  0.5,
+				Closed: true,
+			},
+			want: `{"Tags":{"sides":3},"Name":"tri","Points":[{"X":1,"Y":2},{"X":3}],` +
+				`"Origin":{"X":-1,"Y":-1},"Data":"b2s=","Scale":0.5,"Closed":true}`,
+		},
+		{name: "zero fields are left out", value: dumpShape{Name: "empty"}, want: `{"Name":"empty"}`},
+		{name: "interface field", value: dumpShape{Extra: dumpNamed{Label: "x"}}, want: `{"Extra":{"Label":"x"}}`},
+		{name: "interface slice", value: []any{"a", 1, dumpPoint{X: 2}}, want: `["a",1,{"X":2}]`},
+		{
+			name:  "nested interfaces",
+			value: []any{dumpShape{Extra: dumpShape{Extra: dumpNamed{Label: "z"}}}, dumpPoint{Y: 1}},
+			want:  `[{"Extra":{"Extra":{"Label":"z"}}},{"Y":1}]`,
+		},
+		{name: "nil interface", value: dumpShape{Name: "n", Extra: nil}, want: `{"Name":"n"}`},
+		{name: "recursive type", value: dumpTree{Name: "root", Children: []dumpTree{{Name: "leaf"}}}, want: `{"Children":[{"Name":"leaf"}],"Name":"root"}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			v, err := dumpGob(encodeGob(t, tc.value))
+			if err != nil {
+				t.Fatalf("dumpGob error=%v", err)
+			}
+			got, err := json.Marshal(v)
+			if err != nil {
+				t.Fatalf("Marshal error=%v", err)
+			}
+			if string(got) != tc.want {
+				t.Fatalf("dumpGob=%s want=%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDumpGobMalformedTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		in   []byte
+	}{
+		{name: "empty", in: nil},
+		{name: "zero length message", in: []byte{0}},
+		{name: "length past end", in: []byte{10, 4}},
+		{name: "oversized uint", in: []byte{0xf7, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{name: "unknown type id", in: []byte{3, 0x7f, 0, 1}},
+		{name: "trailing bytes", in: append(encodeGob(t, 1), 0)},
+		{name: "text", in: []byte("hello world")},
+		{name: "json", in: []byte(`{"a":1}`)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if v, err := dumpGob(tc.in); err == nil {
+				t.Fatalf("dumpGob(%x)=%v want error", tc.in, v)
+			}
+		})
+	}
+}
+
+func TestDumpGobTruncatedAndCorruptInput(t *testing.T) {
+	t.Parallel()
+
+	valid := [][]byte{
+		encodeGob(t, []int{1, 2, 3}),
+		encodeGob(t, map[string][]string{"a": {"b"}}),
+		encodeGob(t, dumpShape{Name: "x", Extra: dumpNamed{Label: "y"}, Points: []dumpPoint{{X: 1}}}),
+		encodeGob(t, dumpTree{Name: "a", Children: []dumpTree{{Name: "b", Children: []dumpTree{{Name: "c"}}}}}),
+	}
+
+	for _, b := range valid {
+		for n := range len(b) {
+			if v, err := dumpGob(b[:n]); err == nil {
+				t.Fatalf("dumpGob(%x) truncated to %d=%v want error", b, n, v)
+			}
+		}
+	}
+
+	// Flipped bytes may still decode to something; they must not panic.
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, b := range valid {
+		for range 2000 {
+			corrupt := bytes.Clone(b)
+			corrupt[rng.IntN(len(corrupt))] ^= byte(1 + rng.IntN(255))
+			_, _ = dumpGob(corrupt)
+		}
+	}
+}
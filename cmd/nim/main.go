@@ -0,0 +1,90 @@
+// Command nim inspects and manages a nim cache root from the shell.
+//
+//	nim [-root dir] [-lock-dir dir] <command> [arguments]
+//
+// Payloads written by Set are shown as indented JSON when they hold JSON or
+// gob data, as text when printable and as a hex dump otherwise.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+type command struct {
+	run   func(env *env, args []string) error
+	args  string
+	about string
+}
+
+var commands = map[string]command{
+	"get":    {run: runGet, args: "[-raw] <key>", about: "print the value stored at key"},
+	"set":    {run: runSet, args: "[-ttl d] [-absent] <key> [value|-]", about: "store a value, read from stdin for -"},
+	"rm":     {run: runRemove, args: "<key>...", about: "remove keys and the namespaces below them"},
+	"ls":     {run: runList, args: "[-l] [prefix]", about: "list live keys under prefix"},
+	"ttl":    {run: runTTL, args: "<key> [duration]", about: "show or change the expiry of key; 0 removes it"},
+	"stat":   {run: runStat, args: "<key>", about: "show size, version, expiry and path of key"},
+	"du":     {run: runDiskUsage, args: "[prefix]", about: "sum entry sizes per namespace under prefix"},
+	"gc":     {run: runGC, args: "[prefix]", about: "remove expired entries under prefix"},
+	"fsck":   {run: runCheck, args: "[-repair] [-json]", about: "find, and with -repair remove, leftovers of crashed writers"},
+	"export": {run: runExport, args: "[prefix]", about: "write entries under prefix to stdout as a tar archive"},
+	"import": {run: runImport, args: "[-rebase] [-skip-expired]", about: "store the entries of an archive read from stdin"},
+}
+
+func main() {
+	root := flag.String("root", "./.cache", "cache root path")
+	lockDir := flag.String("lock-dir", "", "directory for lock files (default: next to keys)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "nim: unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	e := &env{root: *root, lockDir: *lockDir, out: os.Stdout, in: os.Stdin, name: name}
+	err := cmd.run(e, flag.Args()[1:])
+	switch {
+	case err == nil:
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "usage: nim %s %s\n", name, cmd.args)
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "nim %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "usage: nim [flags] <command> [arguments]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-6s %s\n         %s\n", name, commands[name].args, commands[name].about)
+	}
+	fmt.Fprintf(w, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+type env struct {
+	out     io.Writer
+	in      io.Reader
+	root    string
+	lockDir string
+	name    string
+}
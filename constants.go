@@ -1,9 +1,36 @@
 package nim
 
+import "time"
+
 const (
 	cacheFileName        = "cache"
 	cacheTempPattern     = "cache-tmp-*"
 	cacheLockSuffix      = ".lock"
 	cacheTTLTempPref     = "ttl-temp-"
 	defaultMaxCacheBytes = 10 * 1024 * 1024
+	internalDirName      = ".nim"
+	txnDirName           = "txn"
+	txnNamePrefix        = "txn-"
+	txnTempPattern       = "tmp-*"
+	txnLockFileName      = "lock"
+	txnIntentFileName    = "intent"
+	txnIntentTempName    = "intent-tmp"
+	barrierFileName      = "barrier"
+	snapshotTempSuffix   = ".snapshot-tmp-*"
+	restoreOldSuffix     = ".restore-old-*"
+	lockRetryMin         = time.Millisecond
+	lockRetryMax         = 50 * time.Millisecond
+	maxLockHandoffs      = 8
+	slowLockWait         = 100 * time.Millisecond
+	watchPollInterval    = time.Second
+	watchSettle          = 10 * time.Millisecond
+	maxQueuedEvents      = 10000
 )
+
+// RemoteExpiresHeader carries an entry's expiry as Unix nanoseconds between
+// HTTPTier and a nim server. It is absent for entries without a TTL.
+const RemoteExpiresHeader = "Nim-Expires"
+
+// RemoteAbsentHeader marks a 404 from a nim server for a key recorded with
+// SetAbsent, and a PUT recording one; both carry the tombstone's expiry.
+const RemoteAbsentHeader = "Nim-Absent"
@@ -3,9 +3,25 @@ package nim
 import "errors"
 
 var (
-	ErrCacheRootPathEmpty   = errors.New("cache root path cannot be empty")
-	ErrCacheKeyEmpty        = errors.New("cache key cannot be empty")
-	ErrCacheKeyEmptySegment = errors.New("cache key contains empty segment")
-	ErrCachePathIsDir       = errors.New("cache path is a directory")
-	ErrCacheValueTooLarge   = errors.New("cache value exceeds max bytes")
+	ErrCacheRootPathEmpty      = errors.New("cache root path cannot be empty")
+	ErrCacheKeyEmpty           = errors.New("cache key cannot be empty")
+	ErrCacheKeyEmptySegment    = errors.New("cache key contains empty segment")
+	ErrCacheKeyInvalidSegment  = errors.New("cache key contains a path segment")
+	ErrCachePathIsDir          = errors.New("cache path is a directory")
+	ErrCacheValueTooLarge      = errors.New("cache value exceeds max bytes")
+	ErrCacheValueReserved      = errors.New("cache value is reserved for tombstones")
+	ErrCacheKeyReserved        = errors.New("cache key uses reserved namespace")
+	ErrCacheTxnKeyNotLocked    = errors.New("cache key is not part of the transaction")
+	ErrCacheTxnClosed          = errors.New("cache transaction is closed")
+	ErrCacheLockLost           = errors.New("cache lock is no longer held")
+	ErrCacheBackendUnsupported = errors.New("cache backend does not support operation")
+	ErrCacheRemote             = errors.New("cache remote tier request failed")
+	ErrCacheMemoizedError      = errors.New("cache memoized error")
+	ErrCacheMemoizedPanic      = errors.New("cache memoized function panicked")
+	ErrCacheArchiveInvalid     = errors.New("cache archive is invalid")
+	ErrCacheSnapshotInvalid    = errors.New("cache snapshot is not a directory")
+	ErrCacheSnapshotInRoot     = errors.New("cache snapshot path is inside the root")
 )
+
+// errWatchLimit reports that no more inotify watches can be added.
+var errWatchLimit = errors.New("inotify watch limit reached")
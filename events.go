@@ -0,0 +1,203 @@
+package nim
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind is the change an Event reports.
+type EventKind string
+
+const (
+	// EventSet is an entry written to the local backend.
+	EventSet EventKind = "set"
+	// EventRemove is an entry removed before it expired.
+	EventRemove EventKind = "remove"
+	// EventExpire is an expired entry removed from the local backend.
+	EventExpire EventKind = "expire"
+	// EventEvict is an entry dropped from the in-memory tier to make room.
+	// It stays in the backend.
+	EventEvict EventKind = "evict"
+)
+
+// EventReason is what caused an Event.
+type EventReason string
+
+const (
+	// ReasonWrite is Set, SetAbsent or Import.
+	ReasonWrite EventReason = "write"
+	// ReasonFill is a copy from the remote tier after a local miss.
+	ReasonFill EventReason = "fill"
+	// ReasonTxn is a committed transaction.
+	ReasonTxn EventReason = "txn"
+	// ReasonDelete is Remove.
+	ReasonDelete EventReason = "delete"
+	// ReasonRead is a read that found the entry expired.
+	ReasonRead EventReason = "read"
+	// ReasonPurge is Purge.
+	ReasonPurge EventReason = "purge"
+	// ReasonCapacity is the in-memory tier reaching Config.L1Entries.
+	ReasonCapacity EventReason = "capacity"
+	// ReasonWatch is a change seen by Watch in the key tree, made by any
+	// process.
+	ReasonWatch EventReason = "watch"
+)
+
+// Event reports a change made through a Client. Expiry is set for
+// EventSet, zero meaning the entry does not expire.
+//
+// Handlers registered with OnSet, OnRemove, OnExpire and OnEvict are called
+// on a separate goroutine after the operation has returned, one event at a
+// time in the order they happened, so they may use the client and a slow
+// handler delays later events without blocking writers. At most 10000
+// events wait for delivery; later ones are dropped and counted in
+// Stats.EventsDropped. A panicking handler is logged through Config.Logger
+// and does not stop delivery. Only changes made through the same Client
+// are reported. The returned cancel unregisters the handler; events already
+// queued may still reach it.
+type Event struct {
+	Time   time.Time
+	Expiry time.Time
+	Kind   EventKind
+	Reason EventReason
+	Key    string
+}
+
+// eventHub queues events and delivers them on a goroutine that runs only
+// while the queue is not empty, one event at a time in the order they were
+// emitted.
+type eventHub struct {
+	handlers map[EventKind][]*eventHandler
+	log      *slog.Logger
+	queue    []Event
+	count    atomic.Int64
+	dropped  atomic.Uint64
+	mu       sync.Mutex
+	running  bool
+}
+
+type eventHandler struct {
+	fn func(Event)
+}
+
+// OnSet registers fn to be called after entries are written.
+func (c *Client) OnSet(fn func(Event)) (cancel func()) {
+	return c.events.on(EventSet, fn)
+}
+
+// OnRemove registers fn to be called after entries are removed. Remove is
+// reported for the key given, also when it held no entry, and not for the
+// nested namespaces it removes with it.
+func (c *Client) OnRemove(fn func(Event)) (cancel func()) {
+	return c.events.on(EventRemove, fn)
+}
+
+// OnExpire registers fn to be called after expired entries are removed,
+// either by a read finding them expired or by Purge.
+func (c *Client) OnExpire(fn func(Event)) (cancel func()) {
+	return c.events.on(EventExpire, fn)
+}
+
+// OnEvict registers fn to be called after entries are evicted from the
+// in-memory tier.
+func (c *Client) OnEvict(fn func(Event)) (cancel func()) {
+	return c.events.on(EventEvict, fn)
+}
+
+func (h *eventHub) on(kind EventKind, fn func(Event)) (cancel func()) {
+	handler := &eventHandler{fn: fn}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handlers == nil {
+		h.handlers = make(map[EventKind][]*eventHandler)
+	}
+	h.handlers[kind] = append(h.handlers[kind], handler)
+	h.count.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.handlers[kind] = slices.DeleteFunc(h.handlers[kind], func(e *eventHandler) bool {
+				return e == handler
+			})
+			h.count.Add(-1)
+		})
+	}
+}
+
+func (h *eventHub) emit(kind EventKind, reason EventReason, key string, expiry time.Time) {
+	if h.count.Load() == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.handlers[kind]) == 0 {
+		return
+	}
+	if len(h.queue) >= maxQueuedEvents {
+		h.dropped.Add(1)
+		return
+	}
+	h.queue = append(h.queue, Event{
+		Time:   time.Now(),
+		Expiry: expiry,
+		Kind:   kind,
+		Reason: reason,
+		Key:    key,
+	})
+	if !h.running {
+		h.running = true
+		go h.deliver()
+	}
+}
+
+func (h *eventHub) deliver() {
+	for {
+		h.mu.Lock()
+		if len(h.queue) == 0 {
+			h.queue = nil
+			h.running = false
+			h.mu.Unlock()
+			return
+		}
+		ev := h.queue[0]
+		h.queue = h.queue[1:]
+		handlers := slices.Clone(h.handlers[ev.Kind])
+		h.mu.Unlock()
+
+		for _, handler := range handlers {
+			h.call(handler, ev)
+		}
+	}
+}
+
+func (h *eventHub) call(handler *eventHandler, ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.log.Error("event handler panicked", "op", "event", "key", ev.Key, "kind", ev.Kind, "err", r)
+		}
+	}()
+	handler.fn(ev)
+}
+
+// emitTxn reports the ops of a committed transaction. Removals made by
+// Purge are expirations.
+func (h *eventHub) emitTxn(ops []TxnOp, reason EventReason) {
+	for _, op := range ops {
+		switch {
+		case !op.Remove:
+			h.emit(EventSet, reason, op.Key, op.Expiry)
+		case reason == ReasonPurge:
+			h.emit(EventExpire, reason, op.Key, time.Time{})
+		default:
+			h.emit(EventRemove, reason, op.Key, time.Time{})
+		}
+	}
+}
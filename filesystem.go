@@ -1,60 +1,151 @@
 package nim
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
-func getBytes(c *Client, key string) (data []byte, ok bool, err error) {
-	ok, err = c.Exists(key)
-	if err != nil || !ok {
-		return nil, ok, err
+// fileBackend is the default Backend. Keys map to nested directories under
+// rootPath; each holds the payload in a cache file and its expiry as the name
+// of a symlink pointing at it.
+type fileBackend struct {
+	locks     *lockTable
+	barrier   *rootBarrier
+	log       *slog.Logger
+	rootPath  string
+	lockRoot  string
+	lockLease time.Duration
+}
+
+func newFileBackend(cfg Config) (*fileBackend, error) {
+	if cfg.RootPath == "" {
+		return nil, ErrCacheRootPathEmpty
+	}
+
+	if err := os.MkdirAll(cfg.RootPath, 0o755); err != nil {
+		return nil, err
+	}
+
+	if cfg.LockDir != "" {
+		if err := createLockDirs(cfg.LockDir); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &fileBackend{
+		locks:     newLockTable(),
+		log:       cfg.Logger,
+		rootPath:  cfg.RootPath,
+		lockRoot:  cfg.LockDir,
+		lockLease: cfg.LockLease,
+	}
+	if cfg.SnapshotBarrier {
+		f.barrier = newRootBarrier(cfg.RootPath)
+	}
+	if err := f.recoverTxns(); err != nil {
+		return nil, err
+	}
+	if cfg.RecoverTempAge > 0 {
+		if _, err := f.recoverTemps(context.Background(), cfg.RecoverTempAge); err != nil {
+			return nil, err
+		}
 	}
 
-	dirPath, err := c.keyDir(key)
+	return f, nil
+}
+
+func (f *fileBackend) Get(key string) ([]byte, EntryInfo, bool, error) {
+	dirPath, err := f.keyDir(key)
 	if err != nil {
-		return nil, false, err
+		return nil, EntryInfo{}, false, err
 	}
 
 	cachePath := filepath.Join(dirPath, cacheFileName)
-	b, err := os.ReadFile(cachePath)
+	file, err := os.Open(cachePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil, false, nil
+			return nil, EntryInfo{}, false, nil
 		}
-		return nil, false, err
+		return nil, EntryInfo{}, false, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// Stat and read go through the same descriptor so the reported version
+	// matches the payload even if the file is replaced concurrently.
+	info, err := file.Stat()
+	if err != nil {
+		return nil, EntryInfo{}, false, err
+	}
+	if info.IsDir() {
+		return nil, EntryInfo{}, false, fmt.Errorf("%w: %s", ErrCachePathIsDir, cachePath)
+	}
+
+	expiry, _, err := readExpiryFromSymlink(dirPath)
+	if err != nil {
+		return nil, EntryInfo{}, false, err
+	}
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return nil, EntryInfo{}, false, err
 	}
 
-	return b, true, nil
+	return b, entryInfo(info, expiry), true, nil
 }
 
-func setBytes(c *Client, key string, ttl time.Duration, data []byte) error {
-	if err := c.validateCacheSize(len(data)); err != nil {
-		return err
+func (f *fileBackend) Stat(key string) (EntryInfo, bool, error) {
+	dirPath, err := f.keyDir(key)
+	if err != nil {
+		return EntryInfo{}, false, err
 	}
 
-	dirPath, err := c.keyDir(key)
+	cachePath := filepath.Join(dirPath, cacheFileName)
+	info, err := os.Stat(cachePath)
 	if err != nil {
-		return err
+		if errors.Is(err, os.ErrNotExist) {
+			return EntryInfo{}, false, nil
+		}
+		return EntryInfo{}, false, err
+	}
+	if info.IsDir() {
+		return EntryInfo{}, false, fmt.Errorf("%w: %s", ErrCachePathIsDir, cachePath)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(dirPath), 0o755); err != nil {
-		return err
+	expiry, _, err := readExpiryFromSymlink(dirPath)
+	if err != nil {
+		return EntryInfo{}, false, err
 	}
 
-	lock, err := c.lockKey(dirPath)
+	return entryInfo(info, expiry), true, nil
+}
+
+func (f *fileBackend) Put(key string, data []byte, expiry time.Time) error {
+	dirPath, err := f.keyDir(key)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = lock.unlock()
-	}()
+
+	if err := f.barrier.enter(); err != nil {
+		return err
+	}
+	defer f.barrier.leave()
 
 	if err := os.MkdirAll(dirPath, 0o755); err != nil {
 		return err
@@ -66,7 +157,10 @@ func setBytes(c *Client, key string, ttl time.Duration, data []byte) error {
 	}
 	tmpPath := tmp.Name()
 	defer func() {
-		_ = os.Remove(tmpPath)
+		// Gone after a successful rename.
+		if err := os.Remove(tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			f.log.Warn("failed to remove temp file", "op", "put", "key", key, "path", tmpPath, "err", err)
+		}
 	}()
 
 	if _, err := tmp.Write(data); err != nil {
@@ -86,10 +180,216 @@ func setBytes(c *Client, key string, ttl time.Duration, data []byte) error {
 		return err
 	}
 
-	return c.writeTTLSymlink(dirPath, ttl)
+	return f.writeExpirySymlink(dirPath, expiry)
+}
+
+func (f *fileBackend) Expire(key string, expiry time.Time) error {
+	dirPath, err := f.keyDir(key)
+	if err != nil {
+		return err
+	}
+
+	if err := f.barrier.enter(); err != nil {
+		return err
+	}
+	defer f.barrier.leave()
+
+	if _, err := os.Stat(filepath.Join(dirPath, cacheFileName)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	return f.writeExpirySymlink(dirPath, expiry)
+}
+
+func (f *fileBackend) Delete(key string) error {
+	dirPath, err := f.keyDir(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dirPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	lock, err := f.lockKey(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		_ = lock.unlock()
+	}()
+
+	return f.removeDir(dirPath)
+}
+
+func (f *fileBackend) DeleteLocked(key string) error {
+	dirPath, err := f.keyDir(key)
+	if err != nil {
+		return err
+	}
+	return f.removeDir(dirPath)
+}
+
+func (f *fileBackend) removeDir(dirPath string) error {
+	if err := f.barrier.enter(); err != nil {
+		return err
+	}
+	defer f.barrier.leave()
+
+	if err := os.RemoveAll(dirPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// List walks only the directories fixed by the complete segments of prefix
+// and returns the keys holding a cache file, sorted.
+func (f *fileBackend) List(prefix string) ([]string, error) {
+	start := f.rootPath
+	if segments := strings.Split(prefix, "::"); len(segments) > 1 {
+		complete := strings.Join(segments[:len(segments)-1], "::")
+		if err := ValidateKey(complete); err != nil {
+			// No key lies below a prefix no key can start with.
+			return nil, nil
+		}
+		start = filepath.Join(append([]string{f.rootPath}, segments[:len(segments)-1]...)...)
+	}
+	internalPath := filepath.Join(f.rootPath, internalDirName)
+
+	var keys []string
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path == internalPath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != cacheFileName || !d.Type().IsRegular() {
+			return nil
+		}
+
+		key, ok := f.keyFromDir(filepath.Dir(path))
+		if ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (f *fileBackend) Lock(key string) (Unlocker, error) {
+	dirPath, err := f.keyDir(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dirPath), 0o755); err != nil {
+		return nil, err
+	}
+
+	lock, err := f.lockKey(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (f *fileBackend) TryLock(key string) (Unlocker, bool, error) {
+	dirPath, err := f.keyDir(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dirPath), 0o755); err != nil {
+		return nil, false, err
+	}
+
+	lock, ok, err := f.locks.tryLock(f.lockPath(dirPath), dirPath, nil)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return lock, true, nil
+}
+
+func entryInfo(info os.FileInfo, expiry time.Time) EntryInfo {
+	var ino uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		ino = st.Ino
+	}
+
+	var buf [32]byte
+	binary.LittleEndian.PutUint64(buf[0:], ino)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(info.ModTime().UnixNano()))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(info.Size()))
+	if !expiry.IsZero() {
+		binary.LittleEndian.PutUint64(buf[24:], uint64(expiry.UnixNano()))
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(buf[:])
+
+	return EntryInfo{Expiry: expiry, Size: info.Size(), Version: h.Sum64()}
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dirPath string) error {
+	d, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
 }
 
-func (c *Client) keyDir(key string) (string, error) {
+func createLockDirs(lockRoot string) error {
+	for i := range 256 {
+		name := hex.EncodeToString([]byte{byte(i)})
+		if err := os.MkdirAll(filepath.Join(lockRoot, name), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fileBackend) keyDir(key string) (string, error) {
 	if err := ValidateKey(key); err != nil {
 		return "", err
 	}
@@ -97,32 +397,32 @@ func (c *Client) keyDir(key string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(append([]string{c.rootPath}, parts...)...), nil
+	dirPath := filepath.Join(append([]string{f.rootPath}, parts...)...)
+	// ValidateKey already refuses such segments; never leave the root.
+	if rel, err := filepath.Rel(f.rootPath, dirPath); err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %q", ErrCacheKeyInvalidSegment, key)
+	}
+	return dirPath, nil
 }
 
-func (c *Client) isExpired(dirPath string) (bool, error) {
-	expiry, ok, err := readExpiryFromSymlink(dirPath)
-	if err != nil {
-		return false, err
+func (f *fileBackend) keyFromDir(dirPath string) (string, bool) {
+	rel, err := filepath.Rel(f.rootPath, dirPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
 	}
-	if !ok {
-		return false, nil
-	}
-
-	return time.Now().After(expiry), nil
+	return strings.ReplaceAll(filepath.ToSlash(rel), "/", "::"), true
 }
 
-func (c *Client) writeTTLSymlink(dirPath string, ttl time.Duration) error {
-	if ttl <= 0 {
-		return c.removeTTLSymlinks(dirPath)
+func (f *fileBackend) writeExpirySymlink(dirPath string, expiry time.Time) error {
+	if expiry.IsZero() {
+		return f.removeTTLSymlinks(dirPath)
 	}
 
-	if err := c.removeTTLSymlinks(dirPath); err != nil {
+	if err := f.removeTTLSymlinks(dirPath); err != nil {
 		return err
 	}
 
-	expiry := time.Now().Add(ttl).UnixNano()
-	finalName := strconv.FormatInt(expiry, 10)
+	finalName := strconv.FormatInt(expiry.UnixNano(), 10)
 	finalPath := filepath.Join(dirPath, finalName)
 	tmpName := cacheTTLTempPref + finalName
 	tmpPath := filepath.Join(dirPath, tmpName)
@@ -139,14 +439,7 @@ func (c *Client) writeTTLSymlink(dirPath string, ttl time.Duration) error {
 	return nil
 }
 
-func (c *Client) validateCacheSize(dataLen int) error {
-	if dataLen > c.maxBytes {
-		return fmt.Errorf("%w: got %d bytes, max %d bytes", ErrCacheValueTooLarge, dataLen, c.maxBytes)
-	}
-	return nil
-}
-
-func (c *Client) removeTTLSymlinks(dirPath string) error {
+func (f *fileBackend) removeTTLSymlinks(dirPath string) error {
 	entries, err := os.ReadDir(dirPath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -155,8 +448,13 @@ func (c *Client) removeTTLSymlinks(dirPath string) error {
 		return err
 	}
 	for _, entry := range entries {
-		if entry.Type()&os.ModeSymlink != 0 {
-			_ = os.Remove(filepath.Join(dirPath, entry.Name()))
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		path := filepath.Join(dirPath, entry.Name())
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			key, _ := f.keyFromDir(dirPath)
+			f.log.Warn("failed to remove expiry symlink", "key", key, "path", path, "err", err)
 		}
 	}
 	return nil
@@ -170,45 +468,176 @@ func readExpiryFromSymlink(dirPath string) (time.Time, bool, error) {
 		}
 		return time.Time{}, false, err
 	}
+
+	entry, ok := currentExpiryLink(entries)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	nanos, _ := expiryLinkNanos(entry)
+	return time.Unix(0, nanos), true, nil
+}
+
+// currentExpiryLink picks the expiry symlink in effect. A directory normally
+// holds at most one; if a crash left several, the most recently created one
+// wins.
+func currentExpiryLink(entries []os.DirEntry) (os.DirEntry, bool) {
+	var (
+		current os.DirEntry
+		modTime time.Time
+	)
 	for _, entry := range entries {
-		if entry.Type()&os.ModeSymlink == 0 {
+		if _, ok := expiryLinkNanos(entry); !ok {
 			continue
 		}
-		name := entry.Name()
-		if strings.HasPrefix(name, cacheTTLTempPref) {
+		if current == nil {
+			current = entry
 			continue
 		}
-		nanos, err := strconv.ParseInt(name, 10, 64)
-		if err != nil {
-			continue
+		if modTime.IsZero() {
+			modTime = linkModTime(current)
+		}
+		if t := linkModTime(entry); t.After(modTime) || (t.Equal(modTime) && entry.Name() > current.Name()) {
+			current, modTime = entry, t
 		}
-		return time.Unix(0, nanos), true, nil
 	}
+	return current, current != nil
+}
 
-	return time.Time{}, false, nil
+func expiryLinkNanos(entry os.DirEntry) (int64, bool) {
+	if entry.Type()&os.ModeSymlink == 0 || strings.HasPrefix(entry.Name(), cacheTTLTempPref) {
+		return 0, false
+	}
+	nanos, err := strconv.ParseInt(entry.Name(), 10, 64)
+	return nanos, err == nil
+}
+
+func linkModTime(entry os.DirEntry) time.Time {
+	info, err := entry.Info()
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
 }
 
 type keyLock struct {
-	file *os.File
+	file    *os.File
+	table   *lockTable
+	entry   *lockEntry
+	path    string
+	dirPath string
 }
 
-func (c *Client) lockKey(dirPath string) (*keyLock, error) {
-	lockPath := dirPath + cacheLockSuffix
-	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
-	if err != nil {
-		return nil, err
+func (f *fileBackend) lockKey(dirPath string) (*keyLock, error) {
+	return f.locks.lock(f.lockPath(dirPath), dirPath)
+}
+
+// lockPath returns the lock file guarding dirPath: a sibling of the key
+// directory by default, or a file named after the SHA-256 of the key inside
+// Config.LockDir.
+func (f *fileBackend) lockPath(dirPath string) string {
+	if f.lockRoot == "" {
+		return dirPath + cacheLockSuffix
+	}
+
+	key, ok := f.keyFromDir(dirPath)
+	if !ok {
+		key = dirPath
+	}
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(f.lockRoot, name[:2], name+cacheLockSuffix)
+}
+
+func lockFile(lockPath string) (*keyLock, error) {
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		ok, err := claimLockFile(f, lockPath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &keyLock{file: f}, nil
+		}
+	}
+}
+
+func tryLockFile(lockPath string) (*keyLock, bool, error) {
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			_ = f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		ok, err := claimLockFile(f, lockPath)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			return &keyLock{file: f}, true, nil
+		}
 	}
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+}
+
+// claimLockFile checks that the flocked file is still the one linked at
+// lockPath, since a stale lock may have been broken while we waited on it.
+// On success any holder metadata left behind is cleared; otherwise f is
+// released and closed.
+func claimLockFile(f *os.File, lockPath string) (bool, error) {
+	release := func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
 		_ = f.Close()
-		return nil, err
 	}
-	return &keyLock{file: f}, nil
+
+	held, err := f.Stat()
+	if err != nil {
+		release()
+		return false, err
+	}
+	current, err := os.Stat(lockPath)
+	if err != nil {
+		release()
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !os.SameFile(held, current) {
+		release()
+		return false, nil
+	}
+
+	if held.Size() > 0 {
+		if err := f.Truncate(0); err != nil {
+			release()
+			return false, err
+		}
+	}
+	return true, nil
 }
 
 func (l *keyLock) unlock() error {
 	if l == nil || l.file == nil {
 		return nil
 	}
-	_ = syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
-	return l.file.Close()
+	if l.table != nil {
+		return l.table.unlock(l)
+	}
+	return unlockFile(l.file)
+}
+
+func (l *keyLock) Unlock() error {
+	return l.unlock()
 }
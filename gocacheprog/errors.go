@@ -0,0 +1,8 @@
+package gocacheprog
+
+import "errors"
+
+var (
+	ErrProtocol       = errors.New("gocacheprog protocol error")
+	ErrUnknownCommand = errors.New("gocacheprog unknown command")
+)
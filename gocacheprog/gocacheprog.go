@@ -0,0 +1,202 @@
+// Package gocacheprog implements the GOCACHEPROG protocol of the go command
+// on top of a nim cache, so builds can share a nim root as their build cache.
+//
+// Action entries are stored under "gocache::a::<action id>" and hold the
+// output ID, size and put time; bodies are stored under
+// "gocache::o::<output id>", whose payload files are handed to the go
+// command as DiskPath. The client must use the default file backend.
+package gocacheprog
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+const (
+	actionKeyPrefix = "gocache::a::"
+	outputKeyPrefix = "gocache::o::"
+
+	cmdGet   = "get"
+	cmdPut   = "put"
+	cmdClose = "close"
+)
+
+type request struct {
+	Command  string
+	ActionID []byte `json:",omitempty"`
+	OutputID []byte `json:",omitempty"`
+	ID       int64
+	BodySize int64 `json:",omitempty"`
+}
+
+type response struct {
+	Time          *time.Time `json:",omitempty"`
+	Err           string     `json:",omitempty"`
+	DiskPath      string     `json:",omitempty"`
+	KnownCommands []string   `json:",omitempty"`
+	OutputID      []byte     `json:",omitempty"`
+	ID            int64
+	Size          int64 `json:",omitempty"`
+	Miss          bool  `json:",omitempty"`
+}
+
+type actionEntry struct {
+	Time     time.Time
+	OutputID string
+	Size     int64
+}
+
+type server struct {
+	client *nim.Client
+	enc    *json.Encoder
+	mu     sync.Mutex
+}
+
+// Serve speaks the protocol over r and w until the go command sends "close"
+// or closes r. Requests are handled concurrently and answered as they
+// complete.
+func Serve(client *nim.Client, r io.Reader, w io.Writer) error {
+	s := &server{client: client, enc: json.NewEncoder(w)}
+	if err := s.reply(&response{KnownCommands: []string{cmdGet, cmdPut, cmdClose}}); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	dec := json.NewDecoder(r)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var body []byte
+		if req.Command == cmdPut && req.BodySize > 0 {
+			if err := dec.Decode(&body); err != nil {
+				return fmt.Errorf("%w: body of request %d: %w", ErrProtocol, req.ID, err)
+			}
+			if int64(len(body)) != req.BodySize {
+				return fmt.Errorf("%w: request %d body has %d bytes, want %d", ErrProtocol, req.ID, len(body), req.BodySize)
+			}
+		}
+
+		if req.Command == cmdClose {
+			wg.Wait()
+			return s.reply(&response{ID: req.ID})
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = s.reply(s.handle(&req, body))
+		}()
+	}
+}
+
+func (s *server) handle(req *request, body []byte) *response {
+	var (
+		resp *response
+		err  error
+	)
+	switch req.Command {
+	case cmdGet:
+		resp, err = s.get(req.ActionID)
+	case cmdPut:
+		resp, err = s.put(req.ActionID, req.OutputID, body)
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnknownCommand, req.Command)
+	}
+	if err != nil {
+		resp = &response{Err: err.Error()}
+	}
+	resp.ID = req.ID
+	return resp
+}
+
+func (s *server) get(actionID []byte) (*response, error) {
+	var action actionEntry
+	ok, err := s.client.Get(actionKeyPrefix+hex.EncodeToString(actionID), &action)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &response{Miss: true}, nil
+	}
+
+	outputKey := outputKeyPrefix + action.OutputID
+	exists, err := s.client.Exists(outputKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return &response{Miss: true}, nil
+	}
+	outputID, err := hex.DecodeString(action.OutputID)
+	if err != nil {
+		return &response{Miss: true}, nil
+	}
+
+	diskPath, err := s.diskPath(outputKey)
+	if err != nil {
+		return nil, err
+	}
+	return &response{
+		OutputID: outputID,
+		Size:     action.Size,
+		Time:     &action.Time,
+		DiskPath: diskPath,
+	}, nil
+}
+
+func (s *server) put(actionID, outputID, body []byte) (*response, error) {
+	outputKey := outputKeyPrefix + hex.EncodeToString(outputID)
+
+	// Output IDs are content hashes, so a stored body of the same size is
+	// the same body.
+	info, ok, err := s.client.Stat(outputKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || info.Size != int64(len(body)) {
+		if err := s.client.Set(outputKey, body, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	action := actionEntry{Time: time.Now(), OutputID: hex.EncodeToString(outputID), Size: int64(len(body))}
+	if err := s.client.Set(actionKeyPrefix+hex.EncodeToString(actionID), action, 0); err != nil {
+		return nil, err
+	}
+
+	diskPath, err := s.diskPath(outputKey)
+	if err != nil {
+		return nil, err
+	}
+	return &response{DiskPath: diskPath}, nil
+}
+
+func (s *server) diskPath(key string) (string, error) {
+	path, err := s.client.DiskPath(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+func (s *server) reply(resp *response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(resp)
+}
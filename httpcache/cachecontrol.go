@@ -0,0 +1,129 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// heuristicFraction and maxHeuristicLifetime bound the freshness derived
+	// from Last-Modified (RFC 9111 section 4.2.2).
+	heuristicFraction    = 10
+	maxHeuristicLifetime = 24 * time.Hour
+
+	// maxDeltaSeconds caps delta-seconds values (RFC 9111 section 1.2.2).
+	maxDeltaSeconds = 1<<31 - 1
+)
+
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, field := range header.Values("Cache-Control") {
+		for directive := range strings.SplitSeq(field, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns a delta-seconds directive; ok is false if it is absent or
+// malformed.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(min(n, int64(maxDeltaSeconds))) * time.Second, true
+}
+
+// heuristicStatus lists the status codes that may be cached without
+// explicit freshness information (RFC 9110 section 15.1). They are the only
+// ones stored; 206 and 304 never replace a full response.
+var heuristicStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// storable implements RFC 9111 section 3 for a private cache.
+func storable(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" || !heuristicStatus[resp.StatusCode] {
+		return false
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	respCC := parseCacheControl(resp.Header)
+	if reqCC.has("no-store") || respCC.has("no-store") {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" &&
+		!respCC.has("public") && !respCC.has("must-revalidate") && !respCC.has("s-maxage") {
+		return false
+	}
+
+	return respCC.has("max-age") || respCC.has("no-cache") || respCC.has("public") ||
+		resp.Header.Get("Expires") != "" || resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// freshnessLifetime implements RFC 9111 section 4.2.1 for a private cache.
+func freshnessLifetime(header http.Header, status int) time.Duration {
+	cc := parseCacheControl(header)
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+
+	date := parseHTTPDate(header.Get("Date"))
+	if v := header.Get("Expires"); v != "" {
+		expires := parseHTTPDate(v)
+		if expires.IsZero() || date.IsZero() {
+			// An invalid Expires means already expired.
+			return 0
+		}
+		return max(expires.Sub(date), 0)
+	}
+
+	lastModified := parseHTTPDate(header.Get("Last-Modified"))
+	if heuristicStatus[status] && !lastModified.IsZero() && !date.IsZero() && date.After(lastModified) {
+		return min(date.Sub(lastModified)/heuristicFraction, maxHeuristicLifetime)
+	}
+	return 0
+}
+
+func parseHTTPDate(v string) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
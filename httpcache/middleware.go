@@ -0,0 +1,329 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+const middlewareKeyPrefix = "httpmw::"
+
+type MiddlewareConfig struct {
+	// VaryHeaders are request headers whose values are part of the cache
+	// key. Responses that Vary on any other header are not stored.
+	VaryHeaders []string
+	// DefaultTTL applies to responses without Cache-Control or Expires
+	// freshness. Zero leaves such responses uncached.
+	DefaultTTL time.Duration
+}
+
+type middleware struct {
+	client  *nim.Client
+	next    http.Handler
+	flights *flightGroup
+	vary    []string
+	ttl     time.Duration
+}
+
+// Middleware caches complete GET responses of the wrapped handler in client,
+// keyed on host, path, query and cfg.VaryHeaders; HEAD requests are answered from
+// stored GET responses. Freshness comes from the handler's Cache-Control
+// (s-maxage, then max-age) or Expires; responses marked no-store, no-cache
+// or private, and those setting cookies, are never stored. Stored responses
+// get an ETag if the handler did not set one, and matching If-None-Match
+// requests are answered with 304. Concurrent misses for the same key wait
+// for a single handler run instead of each calling the handler.
+//
+// A miss is buffered only while it may still be stored. Once the handler's
+// status and headers rule that out, the response is a text/event-stream,
+// the handler flushes, or the body outgrows the client's MaxBytes, the
+// response is passed straight through to the client and waiting requests
+// run the handler themselves.
+func Middleware(client *nim.Client, cfg MiddlewareConfig) func(http.Handler) http.Handler {
+	vary := make([]string, len(cfg.VaryHeaders))
+	for i, name := range cfg.VaryHeaders {
+		vary[i] = http.CanonicalHeaderKey(name)
+	}
+	slices.Sort(vary)
+
+	return func(next http.Handler) http.Handler {
+		return &middleware{
+			client:  client,
+			next:    next,
+			flights: &flightGroup{flights: make(map[string]*flight)},
+			vary:    vary,
+			ttl:     cfg.DefaultTTL,
+		}
+	}
+}
+
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Authorization") != "" {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	key := m.cacheKey(r)
+	var e entry
+	if ok, err := m.client.Get(key, &e); err == nil && ok {
+		writeEntry(w, r, &e)
+		return
+	}
+	if r.Method == http.MethodHead {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	f, leader := m.flights.join(key)
+	if !leader {
+		select {
+		case <-f.done:
+		case <-r.Context().Done():
+			return
+		}
+		if f.entry != nil {
+			writeEntry(w, r, f.entry)
+			return
+		}
+		// The leader's response was not cacheable, so it may be specific
+		// to that request.
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	var stored *entry
+	finished := false
+	finish := func() {
+		if !finished {
+			finished = true
+			m.flights.finish(key, f, stored)
+		}
+	}
+	defer finish()
+
+	// Waiters need not sit out a response that is streamed to this client.
+	rec := &recorder{m: m, w: w, header: http.Header{}, status: http.StatusOK, onPass: finish}
+	m.next.ServeHTTP(rec, r)
+	rec.WriteHeader(http.StatusOK)
+	if rec.pass {
+		return
+	}
+
+	e = entry{
+		ResponseTime: time.Now(),
+		Header:       rec.header,
+		Body:         rec.body.Bytes(),
+		StatusCode:   rec.status,
+	}
+	if e.Header.Get("ETag") == "" {
+		sum := sha256.Sum256(e.Body)
+		e.Header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	if err := m.client.Set(key, e, rec.ttl); err == nil {
+		stored = &e
+	}
+	writeEntry(w, r, &e)
+}
+
+// storeTTL applies shared-cache rules: the handler has to opt in through
+// explicit freshness, or DefaultTTL has to be set.
+func (m *middleware) storeTTL(e *entry) (time.Duration, bool) {
+	if !heuristicStatus[e.StatusCode] || e.Header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(e.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return 0, false
+	}
+
+	cc := parseCacheControl(e.Header)
+	if cc.has("no-store") || cc.has("no-cache") || cc.has("private") {
+		return 0, false
+	}
+	for _, field := range e.Header.Values("Vary") {
+		for name := range strings.SplitSeq(field, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && !slices.Contains(m.vary, name) {
+				return 0, false
+			}
+		}
+	}
+
+	if ttl, ok := cc.seconds("s-maxage"); ok {
+		return ttl, ttl > 0
+	}
+	if ttl, ok := cc.seconds("max-age"); ok {
+		return ttl, ttl > 0
+	}
+	if e.Header.Get("Expires") != "" {
+		ttl := time.Until(parseHTTPDate(e.Header.Get("Expires")))
+		return ttl, ttl > 0
+	}
+	return m.ttl, m.ttl > 0
+}
+
+func (m *middleware) cacheKey(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(r.Host))
+	b.WriteString(r.URL.EscapedPath())
+	b.WriteString("?")
+	// Encode sorts parameters, so their order does not split the cache.
+	b.WriteString(r.URL.Query().Encode())
+	for _, name := range m.vary {
+		b.WriteString("\n" + name + ": " + strings.Join(r.Header.Values(name), ","))
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return middlewareKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func writeEntry(w http.ResponseWriter, r *http.Request, e *entry) {
+	header := w.Header()
+	for name, values := range e.Header {
+		header[name] = slices.Clone(values)
+	}
+	if !e.ResponseTime.IsZero() {
+		header.Set("Age", strconv.FormatInt(int64(time.Since(e.ResponseTime)/time.Second), 10))
+	}
+
+	if etag := e.Header.Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.StatusCode)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.Body)
+	}
+}
+
+// etagMatches implements the weak comparison used for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder buffers a response for the cache. When WriteHeader finds the
+// response cannot be stored, the body grows too large to store, or the
+// handler flushes, it hands the response over to w and writes through from
+// then on.
+type recorder struct {
+	m      *middleware
+	w      http.ResponseWriter
+	header http.Header
+	onPass func()
+	body   bytes.Buffer
+	status int
+	ttl    time.Duration
+	wrote  bool
+	pass   bool
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.wrote {
+		return
+	}
+	r.wrote = true
+	r.status = status
+
+	ttl, ok := r.m.storeTTL(&entry{Header: r.header, StatusCode: status})
+	if !ok {
+		r.passThrough()
+		return
+	}
+	r.ttl = ttl
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if !r.pass && r.body.Len()+len(b) > r.m.client.MaxBytes() {
+		// The client would refuse the entry, so there is no point in holding
+		// the body.
+		r.passThrough()
+	}
+	if r.pass {
+		return r.w.Write(b)
+	}
+	return r.body.Write(b)
+}
+
+// Flush streams the response, so it is never stored.
+func (r *recorder) Flush() {
+	r.WriteHeader(http.StatusOK)
+	if !r.pass {
+		r.passThrough()
+	}
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *recorder) passThrough() {
+	r.pass = true
+	header := r.w.Header()
+	for name, values := range r.header {
+		header[name] = values
+	}
+	r.w.WriteHeader(r.status)
+	if r.body.Len() > 0 {
+		_, _ = r.w.Write(r.body.Bytes())
+		r.body.Reset()
+	}
+	r.onPass()
+}
+
+type flightGroup struct {
+	flights map[string]*flight
+	mu      sync.Mutex
+}
+
+type flight struct {
+	done  chan struct{}
+	entry *entry
+}
+
+// join returns the flight for key and whether the caller leads it.
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+// finish publishes the leader's stored entry, nil if nothing was stored.
+func (g *flightGroup) finish(key string, f *flight, e *entry) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+
+	f.entry = e
+	close(f.done)
+}
@@ -0,0 +1,325 @@
+// Package httpcache provides an http.RoundTripper that caches responses in a
+// nim cache, following the RFC 9111 rules for a private cache. Because the
+// cache is on disk, cached responses survive restarts of the process.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+const (
+	// XFromCache is set on responses served from the cache, including ones
+	// revalidated with the origin.
+	XFromCache = "X-From-Cache"
+
+	keyPrefix = "httpcache::"
+
+	// staleRetention is how long a response with validators is kept after
+	// it goes stale, so it can still be revalidated instead of refetched.
+	staleRetention = 24 * time.Hour
+)
+
+// Transport caches GET responses in a nim.Client. Responses are stored under
+// a hash of the request URL, one variant per URL; a request whose Vary
+// headers differ from the stored variant is a miss and replaces it.
+// Successful unsafe requests invalidate the stored response for their URL.
+// A response is stored once the caller has read its body to the end; bodies
+// larger than the client's MaxBytes are passed through uncached. Cache read
+// and write failures are ignored and the request goes to the origin.
+type Transport struct {
+	client *nim.Client
+	next   http.RoundTripper
+}
+
+type entry struct {
+	RequestTime  time.Time
+	ResponseTime time.Time
+	Header       http.Header
+	VaryHeader   http.Header
+	Body         []byte
+	StatusCode   int
+}
+
+// NewTransport returns a Transport storing responses in client and sending
+// requests through next, or http.DefaultTransport when next is nil.
+func NewTransport(client *nim.Client, next http.RoundTripper) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{client: client, next: next}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req.URL.String())
+	if req.Method != http.MethodGet {
+		return t.roundTripUncached(req, key)
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if len(reqCC) == 0 && strings.EqualFold(req.Header.Get("Pragma"), "no-cache") {
+		reqCC["no-cache"] = ""
+	}
+
+	cached, ok := t.load(key, req)
+	outReq := req
+	if ok {
+		now := time.Now()
+		if cached.fresh(reqCC, now) {
+			return cached.response(req, now), nil
+		}
+		if outReq, ok = cached.conditional(req); !ok {
+			outReq = req
+		}
+	} else if reqCC.has("only-if-cached") {
+		return gatewayTimeout(req), nil
+	}
+
+	requestTime := time.Now()
+	resp, err := t.next.RoundTrip(outReq)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		cached.update(resp.Header, requestTime, responseTime)
+		t.store(key, cached)
+		return cached.response(req, time.Now()), nil
+	}
+
+	// A body the client could not store is not worth buffering.
+	maxBytes := t.client.MaxBytes()
+	if !storable(req, resp) || resp.ContentLength > int64(maxBytes) {
+		return resp, nil
+	}
+
+	e := &entry{
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Header:       resp.Header.Clone(),
+		VaryHeader:   varyHeader(req, resp.Header),
+		StatusCode:   resp.StatusCode,
+	}
+	resp.Body = &cachingBody{ReadCloser: resp.Body, limit: maxBytes, store: func(body []byte) {
+		e.Body = body
+		t.store(key, e)
+	}}
+	return resp, nil
+}
+
+// cachingBody copies a response body as the caller reads it and stores the
+// copy once the body has been read to the end. Copying stops, and nothing
+// is stored, once the body grows past limit or is closed early.
+type cachingBody struct {
+	io.ReadCloser
+	store func(body []byte)
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.store == nil {
+		return n, err
+	}
+	if b.buf.Len()+n > b.limit {
+		b.store, b.buf = nil, bytes.Buffer{}
+		return n, err
+	}
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.store(b.buf.Bytes())
+		b.store = nil
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	b.store = nil
+	return b.ReadCloser.Close()
+}
+
+// roundTripUncached forwards requests other than GET. A successful unsafe
+// request invalidates the stored response for its URL (RFC 9111 section 4.4).
+func (t *Transport) roundTripUncached(req *http.Request, key string) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.Method {
+	case http.MethodHead, http.MethodOptions, http.MethodTrace:
+	default:
+		if resp.StatusCode < http.StatusBadRequest {
+			_ = t.client.Remove(key)
+		}
+	}
+	return resp, nil
+}
+
+func (t *Transport) load(key string, req *http.Request) (*entry, bool) {
+	var e entry
+	ok, err := t.client.Get(key, &e)
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	for name, values := range e.VaryHeader {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(values, ",") {
+			return nil, false
+		}
+	}
+	return &e, true
+}
+
+// store keeps e while it is fresh, and for staleRetention longer when it
+// can be revalidated.
+func (t *Transport) store(key string, e *entry) {
+	ttl := freshnessLifetime(e.Header, e.StatusCode) - e.age(time.Now())
+	if e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != "" {
+		ttl = max(ttl, 0) + staleRetention
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	_ = t.client.Set(key, *e, ttl)
+}
+
+// age implements RFC 9111 section 4.2.3.
+func (e *entry) age(now time.Time) time.Duration {
+	apparent := time.Duration(0)
+	if date := parseHTTPDate(e.Header.Get("Date")); !date.IsZero() {
+		apparent = max(e.ResponseTime.Sub(date), 0)
+	}
+
+	var ageValue time.Duration
+	if secs, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && secs > 0 {
+		ageValue = time.Duration(secs) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+
+	return max(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+// fresh reports whether e may be served without contacting the origin,
+// taking the request's Cache-Control directives into account.
+func (e *entry) fresh(reqCC cacheControl, now time.Time) bool {
+	respCC := parseCacheControl(e.Header)
+	if reqCC.has("no-cache") || respCC.has("no-cache") {
+		return false
+	}
+
+	age := e.age(now)
+	lifetime := freshnessLifetime(e.Header, e.StatusCode)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+	if lifetime > age {
+		return true
+	}
+
+	if !reqCC.has("max-stale") || respCC.has("must-revalidate") {
+		return false
+	}
+	maxStale, ok := reqCC.seconds("max-stale")
+	return !ok || age-lifetime <= maxStale
+}
+
+// conditional returns a copy of req that validates e with the origin, or
+// false if e carries no validators.
+func (e *entry) conditional(req *http.Request) (*http.Request, bool) {
+	etag := e.Header.Get("ETag")
+	lastModified := e.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return nil, false
+	}
+
+	out := req.Clone(req.Context())
+	if etag != "" && out.Header.Get("If-None-Match") == "" {
+		out.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" && out.Header.Get("If-Modified-Since") == "" {
+		out.Header.Set("If-Modified-Since", lastModified)
+	}
+	return out, true
+}
+
+// update applies the header fields of a 304 response (RFC 9111 section
+// 4.3.4).
+func (e *entry) update(header http.Header, requestTime, responseTime time.Time) {
+	for name, values := range header {
+		if name == "Content-Length" {
+			continue
+		}
+		e.Header[name] = values
+	}
+	e.RequestTime = requestTime
+	e.ResponseTime = responseTime
+}
+
+func (e *entry) response(req *http.Request, now time.Time) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	header.Set(XFromCache, "1")
+
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     strconv.Itoa(http.StatusGatewayTimeout) + " " + http.StatusText(http.StatusGatewayTimeout),
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+func varyHeader(req *http.Request, header http.Header) http.Header {
+	vary := http.Header{}
+	for _, field := range header.Values("Vary") {
+		for name := range strings.SplitSeq(field, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" {
+				vary[name] = req.Header.Values(name)
+			}
+		}
+	}
+	return vary
+}
+
+// cacheKey hashes the URL, which may contain characters that are not safe
+// in a nim key.
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return keyPrefix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,108 @@
+// Package connserver runs the accept loop shared by the resp and memcached
+// servers: it tracks listeners and connections so that Close can stop them
+// all and wait for their handlers.
+package connserver
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+type Server struct {
+	handle    func(net.Conn)
+	errClosed error
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	closed    bool
+}
+
+// New returns a Server running handle in its own goroutine for every
+// accepted connection. Serve returns errClosed once Close has been called.
+func New(handle func(net.Conn), errClosed error) *Server {
+	return &Server{
+		handle:    handle,
+		errClosed: errClosed,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		return s.errClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return s.errClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			_ = conn.Close()
+			return s.errClosed
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(nil, conn)
+			s.handle(conn)
+		}()
+	}
+}
+
+// Close stops all listeners, closes open connections and waits for their
+// handlers to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var errs []error
+	for l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *Server) track(l net.Listener, conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	}
+	if conn != nil {
+		s.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.listeners, l)
+	delete(s.conns, conn)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
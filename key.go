@@ -1,23 +1,31 @@
 package nim
 
 import (
+	"os"
 	"slices"
 	"strings"
 )
 
+// ValidateKey reports whether key can be stored. Segments map to directory
+// names, so "." and ".." and segments holding a path separator are refused.
 func ValidateKey(key string) error {
 	if key == "" {
 		return ErrCacheKeyEmpty
 	}
 
-	if !strings.Contains(key, "::") {
-		return nil
+	if key == internalDirName || strings.HasPrefix(key, internalDirName+"::") {
+		return ErrCacheKeyReserved
 	}
 
 	parts := strings.Split(key, "::")
 	if slices.Contains(parts, "") {
 		return ErrCacheKeyEmptySegment
 	}
+	for _, part := range parts {
+		if part == "." || part == ".." || strings.ContainsRune(part, '/') || strings.ContainsRune(part, os.PathSeparator) {
+			return ErrCacheKeyInvalidSegment
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,206 @@
+package nim
+
+import (
+	"bytes"
+	"container/list"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// l1Cache is a bounded LRU of recently read payloads kept in front of the
+// backend. Entries carry the stored expiry and the version of the entry they
+// were read from; with verify enabled every hit is checked against the
+// backend so writes from other processes are never masked.
+type l1Cache struct {
+	entries map[string]*list.Element
+	order   *list.List
+	log     *slog.Logger
+	events  *eventHub
+	gen     uint64
+	max     int
+	mu      sync.Mutex
+	verify  bool
+}
+
+type l1Entry struct {
+	expiry  time.Time
+	key     string
+	data    []byte
+	version uint64
+}
+
+func newL1Cache(maxEntries int, verify bool, log *slog.Logger, events *eventHub) *l1Cache {
+	if maxEntries <= 0 {
+		return nil
+	}
+	return &l1Cache{
+		entries: make(map[string]*list.Element, maxEntries),
+		order:   list.New(),
+		log:     log,
+		events:  events,
+		max:     maxEntries,
+		verify:  verify,
+	}
+}
+
+func (m *l1Cache) get(backend Backend, key string) ([]byte, EntryInfo, bool) {
+	e, ok := m.valid(backend, key)
+	if !ok {
+		return nil, EntryInfo{}, false
+	}
+	return bytes.Clone(e.data), e.info(), true
+}
+
+func (m *l1Cache) stat(backend Backend, key string) (EntryInfo, bool) {
+	e, ok := m.valid(backend, key)
+	return e.info(), ok
+}
+
+func (m *l1Cache) valid(backend Backend, key string) (l1Entry, bool) {
+	if m == nil {
+		return l1Entry{}, false
+	}
+
+	e, ok := m.lookup(key)
+	if !ok {
+		return l1Entry{}, false
+	}
+	if m.verify {
+		info, ok, err := backend.Stat(key)
+		if err != nil || !ok || info.Version != e.version {
+			m.drop(key, e.version)
+			return l1Entry{}, false
+		}
+	}
+
+	return e, true
+}
+
+func (e l1Entry) info() EntryInfo {
+	return EntryInfo{Expiry: e.expiry, Size: int64(len(e.data)), Version: e.version}
+}
+
+func (m *l1Cache) lookup(key string) (l1Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return l1Entry{}, false
+	}
+	e, _ := elem.Value.(*l1Entry)
+	if !e.expiry.IsZero() && time.Now().After(e.expiry) {
+		m.order.Remove(elem)
+		delete(m.entries, key)
+		return l1Entry{}, false
+	}
+
+	m.order.MoveToFront(elem)
+	return *e, true
+}
+
+// generation must be read before the disk read whose result is passed to add,
+// so that fills racing with an invalidation are discarded.
+func (m *l1Cache) generation() uint64 {
+	if m == nil {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gen
+}
+
+func (m *l1Cache) add(key string, data []byte, info EntryInfo, gen uint64) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if gen != m.gen {
+		return
+	}
+
+	e := &l1Entry{expiry: info.Expiry, key: key, data: bytes.Clone(data), version: info.Version}
+	if elem, ok := m.entries[key]; ok {
+		elem.Value = e
+		m.order.MoveToFront(elem)
+		return
+	}
+
+	m.entries[key] = m.order.PushFront(e)
+	for m.order.Len() > m.max {
+		oldest := m.order.Back()
+		evicted, _ := oldest.Value.(*l1Entry)
+		m.order.Remove(oldest)
+		delete(m.entries, evicted.key)
+		m.log.Debug("evicted from memory tier", "key", evicted.key)
+		m.events.emit(EventEvict, ReasonCapacity, evicted.key, time.Time{})
+	}
+}
+
+func (m *l1Cache) drop(key string, version uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return
+	}
+	if e, _ := elem.Value.(*l1Entry); e.version == version {
+		m.order.Remove(elem)
+		delete(m.entries, key)
+	}
+}
+
+func (m *l1Cache) invalidate(key string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gen++
+	if elem, ok := m.entries[key]; ok {
+		m.order.Remove(elem)
+		delete(m.entries, key)
+	}
+}
+
+// invalidateTree drops key and every key nested below it, matching the
+// recursive semantics of Client.Remove.
+func (m *l1Cache) invalidateTree(key string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gen++
+	prefix := key + "::"
+	for k, elem := range m.entries {
+		if k == key || strings.HasPrefix(k, prefix) {
+			m.order.Remove(elem)
+			delete(m.entries, k)
+		}
+	}
+}
+
+func (m *l1Cache) clear() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gen++
+	clear(m.entries)
+	m.order.Init()
+}
@@ -0,0 +1,283 @@
+package nim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// KeyLock is an exclusive, cross-process lock on a single key. It shares the
+// lock used by Set and Remove, so writes to the key from any process block
+// while it is held — including writes from the holder itself.
+type KeyLock struct {
+	lock Unlocker
+	key  string
+}
+
+// fileLease is the file backend's KeyLock: the lock file additionally records
+// the holder's PID and lease so other processes can detect a stale holder.
+type fileLease struct {
+	lock  *keyLock
+	path  string
+	lease time.Duration
+}
+
+type lockHolder struct {
+	expiry int64
+	pid    int
+}
+
+// Lock blocks until the lock on key is acquired or ctx is done. With the file
+// backend a holder whose process no longer exists, or whose lease
+// (Config.LockLease) has expired, is considered stale and its lock is broken.
+func (c *Client) Lock(ctx context.Context, key string) (_ *KeyLock, err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpLock, key)
+	defer sp.end(&err)
+
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	backoff := lockRetryMin
+	for {
+		l, ok, err := c.tryKeyLock(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c.waited(OpLock, key, start)
+			return l, nil
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, lockRetryMax)
+	}
+}
+
+func (c *Client) TryLock(key string) (*KeyLock, bool, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, false, err
+	}
+	return c.tryKeyLock(key)
+}
+
+func (l *KeyLock) Key() string {
+	return l.key
+}
+
+// Refresh extends the lease by Config.LockLease. It fails with
+// ErrCacheLockLost if the lock was broken by another process. Locks from
+// backends other than the file backend carry no lease.
+func (l *KeyLock) Refresh() error {
+	if l.lock == nil {
+		return ErrCacheLockLost
+	}
+
+	lease, ok := l.lock.(*fileLease)
+	if !ok {
+		return nil
+	}
+	return lease.refresh(l.key)
+}
+
+func (l *KeyLock) Unlock() error {
+	if l.lock == nil {
+		return nil
+	}
+
+	err := l.lock.Unlock()
+	l.lock = nil
+	return err
+}
+
+func (c *Client) tryKeyLock(key string) (*KeyLock, bool, error) {
+	var (
+		lock Unlocker
+		ok   bool
+		err  error
+	)
+	if c.files != nil {
+		lock, ok, err = c.files.tryLease(key)
+	} else {
+		lock, ok, err = c.backend.TryLock(key)
+	}
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &KeyLock{lock: lock, key: key}, true, nil
+}
+
+func (f *fileBackend) tryLease(key string) (*fileLease, bool, error) {
+	dirPath, err := f.keyDir(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dirPath), 0o755); err != nil {
+		return nil, false, err
+	}
+
+	lockPath := f.lockPath(dirPath)
+	lock, ok, err := f.locks.tryLock(lockPath, dirPath, func() (bool, error) {
+		return breakStaleLock(lockPath)
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	l := &fileLease{lock: lock, path: lockPath, lease: f.lockLease}
+	if err := l.writeHolder(); err != nil {
+		_ = lock.unlock()
+		return nil, false, err
+	}
+	return l, true, nil
+}
+
+func (l *fileLease) refresh(key string) error {
+	held, err := l.lock.file.Stat()
+	if err != nil {
+		return err
+	}
+	current, err := os.Stat(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrCacheLockLost, key)
+		}
+		return err
+	}
+	if !os.SameFile(held, current) {
+		return fmt.Errorf("%w: %s", ErrCacheLockLost, key)
+	}
+
+	return l.writeHolder()
+}
+
+func (l *fileLease) Unlock() error {
+	_ = l.lock.file.Truncate(0)
+	return l.lock.unlock()
+}
+
+func (l *fileLease) writeHolder() error {
+	var expiry int64
+	if l.lease > 0 {
+		expiry = time.Now().Add(l.lease).UnixNano()
+	}
+
+	record := strconv.Itoa(os.Getpid()) + " " + strconv.FormatInt(expiry, 10) + "\n"
+	if err := l.lock.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.lock.file.WriteAt([]byte(record), 0)
+	return err
+}
+
+// breakStaleLock unlinks the lock file at lockPath if its recorded holder is
+// stale, so that the next acquirer creates a fresh one. Breakers serialize on
+// the parent directory and re-verify the file under that guard.
+func breakStaleLock(lockPath string) (bool, error) {
+	info, holder, err := readLockHolder(lockPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !holder.stale() {
+		return false, nil
+	}
+
+	guard, err := flockDir(filepath.Dir(lockPath))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = guard.unlock()
+	}()
+
+	current, holder, err := readLockHolder(lockPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !os.SameFile(info, current) {
+		return true, nil
+	}
+	if !holder.stale() {
+		return false, nil
+	}
+
+	if err := os.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
+
+func readLockHolder(lockPath string) (os.FileInfo, lockHolder, error) {
+	f, err := os.Open(lockPath)
+	if err != nil {
+		return nil, lockHolder{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, lockHolder{}, err
+	}
+	b, err := io.ReadAll(io.LimitReader(f, 64))
+	if err != nil {
+		return nil, lockHolder{}, err
+	}
+
+	var holder lockHolder
+	fields := strings.Fields(string(b))
+	if len(fields) == 2 {
+		holder.pid, _ = strconv.Atoi(fields[0])
+		holder.expiry, _ = strconv.ParseInt(fields[1], 10, 64)
+	}
+	return info, holder, nil
+}
+
+func (h lockHolder) stale() bool {
+	if h.pid <= 0 {
+		return false
+	}
+	if h.expiry > 0 && time.Now().UnixNano() > h.expiry {
+		return true
+	}
+	return !processAlive(h.pid)
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func flockDir(dirPath string) (*keyLock, error) {
+	f, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &keyLock{file: f}, nil
+}
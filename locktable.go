@@ -0,0 +1,152 @@
+package nim
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// lockTable resolves same-process contention on a lock file in memory before
+// the flock is taken, so goroutines of one process queue on a mutex instead
+// of in the kernel. While goroutines are queued the flock is handed over
+// between them without being released, up to maxLockHandoffs times in a row
+// so that other processes are not starved. When the flock is released for a
+// key whose directory is gone, the lock file is reclaimed.
+type lockTable struct {
+	entries map[string]*lockEntry
+	mu      sync.Mutex
+}
+
+type lockEntry struct {
+	file     *os.File
+	mu       sync.Mutex
+	refs     int
+	handoffs int
+}
+
+func newLockTable() *lockTable {
+	return &lockTable{entries: make(map[string]*lockEntry)}
+}
+
+func (t *lockTable) lock(lockPath, dirPath string) (*keyLock, error) {
+	e := t.ref(lockPath)
+	e.mu.Lock()
+	if e.file == nil {
+		fl, err := lockFile(lockPath)
+		if err != nil {
+			e.mu.Unlock()
+			t.unref(lockPath, e)
+			return nil, err
+		}
+		e.file = fl.file
+	}
+
+	return &keyLock{file: e.file, table: t, entry: e, path: lockPath, dirPath: dirPath}, nil
+}
+
+// tryLock never blocks. When the flock is held by another process, reclaim
+// is given a chance to break it before a single retry.
+func (t *lockTable) tryLock(lockPath, dirPath string, reclaim func() (bool, error)) (*keyLock, bool, error) {
+	e := t.ref(lockPath)
+	if !e.mu.TryLock() {
+		t.unref(lockPath, e)
+		return nil, false, nil
+	}
+
+	if e.file == nil {
+		fl, ok, err := tryLockFile(lockPath)
+		if err == nil && !ok && reclaim != nil {
+			var broken bool
+			broken, err = reclaim()
+			if err == nil && broken {
+				fl, ok, err = tryLockFile(lockPath)
+			}
+		}
+		if err != nil || !ok {
+			e.mu.Unlock()
+			t.unref(lockPath, e)
+			return nil, false, err
+		}
+		e.file = fl.file
+	}
+
+	return &keyLock{file: e.file, table: t, entry: e, path: lockPath, dirPath: dirPath}, true, nil
+}
+
+func (t *lockTable) unlock(l *keyLock) error {
+	e := l.entry
+
+	t.mu.Lock()
+	waiting := e.refs > 1
+	t.mu.Unlock()
+
+	var err error
+	if waiting && e.handoffs < maxLockHandoffs {
+		e.handoffs++
+	} else {
+		e.handoffs = 0
+		if l.dirPath != "" {
+			reclaimLockFile(e.file, l.path, l.dirPath)
+		}
+		err = unlockFile(e.file)
+		e.file = nil
+	}
+	e.mu.Unlock()
+
+	t.unref(l.path, e)
+	return err
+}
+
+func (t *lockTable) ref(lockPath string) *lockEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[lockPath]
+	if !ok {
+		e = &lockEntry{}
+		t.entries[lockPath] = e
+	}
+	e.refs++
+	return e
+}
+
+func (t *lockTable) unref(lockPath string, e *lockEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e.refs--
+	if e.refs > 0 {
+		return
+	}
+	// The last holder may have kept the flock for a waiter that gave up.
+	if e.file != nil {
+		_ = unlockFile(e.file)
+		e.file = nil
+	}
+	delete(t.entries, lockPath)
+}
+
+func unlockFile(f *os.File) error {
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return f.Close()
+}
+
+// reclaimLockFile unlinks the lock file of a key that no longer has a
+// directory. It runs while the flock is still held; acquirers waiting on the
+// unlinked file notice the inode change in claimLockFile and retry.
+func reclaimLockFile(f *os.File, lockPath, dirPath string) {
+	if _, err := os.Lstat(dirPath); !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	held, err := f.Stat()
+	if err != nil {
+		return
+	}
+	current, err := os.Stat(lockPath)
+	if err != nil || !os.SameFile(held, current) {
+		return
+	}
+	_ = os.Remove(lockPath)
+}
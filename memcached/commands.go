@@ -0,0 +1,457 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+const (
+	maxKeyLen = 250
+	maxLine   = 2048
+	// maxItemSize is the default memcached item size limit. Larger data
+	// blocks are skipped without being buffered.
+	maxItemSize = 1 << 20
+
+	// Exptimes above 30 days are absolute Unix timestamps.
+	maxRelativeExptime = 60 * 60 * 24 * 30
+
+	flagsHeaderLen = len(flagsMagic) + 4
+	flagsMagic     = "\x00nimf"
+)
+
+type storeMode int
+
+const (
+	modeSet storeMode = iota
+	modeAdd
+	modeReplace
+	modeCAS
+)
+
+type storeRequest struct {
+	key     string
+	data    []byte
+	ttl     time.Duration
+	cas     uint64
+	mode    storeMode
+	flags   uint32
+	expired bool
+}
+
+// dispatch runs one command line. It returns an error only when the
+// connection can no longer be used.
+func (s *Server) dispatch(r *bufio.Reader, w *bufio.Writer, line string) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		_, _ = w.WriteString("ERROR\r\n")
+		return false, nil
+	}
+
+	name, args := fields[0], fields[1:]
+	noreply := name != "get" && name != "gets" && len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+
+	var reply string
+	switch name {
+	case "get", "gets":
+		if len(args) == 0 {
+			reply = "ERROR\r\n"
+			break
+		}
+		s.get(w, args, name == "gets")
+		return false, nil
+	case "set", "add", "replace", "cas":
+		var err error
+		reply, err = s.storage(r, name, args)
+		if err != nil {
+			return false, err
+		}
+	case "delete":
+		reply = s.delete(args)
+	case "touch":
+		reply = s.touch(args)
+	case "incr", "decr":
+		reply = s.incr(args, name == "incr")
+	case "version":
+		reply = "VERSION nim\r\n"
+	case "quit":
+		return true, nil
+	default:
+		reply = "ERROR\r\n"
+	}
+
+	if !noreply {
+		_, _ = w.WriteString(reply)
+	}
+	return false, nil
+}
+
+func (s *Server) get(w *bufio.Writer, keys []string, withCAS bool) {
+	for _, key := range keys {
+		nimKey, ok := toNimKey(key)
+		if !ok {
+			continue
+		}
+		b, info, ok, err := s.client.Entry(nimKey)
+		if err != nil || !ok {
+			continue
+		}
+
+		flags, data := decodeFlags(b)
+		header := "VALUE " + key + " " + strconv.FormatUint(uint64(flags), 10) + " " + strconv.Itoa(len(data))
+		if withCAS {
+			header += " " + strconv.FormatUint(info.Version, 10)
+		}
+		_, _ = w.WriteString(header + "\r\n")
+		_, _ = w.Write(data)
+		_, _ = w.WriteString("\r\n")
+	}
+	_, _ = w.WriteString("END\r\n")
+}
+
+func (s *Server) storage(r *bufio.Reader, name string, args []string) (string, error) {
+	wantArgs := 4
+	if name == "cas" {
+		wantArgs = 5
+	}
+	if len(args) != wantArgs {
+		return clientError(errBadFormat.Error()), nil
+	}
+
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		return clientError(errBadFormat.Error()), nil
+	}
+	if size > maxItemSize {
+		if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+			return "", err
+		}
+		return serverReply(nim.ErrCacheValueTooLarge), nil
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		// Drop the rest of the oversized chunk so it is not read as a command.
+		if data[len(data)-1] != '\n' {
+			if _, err := r.ReadSlice('\n'); err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+				return "", err
+			}
+		}
+		return clientError(errBadChunk.Error()), nil
+	}
+	data = data[:size]
+
+	req := storeRequest{mode: storeModes[name]}
+	var ok bool
+	if req.key, ok = toNimKey(args[0]); !ok {
+		return clientError(errBadFormat.Error()), nil
+	}
+	flags, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return clientError(errBadFormat.Error()), nil
+	}
+	req.flags = uint32(flags)
+	if req.ttl, req.expired, ok = parseExptime(args[2]); !ok {
+		return clientError(errBadFormat.Error()), nil
+	}
+	if req.mode == modeCAS {
+		if req.cas, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			return clientError(errBadFormat.Error()), nil
+		}
+	}
+	req.data = encodeFlags(req.flags, data)
+
+	switch err := s.store(req); {
+	case err == nil:
+		return "STORED\r\n", nil
+	case errors.Is(err, errNotStored):
+		return "NOT_STORED\r\n", nil
+	case errors.Is(err, errNotFound):
+		return "NOT_FOUND\r\n", nil
+	case errors.Is(err, errCASMismatch):
+		return "EXISTS\r\n", nil
+	default:
+		return serverReply(err), nil
+	}
+}
+
+var storeModes = map[string]storeMode{
+	"set":     modeSet,
+	"add":     modeAdd,
+	"replace": modeReplace,
+	"cas":     modeCAS,
+}
+
+func (s *Server) store(req storeRequest) error {
+	if req.mode == modeSet && !req.expired {
+		return s.client.Set(req.key, req.data, req.ttl)
+	}
+
+	return s.client.Txn([]string{req.key}, func(tx *nim.Tx) error {
+		exists, err := tx.Exists(req.key)
+		if err != nil {
+			return err
+		}
+
+		switch req.mode {
+		case modeSet:
+		case modeAdd:
+			if exists {
+				return errNotStored
+			}
+		case modeReplace:
+			if !exists {
+				return errNotStored
+			}
+		case modeCAS:
+			if !exists {
+				return errNotFound
+			}
+			info, _, err := tx.Stat(req.key)
+			if err != nil {
+				return err
+			}
+			if info.Version != req.cas {
+				return errCASMismatch
+			}
+		}
+
+		// An item stored already expired is gone immediately.
+		if req.expired {
+			return tx.Remove(req.key)
+		}
+		return tx.Set(req.key, req.data, req.ttl)
+	})
+}
+
+func (s *Server) delete(args []string) string {
+	// A trailing "0" is accepted for compatibility with old clients.
+	if len(args) == 2 && args[1] == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		return clientError(errBadFormat.Error())
+	}
+	key, ok := toNimKey(args[0])
+	if !ok {
+		return clientError(errBadFormat.Error())
+	}
+
+	found, err := s.removeEntry(key)
+	switch {
+	case err != nil:
+		return serverReply(err)
+	case !found:
+		return "NOT_FOUND\r\n"
+	default:
+		return "DELETED\r\n"
+	}
+}
+
+// removeEntry deletes only key itself, unlike nim's recursive Remove.
+func (s *Server) removeEntry(key string) (bool, error) {
+	var found bool
+	err := s.client.Txn([]string{key}, func(tx *nim.Tx) error {
+		var err error
+		found, err = tx.Exists(key)
+		if err != nil || !found {
+			return err
+		}
+		return tx.Remove(key)
+	})
+	return found, err
+}
+
+func (s *Server) touch(args []string) string {
+	if len(args) != 2 {
+		return clientError(errBadFormat.Error())
+	}
+	key, ok := toNimKey(args[0])
+	if !ok {
+		return clientError(errBadFormat.Error())
+	}
+	ttl, expired, ok := parseExptime(args[1])
+	if !ok {
+		return clientError(errBadFormat.Error())
+	}
+
+	var found bool
+	var err error
+	if expired {
+		found, err = s.removeEntry(key)
+	} else {
+		found, err = s.client.Expire(key, ttl)
+	}
+	switch {
+	case err != nil:
+		return serverReply(err)
+	case !found:
+		return "NOT_FOUND\r\n"
+	default:
+		return "TOUCHED\r\n"
+	}
+}
+
+func (s *Server) incr(args []string, up bool) string {
+	if len(args) != 2 {
+		return clientError(errBadFormat.Error())
+	}
+	key, ok := toNimKey(args[0])
+	if !ok {
+		return clientError(errBadFormat.Error())
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return clientError("invalid numeric delta argument")
+	}
+
+	n, err := s.increment(key, delta, up)
+	switch {
+	case errors.Is(err, errNotFound):
+		return "NOT_FOUND\r\n"
+	case errors.Is(err, errNonNumeric):
+		return clientError(err.Error())
+	case err != nil:
+		return serverReply(err)
+	default:
+		return strconv.FormatUint(n, 10) + "\r\n"
+	}
+}
+
+// increment follows memcached semantics: incr wraps around at 2^64, decr
+// stops at zero. Flags and TTL of the item are kept.
+func (s *Server) increment(key string, delta uint64, up bool) (uint64, error) {
+	var result uint64
+	err := s.client.Txn([]string{key}, func(tx *nim.Tx) error {
+		var b []byte
+		ok, err := tx.Get(key, &b)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errNotFound
+		}
+
+		flags, data := decodeFlags(b)
+		current, err := strconv.ParseUint(string(bytes.TrimSpace(data)), 10, 64)
+		if err != nil {
+			return errNonNumeric
+		}
+		switch {
+		case up:
+			result = current + delta
+		case delta > current:
+			result = 0
+		default:
+			result = current - delta
+		}
+
+		info, _, err := tx.Stat(key)
+		if err != nil {
+			return err
+		}
+		var ttl time.Duration
+		if !info.Expiry.IsZero() {
+			ttl = max(time.Until(info.Expiry), time.Nanosecond)
+		}
+		return tx.Set(key, encodeFlags(flags, []byte(strconv.FormatUint(result, 10))), ttl)
+	})
+	return result, err
+}
+
+// parseExptime maps a memcached exptime to a nim TTL: 0 means no expiry,
+// values up to 30 days are relative seconds, larger values are absolute Unix
+// times and negative values expire immediately.
+func parseExptime(v string) (ttl time.Duration, expired, ok bool) {
+	exptime, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false, false
+	}
+
+	switch {
+	case exptime == 0:
+		return 0, false, true
+	case exptime < 0:
+		return 0, true, true
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second, false, true
+	case exptime > math.MaxInt64/int64(time.Second):
+		return 0, false, false
+	default:
+		ttl := time.Until(time.Unix(exptime, 0))
+		return ttl, ttl <= 0, true
+	}
+}
+
+func encodeFlags(flags uint32, data []byte) []byte {
+	if flags == 0 {
+		return data
+	}
+
+	b := make([]byte, flagsHeaderLen, flagsHeaderLen+len(data))
+	copy(b, flagsMagic)
+	binary.BigEndian.PutUint32(b[len(flagsMagic):], flags)
+	return append(b, data...)
+}
+
+func decodeFlags(b []byte) (uint32, []byte) {
+	if len(b) < flagsHeaderLen || string(b[:len(flagsMagic)]) != flagsMagic {
+		return 0, b
+	}
+	return binary.BigEndian.Uint32(b[len(flagsMagic):flagsHeaderLen]), b[flagsHeaderLen:]
+}
+
+func toNimKey(key string) (string, bool) {
+	if key == "" || len(key) > maxKeyLen {
+		return "", false
+	}
+	for i := range len(key) {
+		if key[i] < ' ' || key[i] == 0x7f {
+			return "", false
+		}
+	}
+
+	nimKey := strings.ReplaceAll(key, ":", "::")
+	if nim.ValidateKey(nimKey) != nil {
+		return "", false
+	}
+	return nimKey, true
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", errLineTooLong
+		}
+		return "", err
+	}
+	if len(line) > maxLine {
+		return "", errLineTooLong
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func clientError(msg string) string {
+	return "CLIENT_ERROR " + msg + "\r\n"
+}
+
+func serverReply(err error) string {
+	if errors.Is(err, nim.ErrCacheValueTooLarge) {
+		return "SERVER_ERROR object too large for cache\r\n"
+	}
+	return "SERVER_ERROR " + strings.ReplaceAll(err.Error(), "\r\n", " ") + "\r\n"
+}
@@ -0,0 +1,15 @@
+package memcached
+
+import "errors"
+
+var (
+	ErrServerClosed = errors.New("memcached server closed")
+
+	errLineTooLong = errors.New("line too long")
+	errBadFormat   = errors.New("bad command line format")
+	errBadChunk    = errors.New("bad data chunk")
+	errNonNumeric  = errors.New("cannot increment or decrement non-numeric value")
+	errNotFound    = errors.New("not found")
+	errNotStored   = errors.New("not stored")
+	errCASMismatch = errors.New("cas mismatch")
+)
@@ -0,0 +1,76 @@
+// Package memcached serves a nim cache over the memcached text protocol.
+//
+// Supported commands are get, gets, set, add, replace, cas, delete, touch,
+// incr, decr, version and quit. CAS uniques are nim entry versions. Keys use
+// ":" as a namespace separator and are stored under nim's "::" segments, so
+// "user:1" becomes "user::1"; delete removes only the named entry.
+//
+// nim has no slot for client flags, so values stored with non-zero flags are
+// prefixed with a small header that nim clients reading the same key see.
+// Values stored with flags 0 are stored as is.
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"net"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/internal/connserver"
+)
+
+type Server struct {
+	client *nim.Client
+	conns  *connserver.Server
+}
+
+func NewServer(client *nim.Client) *Server {
+	s := &Server{client: client}
+	s.conns = connserver.New(s.serveConn, ErrServerClosed)
+	return s
+}
+
+// Serve accepts connections on l until Close is called, after which it
+// returns ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	return s.conns.Serve(l)
+}
+
+// Close stops all listeners, closes open connections and waits for their
+// handlers to return.
+func (s *Server) Close() error {
+	return s.conns.Close()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				_, _ = w.WriteString(clientError(err.Error()))
+				_ = w.Flush()
+			}
+			return
+		}
+
+		quit, err := s.dispatch(r, w, line)
+		if err != nil {
+			return
+		}
+		// Replies to pipelined commands are flushed together.
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
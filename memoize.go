@@ -0,0 +1,163 @@
+package nim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type MemoizeConfig[K any] struct {
+	// Key derives the cache key of an argument. It must return a valid key.
+	Key func(K) string
+	// TTL applies to successful results; zero keeps them until removed.
+	TTL time.Duration
+	// ErrorTTL caches failures for this long, so a failing upstream is not
+	// called again for every request. Zero disables error caching. Context
+	// errors are never cached.
+	ErrorTTL time.Duration
+}
+
+type memoEntry[V any] struct {
+	Value  V
+	Err    string
+	Failed bool
+}
+
+type memoCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+type memoGroup[V any] struct {
+	calls map[string]*memoCall[V]
+	mu    sync.Mutex
+}
+
+// Memoize returns fn backed by c: results are stored under cfg.Key(k) and
+// served from the cache until they expire. Concurrent calls for the same key
+// within the process share a single call to fn. A cached failure is returned
+// as an error wrapping ErrCacheMemoizedError. Values are stored with the
+// same encoding as Set, so V must be gob-encodable. Cache read and write
+// failures fall back to calling fn. If fn panics, the panic propagates to
+// its caller and the calls sharing it get an error wrapping
+// ErrCacheMemoizedPanic; nothing is cached.
+func Memoize[K, V any](c *Client, cfg MemoizeConfig[K], fn func(context.Context, K) (V, error)) func(context.Context, K) (V, error) {
+	g := &memoGroup[V]{calls: make(map[string]*memoCall[V])}
+
+	return func(ctx context.Context, k K) (V, error) {
+		var zero V
+		key := cfg.Key(k)
+		if err := ValidateKey(key); err != nil {
+			return zero, err
+		}
+
+		for {
+			if v, ok, err := memoLookup[V](c, key); ok {
+				return v, err
+			}
+
+			call, leader := g.join(key)
+			if leader {
+				return memoRun(c, cfg, g, key, call, func() (V, error) {
+					return fn(ctx, k)
+				})
+			}
+
+			select {
+			case <-ctx.Done():
+				return zero, ctx.Err()
+			case <-call.done:
+			}
+			// The leader's context ended; that says nothing about ours.
+			if isContextErr(call.err) {
+				continue
+			}
+			return call.value, call.err
+		}
+	}
+}
+
+func (g *memoGroup[V]) join(key string) (*memoCall[V], bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if call, ok := g.calls[key]; ok {
+		return call, false
+	}
+	call := &memoCall[V]{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+func memoRun[K, V any](
+	c *Client,
+	cfg MemoizeConfig[K],
+	g *memoGroup[V],
+	key string,
+	call *memoCall[V],
+	fn func() (V, error),
+) (V, error) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	// Runs before done is closed, so waiters see the error.
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("%w: %v", ErrCacheMemoizedPanic, r)
+			panic(r)
+		}
+	}()
+
+	// A previous leader may have stored the result after our lookup missed.
+	if v, ok, err := memoLookup[V](c, key); ok {
+		call.value, call.err = v, err
+		return v, err
+	}
+	call.value, call.err = fn()
+	memoStore(c, cfg, key, call.value, call.err)
+	return call.value, call.err
+}
+
+func memoLookup[V any](c *Client, key string) (V, bool, error) {
+	var entry memoEntry[V]
+	found, err := c.Get(key, &entry)
+	if err != nil {
+		c.log.Warn("failed to read memoized result", "op", "memoize", "key", key, "err", err)
+	}
+	if err != nil || !found {
+		var zero V
+		return zero, false, nil
+	}
+	if entry.Failed {
+		return entry.Value, true, fmt.Errorf("%w: %s", ErrCacheMemoizedError, entry.Err)
+	}
+	return entry.Value, true, nil
+}
+
+// memoStore is best effort: a result that cannot be cached is still returned
+// to the caller.
+func memoStore[K, V any](c *Client, cfg MemoizeConfig[K], key string, value V, err error) {
+	entry := memoEntry[V]{Value: value}
+	ttl := cfg.TTL
+	if err != nil {
+		if cfg.ErrorTTL <= 0 || isContextErr(err) {
+			return
+		}
+		var zero V
+		entry = memoEntry[V]{Value: zero, Err: err.Error(), Failed: true}
+		ttl = cfg.ErrorTTL
+	}
+	if err := c.Set(key, entry, ttl); err != nil {
+		c.log.Warn("failed to store memoized result", "op", "memoize", "key", key, "err", err)
+	}
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
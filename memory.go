@@ -0,0 +1,199 @@
+package nim
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps entries in process memory. It is safe for concurrent
+// use, implements Committer and LockedDeleter, and is mainly useful for tests and for caches
+// that do not need to survive a restart or be shared between processes.
+type MemoryBackend struct {
+	entries map[string]memoryEntry
+	locks   map[string]*memoryLock
+	version uint64
+	mu      sync.Mutex
+}
+
+type memoryEntry struct {
+	expiry  time.Time
+	data    []byte
+	version uint64
+}
+
+type memoryLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+type memoryUnlocker struct {
+	backend *MemoryBackend
+	lock    *memoryLock
+	key     string
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		entries: make(map[string]memoryEntry),
+		locks:   make(map[string]*memoryLock),
+	}
+}
+
+func (m *MemoryBackend) Get(key string) ([]byte, EntryInfo, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, EntryInfo{}, false, nil
+	}
+	return bytes.Clone(e.data), e.info(), true, nil
+}
+
+func (m *MemoryBackend) Stat(key string) (EntryInfo, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return EntryInfo{}, false, nil
+	}
+	return e.info(), true, nil
+}
+
+func (m *MemoryBackend) Put(key string, data []byte, expiry time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, data, expiry)
+	return nil
+}
+
+func (m *MemoryBackend) Expire(key string, expiry time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	m.version++
+	e.expiry = expiry
+	e.version = m.version
+	m.entries[key] = e
+	return nil
+}
+
+func (m *MemoryBackend) Delete(key string) error {
+	lock, err := m.Lock(key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	return m.DeleteLocked(key)
+}
+
+func (m *MemoryBackend) DeleteLocked(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := key + "::"
+	for k := range m.entries {
+		if k == key || strings.HasPrefix(k, prefix) {
+			delete(m.entries, k)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) List(prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for k := range m.entries {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (m *MemoryBackend) Lock(key string) (Unlocker, error) {
+	l := m.refLock(key)
+	l.mu.Lock()
+	return &memoryUnlocker{backend: m, lock: l, key: key}, nil
+}
+
+func (m *MemoryBackend) TryLock(key string) (Unlocker, bool, error) {
+	l := m.refLock(key)
+	if !l.mu.TryLock() {
+		m.unrefLock(key, l)
+		return nil, false, nil
+	}
+	return &memoryUnlocker{backend: m, lock: l, key: key}, true, nil
+}
+
+func (m *MemoryBackend) Commit(ops []TxnOp) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, op := range ops {
+		if op.Remove {
+			delete(m.entries, op.Key)
+			continue
+		}
+		m.put(op.Key, op.Data, op.Expiry)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) put(key string, data []byte, expiry time.Time) {
+	m.version++
+	m.entries[key] = memoryEntry{expiry: expiry, data: bytes.Clone(data), version: m.version}
+}
+
+func (m *MemoryBackend) refLock(key string) *memoryLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.locks[key]
+	if !ok {
+		l = &memoryLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (m *MemoryBackend) unrefLock(key string, l *memoryLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, key)
+	}
+}
+
+func (u *memoryUnlocker) Unlock() error {
+	if u.lock == nil {
+		return nil
+	}
+
+	u.lock.mu.Unlock()
+	u.backend.unrefLock(u.key, u.lock)
+	u.lock = nil
+	return nil
+}
+
+func (e memoryEntry) info() EntryInfo {
+	return EntryInfo{Expiry: e.expiry, Size: int64(len(e.data)), Version: e.version}
+}
@@ -0,0 +1,37 @@
+// Package nimtest provides helpers for testing code built on nim.
+package nimtest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/server"
+)
+
+// RemoteServer is an httptest stand-in for a shared nim server. It serves
+// server.Handler over a client backed by Store.
+type RemoteServer struct {
+	*httptest.Server
+	Store *nim.MemoryBackend
+}
+
+// NewRemoteServer starts a RemoteServer that is closed when tb finishes.
+func NewRemoteServer(tb testing.TB) *RemoteServer {
+	tb.Helper()
+
+	store := nim.NewMemoryBackend()
+	client, err := nim.New(nim.Config{Backend: store})
+	if err != nil {
+		tb.Fatalf("New error=%v", err)
+	}
+
+	s := &RemoteServer{Server: httptest.NewServer(server.NewHandler(client)), Store: store}
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Tier returns a nim.HTTPTier pointed at the server.
+func (s *RemoteServer) Tier() *nim.HTTPTier {
+	return nim.NewHTTPTier(s.URL, s.Client())
+}
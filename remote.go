@@ -0,0 +1,162 @@
+package nim
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RemoteTier is a shared store consulted by Client on local misses. With
+// Config.RemoteWriteThrough set, Set, Remove and Txn are also applied to it
+// after the local write succeeds. Expiry is zero for entries without a TTL.
+type RemoteTier interface {
+	Get(key string) ([]byte, time.Time, bool, error)
+	Set(key string, data []byte, expiry time.Time) error
+	Delete(key string) error
+}
+
+// HTTPTier is a RemoteTier speaking to a nim server: entries live at
+// <base>/keys/<key>, and expiries travel in the RemoteExpiresHeader header
+// as Unix nanoseconds.
+type HTTPTier struct {
+	client  *http.Client
+	baseURL string
+	// MaxBytes bounds the payloads Get reads; larger ones are reported as
+	// missing without being read. Zero means the default Config.MaxBytes.
+	MaxBytes int
+}
+
+// NewHTTPTier returns a tier rooted at baseURL. A nil client uses
+// http.DefaultClient.
+func NewHTTPTier(baseURL string, client *http.Client) *HTTPTier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPTier{client: client, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (h *HTTPTier) Get(key string) ([]byte, time.Time, bool, error) {
+	resp, err := h.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		if resp.Header.Get(RemoteAbsentHeader) == "" {
+			return nil, time.Time{}, false, nil
+		}
+	default:
+		return nil, time.Time{}, false, remoteStatusError(http.MethodGet, key, resp)
+	}
+
+	expiry, err := parseRemoteExpiry(resp.Header.Get(RemoteExpiresHeader))
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return tombstoneValue, expiry, true, nil
+	}
+
+	maxBytes := h.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxCacheBytes
+	}
+	if resp.ContentLength > int64(maxBytes) {
+		return nil, time.Time{}, false, nil
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	if len(b) > maxBytes {
+		return nil, time.Time{}, false, nil
+	}
+	// Tombstones arrive as a 404 with RemoteAbsentHeader, never as a body.
+	if err := checkValue(b); err != nil {
+		return nil, time.Time{}, false, fmt.Errorf("%w: GET %s: %w", ErrCacheRemote, key, err)
+	}
+	return b, expiry, true, nil
+}
+
+func (h *HTTPTier) Set(key string, data []byte, expiry time.Time) error {
+	header := http.Header{}
+	if !expiry.IsZero() {
+		header.Set(RemoteExpiresHeader, strconv.FormatInt(expiry.UnixNano(), 10))
+	}
+	var body io.Reader = bytes.NewReader(data)
+	if isTombstone(data) {
+		header.Set(RemoteAbsentHeader, "1")
+		body = nil
+	}
+
+	resp, err := h.do(http.MethodPut, key, body, header)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		return remoteStatusError(http.MethodPut, key, resp)
+	}
+	return nil
+}
+
+func (h *HTTPTier) Delete(key string) error {
+	resp, err := h.do(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return remoteStatusError(http.MethodDelete, key, resp)
+	}
+	return nil
+}
+
+func (h *HTTPTier) do(method, key string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, h.baseURL+"/keys/"+url.PathEscape(key), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %s: %w", ErrCacheRemote, method, key, err)
+	}
+	return resp, nil
+}
+
+func remoteStatusError(method, key string, resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%w: %s %s: %s: %s", ErrCacheRemote, method, key, resp.Status, bytes.TrimSpace(msg))
+}
+
+func parseRemoteExpiry(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	nanos, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid %s header %q", ErrCacheRemote, RemoteExpiresHeader, v)
+	}
+	return time.Unix(0, nanos), nil
+}
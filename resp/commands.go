@@ -0,0 +1,399 @@
+package resp
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+const defaultScanCount = 10
+
+type command struct {
+	run     func(s *Server, w writer, args []string)
+	minArgs int
+	maxArgs int // -1 for variadic
+}
+
+var commands = map[string]command{
+	"get":     {run: (*Server).get, minArgs: 1, maxArgs: 1},
+	"set":     {run: (*Server).set, minArgs: 2, maxArgs: 4},
+	"del":     {run: (*Server).del, minArgs: 1, maxArgs: -1},
+	"exists":  {run: (*Server).exists, minArgs: 1, maxArgs: -1},
+	"ttl":     {run: ttlCommand(time.Second), minArgs: 1, maxArgs: 1},
+	"pttl":    {run: ttlCommand(time.Millisecond), minArgs: 1, maxArgs: 1},
+	"expire":  {run: expireCommand(time.Second), minArgs: 2, maxArgs: 2},
+	"pexpire": {run: expireCommand(time.Millisecond), minArgs: 2, maxArgs: 2},
+	"incr":    {run: incrCommand(1, false), minArgs: 1, maxArgs: 1},
+	"decr":    {run: incrCommand(-1, false), minArgs: 1, maxArgs: 1},
+	"incrby":  {run: incrCommand(1, true), minArgs: 2, maxArgs: 2},
+	"decrby":  {run: incrCommand(-1, true), minArgs: 2, maxArgs: 2},
+	"scan":    {run: (*Server).scan, minArgs: 1, maxArgs: 5},
+	"keys":    {run: (*Server).keys, minArgs: 1, maxArgs: 1},
+	"ping":    {run: ping, minArgs: 0, maxArgs: 1},
+	"echo":    {run: echo, minArgs: 1, maxArgs: 1},
+	"select":  {run: selectDB, minArgs: 1, maxArgs: 1},
+}
+
+// dispatch runs one command and reports whether the connection should close.
+func (s *Server) dispatch(w writer, raw [][]byte) bool {
+	name := strings.ToLower(string(raw[0]))
+	args := make([]string, len(raw)-1)
+	for i, arg := range raw[1:] {
+		args[i] = string(arg)
+	}
+
+	if name == "quit" {
+		w.simple("OK")
+		return true
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		w.err("ERR unknown command '" + name + "'")
+		return false
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		w.err("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+
+	cmd.run(s, w, args)
+	return false
+}
+
+func (s *Server) get(w writer, args []string) {
+	var b []byte
+	ok, err := s.client.Get(toNimKey(args[0]), &b)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if !ok {
+		w.null()
+		return
+	}
+	w.bulk(b)
+}
+
+func (s *Server) set(w writer, args []string) {
+	var ttl time.Duration
+	if len(args) > 2 {
+		if len(args) != 4 {
+			w.err("ERR syntax error")
+			return
+		}
+
+		unit := time.Second
+		switch strings.ToLower(args[2]) {
+		case "ex":
+		case "px":
+			unit = time.Millisecond
+		default:
+			w.err("ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || n <= 0 || n > math.MaxInt64/int64(unit) {
+			w.err("ERR invalid expire time in 'set' command")
+			return
+		}
+		ttl = time.Duration(n) * unit
+	}
+
+	if err := s.client.Set(toNimKey(args[0]), []byte(args[1]), ttl); err != nil {
+		writeErr(w, err)
+		return
+	}
+	w.simple("OK")
+}
+
+// del removes only the named entries, unlike nim's recursive Remove, so
+// "DEL user" leaves "user:1" in place as Redis would.
+func (s *Server) del(w writer, args []string) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = toNimKey(arg)
+	}
+
+	n, err := s.removeEntries(keys)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	w.int(n)
+}
+
+func (s *Server) removeEntries(keys []string) (int64, error) {
+	var n int64
+	err := s.client.Txn(keys, func(tx *nim.Tx) error {
+		n = 0
+		for _, key := range keys {
+			ok, err := tx.Exists(key)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			n++
+			if err := tx.Remove(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *Server) exists(w writer, args []string) {
+	var n int64
+	for _, arg := range args {
+		ok, err := s.client.Exists(toNimKey(arg))
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		if ok {
+			n++
+		}
+	}
+	w.int(n)
+}
+
+func ttlCommand(unit time.Duration) func(s *Server, w writer, args []string) {
+	return func(s *Server, w writer, args []string) {
+		info, ok, err := s.client.Stat(toNimKey(args[0]))
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			w.int(-2)
+		case info.Expiry.IsZero():
+			w.int(-1)
+		default:
+			remaining := max(time.Until(info.Expiry), 0)
+			w.int(int64((remaining + unit - 1) / unit))
+		}
+	}
+}
+
+func expireCommand(unit time.Duration) func(s *Server, w writer, args []string) {
+	return func(s *Server, w writer, args []string) {
+		key := toNimKey(args[0])
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n > math.MaxInt64/int64(unit) {
+			w.err("ERR value is not an integer or out of range")
+			return
+		}
+
+		if n <= 0 {
+			removed, err := s.removeEntries([]string{key})
+			if err != nil {
+				writeErr(w, err)
+				return
+			}
+			w.int(removed)
+			return
+		}
+
+		ok, err := s.client.Expire(key, time.Duration(n)*unit)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.int(boolInt(ok))
+	}
+}
+
+func incrCommand(sign int64, withArg bool) func(s *Server, w writer, args []string) {
+	return func(s *Server, w writer, args []string) {
+		delta := sign
+		if withArg {
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || n == math.MinInt64 {
+				w.err("ERR value is not an integer or out of range")
+				return
+			}
+			delta = sign * n
+		}
+
+		n, err := s.increment(toNimKey(args[0]), delta)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.int(n)
+	}
+}
+
+// increment updates the counter in a transaction so concurrent increments
+// from any process are not lost. An existing TTL is kept.
+func (s *Server) increment(key string, delta int64) (int64, error) {
+	var result int64
+	err := s.client.Txn([]string{key}, func(tx *nim.Tx) error {
+		var b []byte
+		ok, err := tx.Get(key, &b)
+		if err != nil {
+			return err
+		}
+
+		var current int64
+		var ttl time.Duration
+		if ok {
+			current, err = strconv.ParseInt(string(b), 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			info, _, err := tx.Stat(key)
+			if err != nil {
+				return err
+			}
+			if !info.Expiry.IsZero() {
+				ttl = max(time.Until(info.Expiry), time.Nanosecond)
+			}
+		}
+
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			return errOverflow
+		}
+		result = current + delta
+		return tx.Set(key, strconv.FormatInt(result, 10), ttl)
+	})
+	return result, err
+}
+
+// scan pages through the sorted key list; the cursor is the offset of the
+// next key.
+func (s *Server) scan(w writer, args []string) {
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		w.err("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", defaultScanCount
+	opts := args[1:]
+	for len(opts) > 0 {
+		if len(opts) < 2 {
+			w.err("ERR syntax error")
+			return
+		}
+		switch strings.ToLower(opts[0]) {
+		case "match":
+			pattern = opts[1]
+		case "count":
+			count, err = strconv.Atoi(opts[1])
+			if err != nil || count < 1 {
+				w.err("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.err("ERR syntax error")
+			return
+		}
+		opts = opts[2:]
+	}
+
+	keys, err := s.matchKeys(pattern)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	start := min(cursor, len(keys))
+	end := min(start+count, len(keys))
+	next := end
+	if end == len(keys) {
+		next = 0
+	}
+
+	_, _ = w.WriteString("*2\r\n")
+	w.bulk([]byte(strconv.Itoa(next)))
+	w.array(keys[start:end])
+}
+
+func (s *Server) keys(w writer, args []string) {
+	keys, err := s.matchKeys(args[0])
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	w.array(keys)
+}
+
+// matchKeys lists keys under the literal prefix of pattern and filters them
+// with the glob, returning Redis-style keys.
+func (s *Server) matchKeys(pattern string) ([]string, error) {
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	keys, err := s.client.Keys(toNimKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = fromNimKey(key)
+		if globMatch(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+	return matched, nil
+}
+
+func ping(_ *Server, w writer, args []string) {
+	if len(args) == 1 {
+		w.bulk([]byte(args[0]))
+		return
+	}
+	w.simple("PONG")
+}
+
+func echo(_ *Server, w writer, args []string) {
+	w.bulk([]byte(args[0]))
+}
+
+// selectDB accepts only database 0; a nim root has a single keyspace.
+func selectDB(_ *Server, w writer, args []string) {
+	if args[0] != "0" {
+		w.err("ERR DB index is out of range")
+		return
+	}
+	w.simple("OK")
+}
+
+func writeErr(w writer, err error) {
+	switch {
+	case errors.Is(err, errNotInteger), errors.Is(err, errOverflow):
+		w.err("ERR " + err.Error())
+	case errors.Is(err, nim.ErrCacheKeyEmpty),
+		errors.Is(err, nim.ErrCacheKeyEmptySegment),
+		errors.Is(err, nim.ErrCacheKeyInvalidSegment),
+		errors.Is(err, nim.ErrCacheKeyReserved):
+		w.err("ERR invalid key: " + err.Error())
+	default:
+		w.err("ERR " + err.Error())
+	}
+}
+
+func toNimKey(key string) string {
+	return strings.ReplaceAll(key, ":", "::")
+}
+
+func fromNimKey(key string) string {
+	return strings.ReplaceAll(key, "::", ":")
+}
+
+func boolInt(ok bool) int64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,11 @@
+package resp
+
+import "errors"
+
+var (
+	ErrProtocol     = errors.New("resp protocol error")
+	ErrServerClosed = errors.New("resp server closed")
+
+	errNotInteger = errors.New("value is not an integer or out of range")
+	errOverflow   = errors.New("increment or decrement would overflow")
+)
@@ -0,0 +1,81 @@
+package resp
+
+// globMatch implements Redis glob patterns: *, ?, [abc], [^a-z] and
+// backslash escapes. On a mismatch it only retries from the last *, so
+// patterns with many stars take O(len(pattern)*len(s)) time.
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, retry := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, retry = p, i
+				p++
+				continue
+			case '?':
+				p, i = p+1, i+1
+				continue
+			case '[':
+				if rest, ok := matchClass(pattern[p+1:], s[i]); ok {
+					p, i = len(pattern)-len(rest), i+1
+					continue
+				}
+			default:
+				c, width := pattern[p], 1
+				if c == '\\' && p+1 < len(pattern) {
+					c, width = pattern[p+1], 2
+				}
+				if c == s[i] {
+					p, i = p+width, i+1
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// Let the last * absorb one more byte and try again.
+		retry++
+		p, i = star+1, retry
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class body following '[' and returns the
+// pattern after the closing ']'.
+func matchClass(pattern string, c byte) (string, bool) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		lo := pattern[0]
+		if lo == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			lo = pattern[0]
+		}
+		pattern = pattern[1:]
+
+		hi := lo
+		if len(pattern) > 1 && pattern[0] == '-' && pattern[1] != ']' {
+			hi = pattern[1]
+			pattern = pattern[2:]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return pattern, matched != negate
+}
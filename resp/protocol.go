@@ -0,0 +1,128 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/brownhounds/nim"
+)
+
+const (
+	maxBulkLen  = 512 << 20
+	maxArrayLen = 1 << 20
+
+	// commandHeadroom is what the arguments of a command may hold beyond
+	// the largest value, for the key and options.
+	commandHeadroom = 1 << 20
+)
+
+// readCommand reads one command, either as a RESP array of bulk strings or
+// as an inline command line as typed into telnet. A bulk string longer than
+// maxBytes, or one that takes the command past maxBytes plus
+// commandHeadroom, is skipped without being buffered; the rest of the
+// command is still consumed and nim.ErrCacheValueTooLarge returned.
+func readCommand(r *bufio.Reader, maxBytes int) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArrayLen {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+
+	args := make([][]byte, 0, max(n, 0))
+	budget := maxBytes + commandHeadroom
+	var tooLarge error
+	for range n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got %q", ErrProtocol, line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+
+		if size > maxBytes || size > budget || tooLarge != nil {
+			if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+				return nil, err
+			}
+			if tooLarge == nil {
+				tooLarge = fmt.Errorf("%w: bulk string of %d bytes, max %d bytes", nim.ErrCacheValueTooLarge, size, maxBytes)
+			}
+			continue
+		}
+		budget -= size
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string not terminated", ErrProtocol)
+		}
+		args = append(args, buf[:size])
+	}
+	if tooLarge != nil {
+		return nil, tooLarge
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, fmt.Errorf("%w: line too long", ErrProtocol)
+		}
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	_, _ = w.WriteString("+" + s + "\r\n")
+}
+
+func (w writer) err(msg string) {
+	_, _ = w.WriteString("-" + msg + "\r\n")
+}
+
+func (w writer) int(n int64) {
+	_, _ = w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w writer) bulk(b []byte) {
+	_, _ = w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	_, _ = w.Write(b)
+	_, _ = w.WriteString("\r\n")
+}
+
+func (w writer) null() {
+	_, _ = w.WriteString("$-1\r\n")
+}
+
+func (w writer) array(items []string) {
+	_, _ = w.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		w.bulk([]byte(item))
+	}
+}
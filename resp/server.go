@@ -0,0 +1,81 @@
+// Package resp serves a nim cache over the Redis protocol (RESP), so
+// redis-cli and existing Redis client libraries can talk to it.
+//
+// Redis keys use ":" as a namespace separator; they are translated to nim's
+// "::" segments, so "user:1" is stored as "user::1". The supported commands
+// are GET, SET (with EX/PX), DEL, EXISTS, TTL, PTTL, EXPIRE, PEXPIRE, INCR,
+// INCRBY, DECR, DECRBY, SCAN, KEYS, PING, ECHO, SELECT 0 and QUIT.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/internal/connserver"
+)
+
+type Server struct {
+	client *nim.Client
+	conns  *connserver.Server
+}
+
+func NewServer(client *nim.Client) *Server {
+	s := &Server{client: client}
+	s.conns = connserver.New(s.serveConn, ErrServerClosed)
+	return s
+}
+
+// Serve accepts connections on l until Close is called, after which it
+// returns ErrServerClosed. For a unix socket pass net.Listen("unix", path).
+func (s *Server) Serve(l net.Listener) error {
+	return s.conns.Serve(l)
+}
+
+// Close stops all listeners, closes open connections and waits for their
+// handlers to return.
+func (s *Server) Close() error {
+	return s.conns.Close()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r, s.client.MaxBytes())
+		if errors.Is(err, nim.ErrCacheValueTooLarge) {
+			// The command was read past, so the connection stays usable.
+			writeErr(w, err)
+			if err := w.Flush(); err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				w.err("ERR " + err.Error())
+				_ = w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.dispatch(w, args)
+		// Replies to pipelined commands are flushed together.
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
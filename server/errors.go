@@ -0,0 +1,5 @@
+package server
+
+import "errors"
+
+var ErrInvalidHeader = errors.New("invalid request header")
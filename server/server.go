@@ -0,0 +1,266 @@
+// Package server exposes a nim cache over HTTP.
+//
+// Routes:
+//
+//	GET    /keys/{key}      payload, with Nim-Expires and Nim-TTL headers
+//	HEAD   /keys/{key}      headers only
+//	PUT    /keys/{key}      store the body; TTL from Nim-TTL or Nim-Expires
+//	DELETE /keys/{key}      remove key and every key nested below it
+//	GET    /keys?prefix=p   JSON array of live keys starting with p
+//	GET    /stats           JSON request counters
+//
+// Nim-Expires is an absolute expiry in Unix nanoseconds, as used by
+// nim.HTTPTier. Nim-TTL is a relative TTL in whole seconds, or a Go duration
+// such as "90s" on PUT. Both are omitted for entries without a TTL. A PUT
+// whose TTL has already run out removes the key, and one with Nim-Absent
+// records the key as absent instead of storing the body.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+const TTLHeader = "Nim-TTL"
+
+type Handler struct {
+	client *nim.Client
+	mux    *http.ServeMux
+	stats  counters
+}
+
+// Stats is the body of GET /stats.
+type Stats struct {
+	Gets    uint64 `json:"gets"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Sets    uint64 `json:"sets"`
+	Deletes uint64 `json:"deletes"`
+	Lists   uint64 `json:"lists"`
+	Errors  uint64 `json:"errors"`
+}
+
+type counters struct {
+	gets    atomic.Uint64
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	sets    atomic.Uint64
+	deletes atomic.Uint64
+	lists   atomic.Uint64
+	errors  atomic.Uint64
+}
+
+func NewHandler(client *nim.Client) *Handler {
+	h := &Handler{client: client, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /keys/{key...}", h.get)
+	h.mux.HandleFunc("HEAD /keys/{key...}", h.get)
+	h.mux.HandleFunc("PUT /keys/{key...}", h.put)
+	h.mux.HandleFunc("DELETE /keys/{key...}", h.remove)
+	h.mux.HandleFunc("GET /keys", h.list)
+	h.mux.HandleFunc("GET /stats", h.serveStats)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) Stats() Stats {
+	return Stats{
+		Gets:    h.stats.gets.Load(),
+		Hits:    h.stats.hits.Load(),
+		Misses:  h.stats.misses.Load(),
+		Sets:    h.stats.sets.Load(),
+		Deletes: h.stats.deletes.Load(),
+		Lists:   h.stats.lists.Load(),
+		Errors:  h.stats.errors.Load(),
+	}
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	h.stats.gets.Add(1)
+	key := r.PathValue("key")
+
+	var (
+		b    []byte
+		info nim.EntryInfo
+		ok   bool
+		err  error
+	)
+	if r.Method == http.MethodHead {
+		info, ok, err = h.client.StatContext(r.Context(), key)
+	} else {
+		b, info, ok, err = h.client.EntryContext(r.Context(), key)
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if !ok {
+		h.stats.misses.Add(1)
+		h.notFound(w, r, key)
+		return
+	}
+	h.stats.hits.Add(1)
+
+	writeExpiryHeaders(w.Header(), info.Expiry)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	_, _ = w.Write(b)
+}
+
+// notFound marks tombstones so an HTTPTier can carry them to its client.
+func (h *Handler) notFound(w http.ResponseWriter, r *http.Request, key string) {
+	if info, ok, err := h.client.Tombstone(key); err == nil && ok {
+		writeExpiryHeaders(w.Header(), info.Expiry)
+		w.Header().Set(nim.RemoteAbsentHeader, "1")
+	}
+	http.NotFound(w, r)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	h.stats.sets.Add(1)
+	key := r.PathValue("key")
+
+	ttl, expired, err := parseTTL(r.Header)
+	if err != nil {
+		h.stats.errors.Add(1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Bodies are read no further than the client would store.
+	maxBytes := h.client.MaxBytes()
+	if r.ContentLength > int64(maxBytes) {
+		h.writeError(w, fmt.Errorf("%w: got %d bytes, max %d bytes", nim.ErrCacheValueTooLarge, r.ContentLength, maxBytes))
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	// An expiry already in the past leaves nothing to store.
+	switch {
+	case expired:
+		err = h.client.RemoveContext(r.Context(), key)
+	case r.Header.Get(nim.RemoteAbsentHeader) != "":
+		err = h.client.SetAbsentContext(r.Context(), key, ttl)
+	default:
+		err = h.client.SetContext(r.Context(), key, b, ttl)
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) remove(w http.ResponseWriter, r *http.Request) {
+	h.stats.deletes.Add(1)
+
+	if err := h.client.RemoveContext(r.Context(), r.PathValue("key")); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	h.stats.lists.Add(1)
+
+	keys, err := h.client.Keys(r.URL.Query().Get("prefix"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, keys)
+}
+
+func (h *Handler) serveStats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, h.Stats())
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	h.stats.errors.Add(1)
+	http.Error(w, err.Error(), StatusCode(err))
+}
+
+// StatusCode maps errors returned by nim to HTTP status codes.
+func StatusCode(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, nim.ErrCacheValueTooLarge), errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, nim.ErrCacheKeyEmpty),
+		errors.Is(err, nim.ErrCacheKeyEmptySegment),
+		errors.Is(err, nim.ErrCacheKeyInvalidSegment),
+		errors.Is(err, nim.ErrCacheKeyReserved),
+		errors.Is(err, nim.ErrCacheValueReserved):
+		return http.StatusBadRequest
+	case errors.Is(err, nim.ErrCachePathIsDir):
+		return http.StatusConflict
+	case errors.Is(err, nim.ErrCacheRemote):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseTTL reads the TTL of a PUT. Nim-TTL takes precedence over
+// Nim-Expires. A zero TTL means no expiry; expired reports a negative TTL or
+// an absolute expiry that already passed.
+func parseTTL(header http.Header) (ttl time.Duration, expired bool, err error) {
+	if v := header.Get(TTLHeader); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if secs, convErr := strconv.ParseInt(v, 10, 64); convErr == nil {
+			ttl, err = time.Duration(secs)*time.Second, nil
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("%w: invalid %s header %q", ErrInvalidHeader, TTLHeader, v)
+		}
+		return ttl, ttl < 0, nil
+	}
+
+	if v := header.Get(nim.RemoteExpiresHeader); v != "" {
+		nanos, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("%w: invalid %s header %q", ErrInvalidHeader, nim.RemoteExpiresHeader, v)
+		}
+		ttl := time.Until(time.Unix(0, nanos))
+		return ttl, ttl <= 0, nil
+	}
+
+	return 0, false, nil
+}
+
+func writeExpiryHeaders(header http.Header, expiry time.Time) {
+	if expiry.IsZero() {
+		return
+	}
+
+	secs := int64(math.Ceil(time.Until(expiry).Seconds()))
+	header.Set(nim.RemoteExpiresHeader, strconv.FormatInt(expiry.UnixNano(), 10))
+	header.Set(TTLHeader, strconv.FormatInt(max(secs, 0), 10))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
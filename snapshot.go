@@ -0,0 +1,335 @@
+package nim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// rootBarrier lets Snapshot and Restore hold off every writer of a root,
+// across processes. Writers share a flock on a file inside the root, taken
+// when the first writer of the process enters and dropped when the last one
+// leaves; the barrier is raised by taking that flock exclusively.
+//
+// Within a process a waiting raise keeps new writers out, but flock does not
+// queue an exclusive request ahead of shared ones: another process whose
+// writes keep overlapping never drops its shared flock, and raise waits
+// until it does. A nil barrier, used unless Config.SnapshotBarrier is set,
+// costs writers nothing.
+type rootBarrier struct {
+	file    *os.File
+	path    string
+	rw      sync.RWMutex
+	mu      sync.Mutex
+	writers int
+}
+
+func newRootBarrier(rootPath string) *rootBarrier {
+	return &rootBarrier{path: filepath.Join(rootPath, internalDirName, barrierFileName)}
+}
+
+// enter must not be nested: a writer already inside would deadlock against
+// a waiting raise.
+func (b *rootBarrier) enter() error {
+	if b == nil {
+		return nil
+	}
+	b.rw.RLock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.writers == 0 {
+		f, err := flockBarrier(b.file, b.path, syscall.LOCK_SH)
+		if err != nil {
+			b.file = nil
+			b.rw.RUnlock()
+			return err
+		}
+		b.file = f
+	}
+	b.writers++
+	return nil
+}
+
+func (b *rootBarrier) leave() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.writers--
+	if b.writers == 0 {
+		_ = syscall.Flock(int(b.file.Fd()), syscall.LOCK_UN)
+	}
+	b.mu.Unlock()
+	b.rw.RUnlock()
+}
+
+// raise blocks until no writer of any process is inside and keeps them out
+// until the returned func is called.
+func (b *rootBarrier) raise() (func(), error) {
+	b.rw.Lock()
+	f, err := flockBarrier(nil, b.path, syscall.LOCK_EX)
+	if err != nil {
+		b.rw.Unlock()
+		return nil, err
+	}
+	return func() {
+		_ = unlockFile(f)
+		b.rw.Unlock()
+	}, nil
+}
+
+// flockBarrier flocks the barrier file at path, reusing f when it is still
+// linked there. Restore moves the file away with the old root, so holders
+// that waited on it notice the change and move to the new one.
+func flockBarrier(f *os.File, path string, how int) (*os.File, error) {
+	for {
+		if f == nil {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return nil, err
+			}
+			var err error
+			if f, err = os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o644); err != nil {
+				return nil, err
+			}
+		}
+		if err := syscall.Flock(int(f.Fd()), how); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+
+		held, err := f.Stat()
+		if err != nil {
+			_ = unlockFile(f)
+			return nil, err
+		}
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(held, current) {
+			return f, nil
+		}
+		_ = unlockFile(f)
+		f = nil
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
+
+// Snapshot writes a consistent copy of the root to dst, which must not
+// exist and must lie outside the root. Writers of every process sharing the
+// root are held off while the entries are linked, which only takes as long
+// as walking the tree: payload files are hard-linked, and copied only when
+// dst is on another file system. The snapshot is assembled next to dst and
+// renamed into place, so dst never holds a partial copy. Lock files and
+// state under the internal directory are not copied. Only the default file
+// backend supports it, and only with Config.SnapshotBarrier set by every
+// client of the root. Writers in other processes that never pause between
+// writes can delay it indefinitely.
+func (c *Client) Snapshot(dst string) error {
+	if c.files == nil {
+		return fmt.Errorf("%w: Snapshot", ErrCacheBackendUnsupported)
+	}
+	if c.files.barrier == nil {
+		return fmt.Errorf("%w: Snapshot without Config.SnapshotBarrier", ErrCacheBackendUnsupported)
+	}
+	if err := c.files.outsideRoot(dst); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); !errors.Is(err, os.ErrNotExist) {
+		if err == nil {
+			err = fmt.Errorf("%w: %s", os.ErrExist, dst)
+		}
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dst), filepath.Base(dst)+snapshotTempSuffix)
+	if err != nil {
+		return err
+	}
+
+	if err := c.files.snapshot(tmpDir); err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return err
+	}
+	if err := os.Rename(tmpDir, dst); err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+// outsideRoot refuses a snapshot path at or below the root: Snapshot would
+// walk into its own output, and Restore would move the root away from it.
+func (f *fileBackend) outsideRoot(path string) error {
+	root, err := filepath.Abs(f.rootPath)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return err
+	}
+	if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s", ErrCacheSnapshotInRoot, path)
+	}
+	return nil
+}
+
+func (f *fileBackend) snapshot(dst string) error {
+	release, err := f.barrier.raise()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	internalPath := filepath.Join(f.rootPath, internalDirName)
+	return filepath.WalkDir(f.rootPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			// Check and Recover may remove damaged directories meanwhile.
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path == internalPath {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(f.rootPath, path)
+		if err != nil {
+			return err
+		}
+		return snapshotDir(path, filepath.Join(dst, rel))
+	})
+}
+
+// snapshotDir copies the entry held directly in dirPath, if any, to dstDir.
+func snapshotDir(dirPath, dstDir string) error {
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	hasCache := false
+	for _, entry := range entries {
+		if entry.Name() == cacheFileName && entry.Type().IsRegular() {
+			hasCache = true
+		}
+	}
+	if !hasCache {
+		return nil
+	}
+
+	if err := linkOrCopy(filepath.Join(dirPath, cacheFileName), filepath.Join(dstDir, cacheFileName)); err != nil {
+		return err
+	}
+	if link, ok := currentExpiryLink(entries); ok {
+		return os.Symlink(cacheFileName, filepath.Join(dstDir, link.Name()))
+	}
+	return nil
+}
+
+// linkOrCopy hard-links src to dst. Payload files are only ever replaced by
+// rename, never rewritten, so the link keeps the snapshot's payload intact.
+func linkOrCopy(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Restore replaces the root with the snapshot at src, which is moved into
+// place and must be on the same file system as the root. Writers of every
+// process sharing the root are held off during the swap and continue on the
+// restored contents. The previous root is moved aside and removed once the
+// snapshot is in place; a crash in between leaves it next to the root. Key
+// locks held across a Restore do not carry over to the restored root. Like
+// Snapshot, it needs the default file backend and Config.SnapshotBarrier.
+func (c *Client) Restore(src string) error {
+	if c.files == nil {
+		return fmt.Errorf("%w: Restore", ErrCacheBackendUnsupported)
+	}
+	if c.files.barrier == nil {
+		return fmt.Errorf("%w: Restore without Config.SnapshotBarrier", ErrCacheBackendUnsupported)
+	}
+	if err := c.files.outsideRoot(src); err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s", ErrCacheSnapshotInvalid, src)
+	}
+	defer c.l1.clear()
+
+	oldRoot, err := c.files.swapRoot(src)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(oldRoot)
+}
+
+func (f *fileBackend) swapRoot(src string) (string, error) {
+	release, err := f.barrier.raise()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	// os.Rename refuses to replace a directory, so the unique name picked by
+	// MkdirTemp is freed again before the root is moved there.
+	oldRoot, err := os.MkdirTemp(filepath.Dir(f.rootPath), filepath.Base(f.rootPath)+restoreOldSuffix)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(oldRoot); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.rootPath, oldRoot); err != nil {
+		return "", err
+	}
+	if err := os.Rename(src, f.rootPath); err != nil {
+		if rerr := os.Rename(oldRoot, f.rootPath); rerr != nil {
+			return "", errors.Join(err, rerr)
+		}
+		return "", err
+	}
+	return oldRoot, nil
+}
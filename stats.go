@@ -0,0 +1,225 @@
+package nim
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorKind classifies the errors counted in Stats.
+type ErrorKind string
+
+const (
+	// ErrorKindKey is an invalid or reserved key.
+	ErrorKindKey ErrorKind = "key"
+	// ErrorKindTooLarge is a value over Config.MaxBytes.
+	ErrorKindTooLarge ErrorKind = "too-large"
+	// ErrorKindCodec is a value that could not be gob encoded or decoded, or
+	// one reserved for tombstones.
+	ErrorKindCodec ErrorKind = "codec"
+	// ErrorKindLock is a lost key lock or a lock wait cut short by its
+	// context.
+	ErrorKindLock ErrorKind = "lock"
+	// ErrorKindRemote is a failed request to the remote tier.
+	ErrorKindRemote ErrorKind = "remote"
+	// ErrorKindBackend is any other failure, usually I/O in the backend.
+	ErrorKindBackend ErrorKind = "backend"
+)
+
+// Metrics receives the measurements behind Client.Stats as they are taken,
+// so they can be exported to a monitoring system without nim depending on
+// one. Methods are called on the goroutine of the operation and must be
+// safe for concurrent use.
+type Metrics interface {
+	Hit()
+	Miss()
+	ExpiredOnRead()
+	Set(bytes int)
+	Remove()
+	LockWait(d time.Duration)
+	Error(kind ErrorKind)
+}
+
+// Stats is a snapshot of the counters of a Client since it was created.
+// Reads through Get, Entry, Lookup, Stat and Exists count as hits when the
+// cache answers them, tombstones included, and as misses otherwise; an
+// entry found expired also counts in ExpiredOnRead. Sets and Removes count
+// keys, including those written by transactions; removing a key that is not
+// stored is not counted. EventsDropped counts events not delivered because
+// too many were waiting. There is no figure for bytes on disk: it would take
+// a walk of the whole tree, and other processes write to the same root, so
+// BytesWritten only covers the writes of this client.
+type Stats struct {
+	Errors        map[ErrorKind]uint64
+	LockWait      Histogram
+	Hits          uint64
+	Misses        uint64
+	ExpiredOnRead uint64
+	Sets          uint64
+	Removes       uint64
+	BytesWritten  uint64
+	EventsDropped uint64
+}
+
+// Histogram counts durations into buckets. Counts[i] holds the durations up
+// to Bounds[i] not counted in an earlier bucket, and the last count those
+// above every bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Sum    time.Duration
+}
+
+var lockWaitBounds = [...]time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+type clientStats struct {
+	metrics       Metrics
+	errors        map[ErrorKind]uint64
+	lockWait      [len(lockWaitBounds) + 1]atomic.Uint64
+	lockWaitSum   atomic.Int64
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	expiredOnRead atomic.Uint64
+	sets          atomic.Uint64
+	removes       atomic.Uint64
+	bytesWritten  atomic.Uint64
+	mu            sync.Mutex
+}
+
+// Stats returns the counters collected so far.
+func (c *Client) Stats() Stats {
+	s := c.stats
+	st := Stats{
+		Hits:          s.hits.Load(),
+		Misses:        s.misses.Load(),
+		ExpiredOnRead: s.expiredOnRead.Load(),
+		Sets:          s.sets.Load(),
+		Removes:       s.removes.Load(),
+		BytesWritten:  s.bytesWritten.Load(),
+		EventsDropped: c.events.dropped.Load(),
+		LockWait: Histogram{
+			Bounds: lockWaitBounds[:],
+			Counts: make([]uint64, len(s.lockWait)),
+			Sum:    time.Duration(s.lockWaitSum.Load()),
+		},
+	}
+	for i := range s.lockWait {
+		st.LockWait.Counts[i] = s.lockWait[i].Load()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.Errors = make(map[ErrorKind]uint64, len(s.errors))
+	for kind, n := range s.errors {
+		st.Errors[kind] = n
+	}
+	return st
+}
+
+func (s *clientStats) read(found bool) {
+	if found {
+		s.hits.Add(1)
+		if s.metrics != nil {
+			s.metrics.Hit()
+		}
+		return
+	}
+	s.misses.Add(1)
+	if s.metrics != nil {
+		s.metrics.Miss()
+	}
+}
+
+func (s *clientStats) expired() {
+	s.expiredOnRead.Add(1)
+	if s.metrics != nil {
+		s.metrics.ExpiredOnRead()
+	}
+}
+
+func (s *clientStats) set(n int) {
+	s.sets.Add(1)
+	s.bytesWritten.Add(uint64(n))
+	if s.metrics != nil {
+		s.metrics.Set(n)
+	}
+}
+
+func (s *clientStats) remove() {
+	s.removes.Add(1)
+	if s.metrics != nil {
+		s.metrics.Remove()
+	}
+}
+
+func (s *clientStats) waited(start time.Time) time.Duration {
+	d := time.Since(start)
+	i := 0
+	for i < len(lockWaitBounds) && d > lockWaitBounds[i] {
+		i++
+	}
+	s.lockWait[i].Add(1)
+	s.lockWaitSum.Add(int64(d))
+	if s.metrics != nil {
+		s.metrics.LockWait(d)
+	}
+	return d
+}
+
+// fail counts *errp, if set. It is deferred by the public operations.
+func (s *clientStats) fail(errp *error) {
+	if *errp == nil {
+		return
+	}
+	kind := errorKind(*errp)
+
+	s.mu.Lock()
+	if s.errors == nil {
+		s.errors = make(map[ErrorKind]uint64)
+	}
+	s.errors[kind]++
+	s.mu.Unlock()
+
+	if s.metrics != nil {
+		s.metrics.Error(kind)
+	}
+}
+
+// codecError marks gob failures for errorKind without changing the message.
+type codecError struct {
+	error
+}
+
+func (e codecError) Unwrap() error {
+	return e.error
+}
+
+func errorKind(err error) ErrorKind {
+	var codec codecError
+	switch {
+	case errors.Is(err, ErrCacheKeyEmpty), errors.Is(err, ErrCacheKeyEmptySegment), errors.Is(err, ErrCacheKeyInvalidSegment),
+		errors.Is(err, ErrCacheKeyReserved):
+		return ErrorKindKey
+	case errors.Is(err, ErrCacheValueTooLarge):
+		return ErrorKindTooLarge
+	case errors.As(err, &codec):
+		return ErrorKindCodec
+	case errors.Is(err, ErrCacheLockLost), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindLock
+	case errors.Is(err, ErrCacheRemote):
+		return ErrorKindRemote
+	case errors.Is(err, ErrCacheValueReserved):
+		return ErrorKindCodec
+	default:
+		return ErrorKindBackend
+	}
+}
@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+func exportArchive(t *testing.T, client *nim.Client, prefix string, want int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	n, err := client.Export(&buf, prefix)
+	if err != nil {
+		t.Fatalf("Export error=%v", err)
+	}
+	if n != want {
+		t.Fatalf("Export wrote=%d want=%d", n, want)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	src := newClientForCase(t, "archive round trip src", 1024)
+	if err := src.Set("users::1", sampleValue{Name: "alice", Count: 1}, time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := src.Set("users::1::avatar", []byte{0, 1, 2}, 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := src.SetAbsent("users::404", time.Hour); err != nil {
+		t.Fatalf("SetAbsent error=%v", err)
+	}
+	if err := src.Set("orders::1", "book", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	archive := exportArchive(t, src, "users::", 3)
+
+	dst := newClientForCase(t, "archive round trip dst", 1024)
+	n, err := dst.Import(bytes.NewReader(archive), nim.ImportOptions{})
+	if err != nil || n != 3 {
+		t.Fatalf("Import imported=%d error=%v want 3", n, err)
+	}
+
+	assertKeys(t, dst, "", []string{"users::1", "users::1::avatar"})
+	var got sampleValue
+	if ok, err := dst.Get("users::1", &got); err != nil || !ok || got.Name != "alice" {
+		t.Fatalf("Get=%+v ok=%v error=%v", got, ok, err)
+	}
+	want, _, _ := src.Stat("users::1")
+	info, _, _ := dst.Stat("users::1")
+	if !info.Expiry.Equal(want.Expiry) {
+		t.Fatalf("imported expiry=%v want=%v", info.Expiry, want.Expiry)
+	}
+	assertLookup(t, dst, "users::404", nim.PresenceAbsent)
+}
+
+func TestArchiveImportOptions(t *testing.T) {
+	t.Parallel()
+
+	src := newClientForCase(t, "archive import options src", 1024)
+	if err := src.Set("short", "v", 30*time.Millisecond); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := src.Set("long", "v", time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	archive := exportArchive(t, src, "", 2)
+	time.Sleep(50 * time.Millisecond)
+
+	cases := []struct {
+		name string
+		opts nim.ImportOptions
+		want int
+	}{
+		{name: "plain", opts: nim.ImportOptions{}, want: 2},
+		{name: "skip expired", opts: nim.ImportOptions{SkipExpired: true}, want: 1},
+		{name: "rebase", opts: nim.ImportOptions{Rebase: true, SkipExpired: true}, want: 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := newClientForCase(t, "archive import options "+tc.name, 1024)
+			n, err := dst.Import(bytes.NewReader(archive), tc.opts)
+			if err != nil || n != tc.want {
+				t.Fatalf("Import imported=%d error=%v want %d", n, err, tc.want)
+			}
+		})
+	}
+
+	dst := newClientForCase(t, "archive import options rebase ttl", 1024)
+	before := time.Now()
+	if _, err := dst.Import(bytes.NewReader(archive), nim.ImportOptions{Rebase: true}); err != nil {
+		t.Fatalf("Import error=%v", err)
+	}
+	info, ok, err := dst.Stat("short")
+	if err != nil || !ok {
+		t.Fatalf("Stat ok=%v error=%v want rebased entry", ok, err)
+	}
+	if info.Expiry.Before(before) || info.Expiry.After(time.Now().Add(30*time.Millisecond)) {
+		t.Fatalf("rebased expiry=%v want within 30ms of import", info.Expiry)
+	}
+}
+
+func TestArchiveImportRejectsForeignArchive(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "users::1", Mode: 0o644, Size: 1}); err != nil {
+		t.Fatalf("WriteHeader error=%v", err)
+	}
+	_, _ = tw.Write([]byte("x"))
+	_ = tw.Close()
+
+	client := newClientForCase(t, "archive rejects foreign", 1024)
+	if _, err := client.Import(&buf, nim.ImportOptions{}); !errors.Is(err, nim.ErrCacheArchiveInvalid) {
+		t.Fatalf("Import error=%v want ErrCacheArchiveInvalid", err)
+	}
+	if _, err := client.Import(strings.NewReader(""), nim.ImportOptions{}); !errors.Is(err, nim.ErrCacheArchiveInvalid) {
+		t.Fatalf("Import(empty) error=%v want ErrCacheArchiveInvalid", err)
+	}
+}
+
+func TestArchiveImportEnforcesMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	src := newClientForCase(t, "archive max bytes src", 1024)
+	if err := src.Set("big", strings.Repeat("x", 100), 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	archive := exportArchive(t, src, "", 1)
+
+	dst := newClientForCase(t, "archive max bytes dst", 10)
+	if _, err := dst.Import(bytes.NewReader(archive), nim.ImportOptions{}); !errors.Is(err, nim.ErrCacheValueTooLarge) {
+		t.Fatalf("Import error=%v want ErrCacheValueTooLarge", err)
+	}
+}
+
+func TestArchiveTombstonesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	src := newClientForCase(t, "archive tombstones src", 1024)
+	if err := src.SetAbsent("users::404", time.Hour); err != nil {
+		t.Fatalf("SetAbsent error=%v", err)
+	}
+	archive := exportArchive(t, src, "", 1)
+
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("no tombstone entry in archive, error=%v", err)
+		}
+		if hdr.Name == "users::404" {
+			if hdr.Size != 0 || hdr.PAXRecords["NIM.tombstone"] != "1" {
+				t.Fatalf("tombstone header size=%d records=%v", hdr.Size, hdr.PAXRecords)
+			}
+			break
+		}
+	}
+
+	dst := newClientForCase(t, "archive tombstones dst", 1024)
+	if n, err := dst.Import(bytes.NewReader(archive), nim.ImportOptions{}); err != nil || n != 1 {
+		t.Fatalf("Import imported=%d error=%v want 1", n, err)
+	}
+	want, _, _ := src.Tombstone("users::404")
+	info, ok, err := dst.Tombstone("users::404")
+	if err != nil || !ok || !info.Expiry.Equal(want.Expiry) {
+		t.Fatalf("Tombstone info=%+v ok=%v error=%v want expiry %v", info, ok, err, want.Expiry)
+	}
+	assertLookup(t, dst, "users::404", nim.PresenceAbsent)
+	assertKeys(t, dst, "", nil)
+
+	// Exporting the import again gives the same entry.
+	again := exportArchive(t, dst, "", 1)
+	dst2 := newClientForCase(t, "archive tombstones dst2", 1024)
+	if _, err := dst2.Import(bytes.NewReader(again), nim.ImportOptions{}); err != nil {
+		t.Fatalf("Import error=%v", err)
+	}
+	assertLookup(t, dst2, "users::404", nim.PresenceAbsent)
+}
+
+func TestArchiveImportRejectsMaliciousEntries(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		hdr  tar.Header
+	}{
+		{name: "parent path", hdr: tar.Header{Typeflag: tar.TypeReg, Name: "../../evil"}},
+		{name: "parent segment", hdr: tar.Header{Typeflag: tar.TypeReg, Name: "..::..::evil"}},
+		{name: "absolute path", hdr: tar.Header{Typeflag: tar.TypeReg, Name: "/tmp/evil"}},
+		{name: "current segment", hdr: tar.Header{Typeflag: tar.TypeReg, Name: "users::."}},
+		{name: "empty segment", hdr: tar.Header{Typeflag: tar.TypeReg, Name: "users::::1"}},
+		{name: "reserved namespace", hdr: tar.Header{Typeflag: tar.TypeReg, Name: ".nim::txn::1"}},
+		{name: "symlink", hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "users::1", Linkname: "/etc/passwd"}},
+		{name: "hard link", hdr: tar.Header{Typeflag: tar.TypeLink, Name: "users::1", Linkname: "users::2"}},
+		{name: "directory", hdr: tar.Header{Typeflag: tar.TypeDir, Name: "users::1"}},
+		{
+			name: "tombstone with payload",
+			hdr: tar.Header{
+				Typeflag:   tar.TypeReg,
+				Name:       "users::1",
+				Size:       1,
+				PAXRecords: map[string]string{"NIM.tombstone": "1"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			base := t.TempDir()
+			client, err := nim.New(nim.Config{RootPath: filepath.Join(base, "a", "root")})
+			if err != nil {
+				t.Fatalf("New error=%v", err)
+			}
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			err = tw.WriteHeader(&tar.Header{
+				Typeflag:   tar.TypeXGlobalHeader,
+				Name:       "nim-archive",
+				PAXRecords: map[string]string{"NIM.version": "1", "NIM.exported": "0"},
+			})
+			if err != nil {
+				t.Fatalf("WriteHeader error=%v", err)
+			}
+			hdr := tc.hdr
+			hdr.Mode = 0o644
+			hdr.Format = tar.FormatPAX
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatalf("WriteHeader error=%v", err)
+			}
+			if hdr.Size > 0 {
+				_, _ = tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("Close error=%v", err)
+			}
+
+			n, err := client.Import(&buf, nim.ImportOptions{})
+			if !errors.Is(err, nim.ErrCacheArchiveInvalid) || n != 0 {
+				t.Fatalf("Import imported=%d error=%v want ErrCacheArchiveInvalid", n, err)
+			}
+			for _, path := range []string{filepath.Join(base, "evil"), filepath.Join(base, "a", "evil")} {
+				if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("Lstat(%s) error=%v want not exist", path, err)
+				}
+			}
+			assertKeys(t, client, "", nil)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+type backendCase struct {
+	newClient func(t *testing.T) *nim.Client
+	name      string
+}
+
+func backendCases() []backendCase {
+	return []backendCase{
+		{
+			name: "file",
+			newClient: func(t *testing.T) *nim.Client {
+				t.Helper()
+				return newClientForCase(t, "backend "+t.Name(), 1024)
+			},
+		},
+		{
+			name: "memory",
+			newClient: func(t *testing.T) *nim.Client {
+				t.Helper()
+				client, err := nim.New(nim.Config{Backend: nim.NewMemoryBackend(), MaxBytes: 1024})
+				if err != nil {
+					t.Fatalf("New error=%v", err)
+				}
+				return client
+			},
+		},
+	}
+}
+
+func assertKeys(t *testing.T, client *nim.Client, prefix string, want []string) {
+	t.Helper()
+
+	keys, err := client.Keys(prefix)
+	if err != nil {
+		t.Fatalf("Keys error=%v", err)
+	}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("Keys(%q)=%v want=%v", prefix, keys, want)
+	}
+}
+
+func TestBackendSetGetRemove(t *testing.T) {
+	t.Parallel()
+
+	for _, bc := range backendCases() {
+		t.Run(bc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := bc.newClient(t)
+			for _, key := range []string{"users", "users::1", "users::1::profile", "posts::1"} {
+				if err := client.Set(key, key+"-value", 0); err != nil {
+					t.Fatalf("Set error=%v", err)
+				}
+			}
+			assertGetStringValue(t, client, "users::1", "users::1-value")
+
+			var sample sampleValue
+			if err := client.Set("posts::2", sampleValue{Name: "post", Count: 2}, 0); err != nil {
+				t.Fatalf("Set error=%v", err)
+			}
+			ok, err := client.Get("posts::2", &sample)
+			if err != nil || !ok || sample.Count != 2 {
+				t.Fatalf("Get ok=%v value=%+v error=%v", ok, sample, err)
+			}
+
+			if err := client.Remove("users::1"); err != nil {
+				t.Fatalf("Remove error=%v", err)
+			}
+			for _, key := range []string{"users::1", "users::1::profile"} {
+				exists, err := client.Exists(key)
+				if err != nil {
+					t.Fatalf("Exists error=%v", err)
+				}
+				if exists {
+					t.Fatalf("Exists(%q)=true want=false after parent remove", key)
+				}
+			}
+			assertGetStringValue(t, client, "users", "users-value")
+		})
+	}
+}
+
+func TestBackendTTLExpires(t *testing.T) {
+	t.Parallel()
+
+	for _, bc := range backendCases() {
+		t.Run(bc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := bc.newClient(t)
+			if err := client.Set("ttl::short", "value", 15*time.Millisecond); err != nil {
+				t.Fatalf("Set error=%v", err)
+			}
+			assertGetStringValue(t, client, "ttl::short", "value")
+
+			time.Sleep(35 * time.Millisecond)
+			exists, err := client.Exists("ttl::short")
+			if err != nil {
+				t.Fatalf("Exists error=%v", err)
+			}
+			if exists {
+				t.Fatalf("Exists=true want=false after ttl")
+			}
+		})
+	}
+}
+
+func TestBackendKeys(t *testing.T) {
+	t.Parallel()
+
+	for _, bc := range backendCases() {
+		t.Run(bc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := bc.newClient(t)
+			for _, key := range []string{"app::users::2", "app::users::1", "app::user", "app::posts::1", "other"} {
+				if err := client.Set(key, "v", 0); err != nil {
+					t.Fatalf("Set error=%v", err)
+				}
+			}
+			if err := client.Set("app::users::3", "v", time.Nanosecond); err != nil {
+				t.Fatalf("Set error=%v", err)
+			}
+			time.Sleep(time.Millisecond)
+
+			assertKeys(t, client, "app::user", []string{"app::user", "app::users::1", "app::users::2"})
+			assertKeys(t, client, "app::users::", []string{"app::users::1", "app::users::2"})
+			assertKeys(t, client, "", []string{"app::posts::1", "app::user", "app::users::1", "app::users::2", "other"})
+			assertKeys(t, client, "missing::", nil)
+		})
+	}
+}
+
+func TestBackendTxnAndLocks(t *testing.T) {
+	t.Parallel()
+
+	for _, bc := range backendCases() {
+		t.Run(bc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := bc.newClient(t)
+			if err := client.Set("acct::a", "10", 0); err != nil {
+				t.Fatalf("Set error=%v", err)
+			}
+
+			err := client.Txn([]string{"acct::a", "acct::b"}, func(tx *nim.Tx) error {
+				if err := tx.Set("acct::b", "10", 0); err != nil {
+					return err
+				}
+				return tx.Remove("acct::a")
+			})
+			if err != nil {
+				t.Fatalf("Txn error=%v", err)
+			}
+			assertGetStringValue(t, client, "acct::b", "10")
+			if exists, _ := client.Exists("acct::a"); exists {
+				t.Fatalf("Exists(acct::a)=true want=false after txn remove")
+			}
+
+			lock, ok, err := client.TryLock("acct::b")
+			if err != nil || !ok {
+				t.Fatalf("TryLock ok=%v error=%v", ok, err)
+			}
+			if _, ok, err := client.TryLock("acct::b"); err != nil || ok {
+				t.Fatalf("second TryLock ok=%v error=%v want ok=false", ok, err)
+			}
+			if err := lock.Refresh(); err != nil {
+				t.Fatalf("Refresh error=%v", err)
+			}
+			if err := lock.Unlock(); err != nil {
+				t.Fatalf("Unlock error=%v", err)
+			}
+			if err := lock.Refresh(); !errors.Is(err, nim.ErrCacheLockLost) {
+				t.Fatalf("Refresh after Unlock error=%v want=%v", err, nim.ErrCacheLockLost)
+			}
+
+			again, ok, err := client.TryLock("acct::b")
+			if err != nil || !ok {
+				t.Fatalf("TryLock after Unlock ok=%v error=%v", ok, err)
+			}
+			_ = again.Unlock()
+		})
+	}
+}
+
+type plainBackend struct {
+	nim.Backend
+}
+
+func TestTxnRequiresCommitter(t *testing.T) {
+	t.Parallel()
+
+	client, err := nim.New(nim.Config{Backend: plainBackend{nim.NewMemoryBackend()}})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	err = client.Txn([]string{"a"}, func(*nim.Tx) error { return nil })
+	if !errors.Is(err, nim.ErrCacheBackendUnsupported) {
+		t.Fatalf("Txn error=%v want=%v", err, nim.ErrCacheBackendUnsupported)
+	}
+}
@@ -235,3 +235,22 @@ func BenchmarkCacheSetParallelSameKey(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkCacheSetSnapshotBarrier(b *testing.B) {
+	for _, barrier := range []bool{false, true} {
+		b.Run(fmt.Sprintf("barrier=%t", barrier), func(b *testing.B) {
+			client, err := nim.New(nim.Config{RootPath: b.TempDir(), SnapshotBarrier: barrier})
+			if err != nil {
+				b.Fatalf("New error=%v", err)
+			}
+			payload := bytes.Repeat([]byte("a"), 128)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := client.Set("bench::set::barrier", payload, 0); err != nil {
+					b.Fatalf("Set error=%v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+func anomalyKinds(report nim.CheckReport) []nim.AnomalyKind {
+	kinds := make([]nim.AnomalyKind, 0, len(report.Anomalies))
+	for _, a := range report.Anomalies {
+		kinds = append(kinds, a.Kind)
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+func TestCheckTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		damage func(t *testing.T, rootPath string)
+		name   string
+		want   nim.AnomalyKind
+	}{
+		{
+			name: "stray temp file",
+			damage: func(t *testing.T, rootPath string) {
+				writeDamage(t, filepath.Join(cacheKeyDir(rootPath, "users::1"), "cache-tmp-123"))
+			},
+			want: nim.AnomalyTempFile,
+		},
+		{
+			name: "stray ttl temp symlink",
+			damage: func(t *testing.T, rootPath string) {
+				linkDamage(t, filepath.Join(cacheKeyDir(rootPath, "users::1"), "ttl-temp-42"))
+			},
+			want: nim.AnomalyTTLTemp,
+		},
+		{
+			name: "second expiry symlink",
+			damage: func(t *testing.T, rootPath string) {
+				addStaleExpiry(t, cacheKeyDir(rootPath, "users::1"), "42")
+			},
+			want: nim.AnomalyExtraExpiry,
+		},
+		{
+			name: "key directory without cache file",
+			damage: func(t *testing.T, rootPath string) {
+				if err := os.MkdirAll(cacheKeyDir(rootPath, "users::2"), 0o755); err != nil {
+					t.Fatalf("MkdirAll error=%v", err)
+				}
+			},
+			want: nim.AnomalyMissingCache,
+		},
+		{
+			name: "orphaned lock file",
+			damage: func(t *testing.T, rootPath string) {
+				writeDamage(t, cacheKeyDir(rootPath, "users::3")+".lock")
+			},
+			want: nim.AnomalyOrphanLock,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			caseName := "check " + tc.name
+			client := newClientForCase(t, caseName, 1024)
+			if err := client.Set("users::1", "alice", time.Hour); err != nil {
+				t.Fatalf("Set error=%v", err)
+			}
+			tc.damage(t, caseRootPath(t, caseName))
+
+			report, err := client.Check(context.Background(), false)
+			if err != nil {
+				t.Fatalf("Check error=%v", err)
+			}
+			if kinds := anomalyKinds(report); !slices.Equal(kinds, []nim.AnomalyKind{tc.want}) {
+				t.Fatalf("Check anomalies=%v want=[%s]", report.Anomalies, tc.want)
+			}
+			if report.Anomalies[0].Repaired {
+				t.Fatalf("Check without repair reported a repair")
+			}
+
+			report, err = client.Check(context.Background(), true)
+			if err != nil {
+				t.Fatalf("Check(repair) error=%v", err)
+			}
+			if len(report.Anomalies) != 1 || !report.Anomalies[0].Repaired {
+				t.Fatalf("Check(repair) anomalies=%v want one repaired", report.Anomalies)
+			}
+
+			report, err = client.Check(context.Background(), false)
+			if err != nil {
+				t.Fatalf("Check error=%v", err)
+			}
+			if len(report.Anomalies) != 0 {
+				t.Fatalf("Check after repair anomalies=%v want none", report.Anomalies)
+			}
+			assertGetStringValue(t, client, "users::1", "alice")
+		})
+	}
+}
+
+func TestCheckKeepsNewestExpiry(t *testing.T) {
+	t.Parallel()
+
+	const caseName = "check keeps newest expiry"
+	client := newClientForCase(t, caseName, 1024)
+	if err := client.Set("users::1", "alice", time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	// An older symlink with an earlier expiry must not win over the current
+	// one, whatever order the directory is listed in.
+	dirPath := cacheKeyDir(caseRootPath(t, caseName), "users::1")
+	staleName := strconv.FormatInt(time.Now().Add(-time.Hour).UnixNano(), 10)
+	addStaleExpiry(t, dirPath, staleName)
+	stale := filepath.Join(dirPath, staleName)
+	assertGetStringValue(t, client, "users::1", "alice")
+
+	if _, err := client.Check(context.Background(), true); err != nil {
+		t.Fatalf("Check(repair) error=%v", err)
+	}
+	if _, err := os.Lstat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stale expiry symlink error=%v want not exist", err)
+	}
+	info, ok, err := client.Stat("users::1")
+	if err != nil || !ok {
+		t.Fatalf("Stat ok=%v error=%v want true", ok, err)
+	}
+	if info.Expiry.Before(time.Now()) {
+		t.Fatalf("Stat expiry=%v want in the future", info.Expiry)
+	}
+}
+
+func TestCheckRemovesEmptiedNamespaces(t *testing.T) {
+	t.Parallel()
+
+	const caseName = "check removes emptied namespaces"
+	client := newClientForCase(t, caseName, 1024)
+	rootPath := caseRootPath(t, caseName)
+	if err := os.MkdirAll(cacheKeyDir(rootPath, "a::b::c"), 0o755); err != nil {
+		t.Fatalf("MkdirAll error=%v", err)
+	}
+
+	report, err := client.Check(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Check(repair) error=%v", err)
+	}
+	if len(report.Anomalies) != 3 {
+		t.Fatalf("Check(repair) anomalies=%v want three directories", report.Anomalies)
+	}
+	if _, err := os.Stat(cacheKeyDir(rootPath, "a")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat(a) error=%v want not exist", err)
+	}
+	if locks := listLockFiles(t, rootPath); len(locks) != 0 {
+		t.Fatalf("lock files=%v want none", locks)
+	}
+}
+
+func TestCheckSkipsLockedKeys(t *testing.T) {
+	t.Parallel()
+
+	const caseName = "check skips locked keys"
+	client := newClientForCase(t, caseName, 1024)
+	rootPath := caseRootPath(t, caseName)
+	tmpPath := filepath.Join(cacheKeyDir(rootPath, "users::1"), "cache-tmp-123")
+	writeDamage(t, tmpPath)
+	holdExternalLock(t, cacheKeyDir(rootPath, "users::1")+".lock", "")
+
+	report, err := client.Check(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Check(repair) error=%v", err)
+	}
+	if !slices.Equal(report.Busy, []string{"users::1"}) || len(report.Anomalies) != 0 {
+		t.Fatalf("Check busy=%v anomalies=%v want users::1 skipped", report.Busy, report.Anomalies)
+	}
+	if _, err := os.Stat(tmpPath); err != nil {
+		t.Fatalf("Stat(temp) error=%v want kept", err)
+	}
+}
+
+func TestCheckLockDirOrphans(t *testing.T) {
+	t.Parallel()
+
+	rootPath := filepath.Join(t.TempDir(), "root")
+	lockDir := filepath.Join(t.TempDir(), "locks")
+	client, err := nim.New(nim.Config{RootPath: rootPath, LockDir: lockDir})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	orphan := hashedLockPath(lockDir, "users::2")
+	writeDamage(t, orphan)
+
+	report, err := client.Check(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Check(repair) error=%v", err)
+	}
+	if len(report.Anomalies) != 1 || report.Anomalies[0].Path != orphan {
+		t.Fatalf("Check anomalies=%v want %s", report.Anomalies, orphan)
+	}
+	if _, err := os.Stat(hashedLockPath(lockDir, "users::1")); err != nil {
+		t.Fatalf("Stat(live lock) error=%v want kept", err)
+	}
+}
+
+func TestCheckUnsupportedBackend(t *testing.T) {
+	t.Parallel()
+
+	client, err := nim.New(nim.Config{Backend: nim.NewMemoryBackend()})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if _, err := client.Check(context.Background(), false); !errors.Is(err, nim.ErrCacheBackendUnsupported) {
+		t.Fatalf("Check error=%v want ErrCacheBackendUnsupported", err)
+	}
+}
+
+func writeDamage(t *testing.T, path string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("MkdirAll error=%v", err)
+	}
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("WriteFile error=%v", err)
+	}
+}
+
+func linkDamage(t *testing.T, path string) {
+	t.Helper()
+
+	if err := os.Symlink("cache", path); err != nil {
+		t.Fatalf("Symlink error=%v", err)
+	}
+}
+
+// addStaleExpiry adds an expiry symlink named name to dirPath that is older
+// than the one already there.
+func addStaleExpiry(t *testing.T, dirPath, name string) {
+	t.Helper()
+
+	current := listSymlinkNames(t, dirPath)
+	if len(current) != 1 {
+		t.Fatalf("expiry symlinks=%v want one", current)
+	}
+	linkDamage(t, filepath.Join(dirPath, name))
+	time.Sleep(10 * time.Millisecond)
+
+	if err := os.Remove(filepath.Join(dirPath, current[0])); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	linkDamage(t, filepath.Join(dirPath, current[0]))
+}
+
+func TestRecoverRemovesOldTemps(t *testing.T) {
+	t.Parallel()
+
+	const caseName = "recover removes old temps"
+	client := newClientForCase(t, caseName, 1024)
+	rootPath := caseRootPath(t, caseName)
+	if err := client.Set("users::1", "alice", time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	dirPath := cacheKeyDir(rootPath, "users::1")
+	oldTemp := filepath.Join(dirPath, "cache-tmp-old")
+	writeDamage(t, oldTemp)
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(oldTemp, old, old); err != nil {
+		t.Fatalf("Chtimes error=%v", err)
+	}
+	newTemp := filepath.Join(dirPath, "cache-tmp-new")
+	writeDamage(t, newTemp)
+	oldTxn := filepath.Join(rootPath, ".nim", "txn", "tmp-old")
+	writeDamage(t, filepath.Join(oldTxn, "lock"))
+	if err := os.Chtimes(oldTxn, old, old); err != nil {
+		t.Fatalf("Chtimes error=%v", err)
+	}
+
+	removed, err := client.Recover(context.Background(), time.Minute)
+	if err != nil {
+		t.Fatalf("Recover error=%v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("Recover removed=%d want=2", removed)
+	}
+	for _, path := range []string{oldTemp, oldTxn} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat(%s) error=%v want not exist", path, err)
+		}
+	}
+	if _, err := os.Stat(newTemp); err != nil {
+		t.Fatalf("Stat(recent temp) error=%v want kept", err)
+	}
+	assertGetStringValue(t, client, "users::1", "alice")
+}
+
+func TestRecoverSkipsLockedKeys(t *testing.T) {
+	t.Parallel()
+
+	const caseName = "recover skips locked keys"
+	client := newClientForCase(t, caseName, 1024)
+	rootPath := caseRootPath(t, caseName)
+	tmpPath := filepath.Join(cacheKeyDir(rootPath, "users::1"), "cache-tmp-123")
+	writeDamage(t, tmpPath)
+	holdExternalLock(t, cacheKeyDir(rootPath, "users::1")+".lock", "")
+
+	removed, err := client.Recover(context.Background(), 0)
+	if err != nil || removed != 0 {
+		t.Fatalf("Recover removed=%d error=%v want 0", removed, err)
+	}
+	if _, err := os.Stat(tmpPath); err != nil {
+		t.Fatalf("Stat(temp) error=%v want kept", err)
+	}
+}
+
+func TestRecoverOnNew(t *testing.T) {
+	t.Parallel()
+
+	rootPath := filepath.Join(t.TempDir(), "root")
+	tmpPath := filepath.Join(cacheKeyDir(rootPath, "users::1"), "ttl-temp-42")
+	if err := os.MkdirAll(filepath.Dir(tmpPath), 0o755); err != nil {
+		t.Fatalf("MkdirAll error=%v", err)
+	}
+	linkDamage(t, tmpPath)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := nim.New(nim.Config{RootPath: rootPath, RecoverTempAge: time.Millisecond}); err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if _, err := os.Lstat(tmpPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat(ttl temp) error=%v want not exist", err)
+	}
+}
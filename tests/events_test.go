@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+func newEventClient(t *testing.T, l1Entries int) (*nim.Client, chan nim.Event) {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{
+		RootPath:  filepath.Join(t.TempDir(), "cache"),
+		L1Entries: l1Entries,
+	})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	events := make(chan nim.Event, 64)
+	record := func(ev nim.Event) { events <- ev }
+	for _, on := range []func(func(nim.Event)) func(){client.OnSet, client.OnRemove, client.OnExpire, client.OnEvict} {
+		t.Cleanup(on(record))
+	}
+	return client, events
+}
+
+func nextEvent(t *testing.T, events <-chan nim.Event) nim.Event {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event delivered")
+		return nim.Event{}
+	}
+}
+
+func expectEvents(t *testing.T, events <-chan nim.Event, want ...nim.Event) {
+	t.Helper()
+
+	for _, w := range want {
+		ev := nextEvent(t, events)
+		if ev.Kind != w.Kind || ev.Reason != w.Reason || ev.Key != w.Key {
+			t.Fatalf("event=%+v want %s %s %s", ev, w.Kind, w.Reason, w.Key)
+		}
+	}
+}
+
+func TestEventsWritesAndRemovals(t *testing.T) {
+	t.Parallel()
+
+	client, events := newEventClient(t, 0)
+	if err := client.Set("users::1", "alice", time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	ev := nextEvent(t, events)
+	if ev.Kind != nim.EventSet || ev.Reason != nim.ReasonWrite || ev.Key != "users::1" || ev.Expiry.IsZero() {
+		t.Fatalf("set event=%+v", ev)
+	}
+
+	if err := client.Set("users::3", "carol", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	expectEvents(t, events, nim.Event{Kind: nim.EventSet, Reason: nim.ReasonWrite, Key: "users::3"})
+
+	if err := client.Remove("users::1"); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	err := client.Txn([]string{"users::2", "users::3"}, func(tx *nim.Tx) error {
+		if err := tx.Set("users::2", "bob", 0); err != nil {
+			return err
+		}
+		return tx.Remove("users::3")
+	})
+	if err != nil {
+		t.Fatalf("Txn error=%v", err)
+	}
+	expectEvents(t, events,
+		nim.Event{Kind: nim.EventRemove, Reason: nim.ReasonDelete, Key: "users::1"},
+		nim.Event{Kind: nim.EventSet, Reason: nim.ReasonTxn, Key: "users::2"},
+		nim.Event{Kind: nim.EventRemove, Reason: nim.ReasonTxn, Key: "users::3"},
+	)
+}
+
+func TestEventsSkipRemovalsOfMissingKeys(t *testing.T) {
+	t.Parallel()
+
+	client, events := newEventClient(t, 0)
+	if err := client.Remove("users::1"); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	err := client.Txn([]string{"users::2"}, func(tx *nim.Tx) error {
+		return tx.Remove("users::2")
+	})
+	if err != nil {
+		t.Fatalf("Txn error=%v", err)
+	}
+	if err := client.Set("users::3", "carol", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	expectEvents(t, events, nim.Event{Kind: nim.EventSet, Reason: nim.ReasonWrite, Key: "users::3"})
+	if st := client.Stats(); st.Removes != 0 {
+		t.Fatalf("Stats.Removes=%d want 0", st.Removes)
+	}
+}
+
+func TestEventsExpiry(t *testing.T) {
+	t.Parallel()
+
+	client, events := newEventClient(t, 0)
+	for _, key := range []string{"users::1", "users::2"} {
+		if err := client.Set(key, "alice", time.Millisecond); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+	}
+	expectEvents(t, events,
+		nim.Event{Kind: nim.EventSet, Reason: nim.ReasonWrite, Key: "users::1"},
+		nim.Event{Kind: nim.EventSet, Reason: nim.ReasonWrite, Key: "users::2"},
+	)
+	time.Sleep(5 * time.Millisecond)
+
+	var s string
+	if found, err := client.Get("users::1", &s); err != nil || found {
+		t.Fatalf("Get found=%v error=%v", found, err)
+	}
+	if n, err := client.Purge("users"); err != nil || n != 1 {
+		t.Fatalf("Purge n=%d error=%v", n, err)
+	}
+	expectEvents(t, events,
+		nim.Event{Kind: nim.EventExpire, Reason: nim.ReasonRead, Key: "users::1"},
+		nim.Event{Kind: nim.EventExpire, Reason: nim.ReasonPurge, Key: "users::2"},
+	)
+}
+
+func TestEventsEviction(t *testing.T) {
+	t.Parallel()
+
+	client, events := newEventClient(t, 1)
+	var s string
+	for _, key := range []string{"users::1", "users::2"} {
+		if err := client.Set(key, "alice", 0); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+		if _, err := client.Get(key, &s); err != nil {
+			t.Fatalf("Get error=%v", err)
+		}
+	}
+	expectEvents(t, events,
+		nim.Event{Kind: nim.EventSet, Reason: nim.ReasonWrite, Key: "users::1"},
+		nim.Event{Kind: nim.EventSet, Reason: nim.ReasonWrite, Key: "users::2"},
+		nim.Event{Kind: nim.EventEvict, Reason: nim.ReasonCapacity, Key: "users::1"},
+	)
+}
+
+func TestEventsHandlersMayUseClient(t *testing.T) {
+	t.Parallel()
+
+	client, err := nim.New(nim.Config{RootPath: filepath.Join(t.TempDir(), "cache")})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	done := make(chan struct{})
+	cancel := client.OnRemove(func(ev nim.Event) {
+		// Chain the invalidation to a derived key, which is reported too.
+		if strings.HasPrefix(ev.Key, "views::") {
+			return
+		}
+		_ = client.Remove("views::" + ev.Key)
+		close(done)
+	})
+	for _, key := range []string{"users::1", "views::users::1"} {
+		if err := client.Set(key, "page", 0); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+	}
+	if err := client.Remove("users::1"); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+	cancel()
+
+	if ok, err := client.Exists("views::users::1"); err != nil || ok {
+		t.Fatalf("Exists ok=%v error=%v", ok, err)
+	}
+}
+
+func TestEventsDropBeyondQueueLimit(t *testing.T) {
+	t.Parallel()
+
+	client, err := nim.New(nim.Config{Backend: nim.NewMemoryBackend()})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var delivered atomic.Int64
+	cancel := client.OnSet(func(ev nim.Event) {
+		if delivered.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+	})
+	defer cancel()
+
+	if err := client.Set("first", "v", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	<-started
+	// The handler is blocked, so these all wait in the queue.
+	const queued, extra = 10000, 5
+	for i := range queued + extra {
+		if err := client.Set("k::"+strconv.Itoa(i), "v", 0); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+	}
+	if got := client.Stats().EventsDropped; got != extra {
+		t.Fatalf("EventsDropped=%d want=%d", got, extra)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for delivered.Load() != 1+queued {
+		if time.Now().After(deadline) {
+			t.Fatalf("delivered=%d want=%d", delivered.Load(), 1+queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventsHandlerPanicIsLogged(t *testing.T) {
+	t.Parallel()
+
+	client, logs := newLoggingClient(t, nim.Config{})
+	events := make(chan nim.Event, 2)
+	cancel := client.OnSet(func(ev nim.Event) {
+		if ev.Key == "users::1" {
+			panic("boom")
+		}
+		events <- ev
+	})
+	defer cancel()
+
+	for _, key := range []string{"users::1", "users::2"} {
+		if err := client.Set(key, "v", 0); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+	}
+	expectEvents(t, events, nim.Event{Kind: nim.EventSet, Reason: nim.ReasonWrite, Key: "users::2"})
+
+	recs := logs.records(t, "event handler panicked")
+	if len(recs) != 1 || recs[0]["key"] != "users::1" || recs[0]["err"] != "boom" || recs[0]["level"] != "ERROR" {
+		t.Fatalf("panic logs=%v", recs)
+	}
+}
@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/gocacheprog"
+)
+
+type cacheProgResponse struct {
+	Err           string
+	DiskPath      string
+	KnownCommands []string
+	OutputID      []byte
+	ID            int64
+	Size          int64
+	Miss          bool
+}
+
+type cacheProgSession struct {
+	in   io.WriteCloser
+	out  *json.Decoder
+	done chan error
+}
+
+func startCacheProg(t *testing.T, rootPath string) *cacheProgSession {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{RootPath: rootPath})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := &cacheProgSession{in: inW, out: json.NewDecoder(bufio.NewReader(outR)), done: make(chan error, 1)}
+	go func() {
+		err := gocacheprog.Serve(client, inR, outW)
+		_ = outW.Close()
+		s.done <- err
+	}()
+	t.Cleanup(func() {
+		_ = inW.Close()
+		_ = outR.Close()
+	})
+
+	hello := s.read(t)
+	if hello.ID != 0 || len(hello.KnownCommands) != 3 {
+		t.Fatalf("capabilities=%+v want get, put and close", hello)
+	}
+	return s
+}
+
+func (s *cacheProgSession) send(t *testing.T, req map[string]any, body []byte) {
+	t.Helper()
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal error=%v", err)
+	}
+	msg := string(b) + "\n"
+	if body != nil {
+		msg += `"` + base64.StdEncoding.EncodeToString(body) + `"` + "\n"
+	}
+	if _, err := io.WriteString(s.in, msg); err != nil {
+		t.Fatalf("write error=%v", err)
+	}
+}
+
+func (s *cacheProgSession) read(t *testing.T) cacheProgResponse {
+	t.Helper()
+
+	var resp cacheProgResponse
+	if err := s.out.Decode(&resp); err != nil {
+		t.Fatalf("Decode error=%v", err)
+	}
+	return resp
+}
+
+func TestGoCacheProgPutGetClose(t *testing.T) {
+	t.Parallel()
+
+	rootPath := t.TempDir()
+	s := startCacheProg(t, rootPath)
+	actionID := []byte{0xaa, 0x01}
+	outputID := []byte{0xbb, 0x02}
+	body := []byte("compiled object")
+
+	s.send(t, map[string]any{"ID": 1, "Command": "get", "ActionID": actionID}, nil)
+	if resp := s.read(t); resp.ID != 1 || !resp.Miss {
+		t.Fatalf("get before put=%+v want miss", resp)
+	}
+
+	s.send(t, map[string]any{"ID": 2, "Command": "put", "ActionID": actionID, "OutputID": outputID, "BodySize": len(body)}, body)
+	put := s.read(t)
+	if put.ID != 2 || put.Err != "" || !filepath.IsAbs(put.DiskPath) {
+		t.Fatalf("put=%+v want absolute DiskPath", put)
+	}
+
+	s.send(t, map[string]any{"ID": 3, "Command": "get", "ActionID": actionID}, nil)
+	get := s.read(t)
+	if get.ID != 3 || get.Miss || get.Size != int64(len(body)) || string(get.OutputID) != string(outputID) {
+		t.Fatalf("get=%+v", get)
+	}
+	b, err := os.ReadFile(get.DiskPath)
+	if err != nil {
+		t.Fatalf("ReadFile(DiskPath) error=%v", err)
+	}
+	if string(b) != string(body) {
+		t.Fatalf("DiskPath content=%q want=%q", b, body)
+	}
+
+	emptyAction := []byte{0xcc}
+	s.send(t, map[string]any{"ID": 4, "Command": "put", "ActionID": emptyAction, "OutputID": []byte{0xdd}}, nil)
+	if resp := s.read(t); resp.Err != "" || resp.DiskPath == "" {
+		t.Fatalf("empty put=%+v", resp)
+	}
+
+	s.send(t, map[string]any{"ID": 5, "Command": "close"}, nil)
+	if resp := s.read(t); resp.ID != 5 {
+		t.Fatalf("close=%+v", resp)
+	}
+	if err := <-s.done; err != nil {
+		t.Fatalf("Serve error=%v", err)
+	}
+
+	// A new process on the same root sees the stored action.
+	s = startCacheProg(t, rootPath)
+	s.send(t, map[string]any{"ID": 1, "Command": "get", "ActionID": actionID}, nil)
+	if resp := s.read(t); resp.Miss || resp.DiskPath != get.DiskPath {
+		t.Fatalf("get after restart=%+v want hit at %s", resp, get.DiskPath)
+	}
+}
+
+func TestGoCacheProgWithGoCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds with the go command")
+	}
+	t.Parallel()
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	dir := t.TempDir()
+	progPath := filepath.Join(dir, "nim-gocacheprog")
+	build := exec.Command(goBin, "build", "-o", progPath, "github.com/brownhounds/nim/cmd/nim-gocacheprog")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build nim-gocacheprog error=%v\n%s", err, out)
+	}
+
+	modDir := filepath.Join(dir, "hello")
+	if err := os.MkdirAll(modDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll error=%v", err)
+	}
+	files := map[string]string{
+		"go.mod":  "module hello\n\ngo 1.24\n",
+		"main.go": "package main\n\nfunc main() { println(\"hello\") }\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(modDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile error=%v", err)
+		}
+	}
+
+	rootPath := filepath.Join(dir, "cache")
+	for range 2 {
+		cmd := exec.Command(goBin, "build", "-o", filepath.Join(dir, "hello-bin"), ".")
+		cmd.Dir = modDir
+		cmd.Env = append(os.Environ(), "GOCACHEPROG="+progPath+" -root "+rootPath, "GOFLAGS=-mod=mod", "GOTOOLCHAIN=local")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go build with GOCACHEPROG error=%v\n%s", err, out)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(rootPath, "gocache", "a"))
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("action entries=%d error=%v want some", len(entries), err)
+	}
+}
@@ -0,0 +1,423 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/httpcache"
+)
+
+func newCachedHandlerServer(t *testing.T, cfg httpcache.MiddlewareConfig, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	srv := httptest.NewServer(httpcache.Middleware(client, cfg)(handler))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMiddlewareCachingTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		header   func(h http.Header)
+		first    map[string]string
+		second   map[string]string
+		name     string
+		path1    string
+		path2    string
+		cfg      httpcache.MiddlewareConfig
+		status   int
+		wantRuns int64
+	}{
+		{
+			name:     "max-age is cached",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			wantRuns: 1,
+		},
+		{
+			name:     "s-maxage is cached",
+			header:   func(h http.Header) { h.Set("Cache-Control", "s-maxage=60") },
+			wantRuns: 1,
+		},
+		{
+			name:     "no cache-control is not cached",
+			header:   func(http.Header) {},
+			wantRuns: 2,
+		},
+		{
+			name:     "default ttl caches without cache-control",
+			header:   func(http.Header) {},
+			cfg:      httpcache.MiddlewareConfig{DefaultTTL: time.Minute},
+			wantRuns: 1,
+		},
+		{
+			name:     "private is not cached",
+			header:   func(h http.Header) { h.Set("Cache-Control", "private, max-age=60") },
+			wantRuns: 2,
+		},
+		{
+			name: "set-cookie is not cached",
+			header: func(h http.Header) {
+				h.Set("Cache-Control", "max-age=60")
+				h.Set("Set-Cookie", "session=1")
+			},
+			wantRuns: 2,
+		},
+		{
+			name:     "server errors are not cached",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			status:   http.StatusInternalServerError,
+			wantRuns: 2,
+		},
+		{
+			name:     "query order shares an entry",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			path1:    "/items?a=1&b=2",
+			path2:    "/items?b=2&a=1",
+			wantRuns: 1,
+		},
+		{
+			name:     "different query is a miss",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			path1:    "/items?a=1",
+			path2:    "/items?a=2",
+			wantRuns: 2,
+		},
+		{
+			name:     "configured vary header splits the cache",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			cfg:      httpcache.MiddlewareConfig{VaryHeaders: []string{"accept-language"}},
+			first:    map[string]string{"Accept-Language": "de"},
+			second:   map[string]string{"Accept-Language": "fr"},
+			wantRuns: 2,
+		},
+		{
+			name: "unconfigured vary from handler is not cached",
+			header: func(h http.Header) {
+				h.Set("Cache-Control", "max-age=60")
+				h.Set("Vary", "Accept-Encoding")
+			},
+			wantRuns: 2,
+		},
+		{
+			name:     "authorization bypasses cache",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			first:    map[string]string{"Authorization": "Bearer x"},
+			second:   map[string]string{"Authorization": "Bearer x"},
+			wantRuns: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var runs atomic.Int64
+			srv := newCachedHandlerServer(t, tc.cfg, func(w http.ResponseWriter, _ *http.Request) {
+				n := runs.Add(1)
+				tc.header(w.Header())
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+				_, _ = io.WriteString(w, "run-"+strconv.FormatInt(n, 10))
+			})
+
+			path1, path2 := tc.path1, tc.path2
+			if path1 == "" {
+				path1, path2 = "/", "/"
+			}
+			fetch(t, http.DefaultClient, http.MethodGet, srv.URL+path1, tc.first)
+			_, body := fetch(t, http.DefaultClient, http.MethodGet, srv.URL+path2, tc.second)
+
+			if got := runs.Load(); got != tc.wantRuns {
+				t.Fatalf("handler runs=%d want=%d", got, tc.wantRuns)
+			}
+			if want := "run-" + strconv.FormatInt(tc.wantRuns, 10); body != want {
+				t.Fatalf("second body=%q want=%q", body, want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareConditionalRequests(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		etag     string
+		wantETag string
+	}{
+		{name: "generated etag"},
+		{name: "handler etag", etag: `"v7"`, wantETag: `"v7"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := newCachedHandlerServer(t, httpcache.MiddlewareConfig{}, func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				if tc.etag != "" {
+					w.Header().Set("ETag", tc.etag)
+				}
+				_, _ = io.WriteString(w, "payload")
+			})
+
+			resp, _ := fetch(t, http.DefaultClient, http.MethodGet, srv.URL, nil)
+			etag := resp.Header.Get("ETag")
+			if etag == "" || (tc.wantETag != "" && etag != tc.wantETag) {
+				t.Fatalf("ETag=%q want=%q", etag, tc.wantETag)
+			}
+
+			resp, body := fetch(t, http.DefaultClient, http.MethodGet, srv.URL, map[string]string{"If-None-Match": "W/" + etag})
+			if resp.StatusCode != http.StatusNotModified || body != "" {
+				t.Fatalf("conditional status=%d body=%q want 304", resp.StatusCode, body)
+			}
+
+			resp, body = fetch(t, http.DefaultClient, http.MethodGet, srv.URL, map[string]string{"If-None-Match": `"other"`})
+			if resp.StatusCode != http.StatusOK || body != "payload" {
+				t.Fatalf("mismatched conditional status=%d body=%q want 200", resp.StatusCode, body)
+			}
+
+			resp, body = fetch(t, http.DefaultClient, http.MethodHead, srv.URL, nil)
+			if resp.StatusCode != http.StatusOK || body != "" || resp.ContentLength != int64(len("payload")) {
+				t.Fatalf("HEAD status=%d body=%q length=%d", resp.StatusCode, body, resp.ContentLength)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCoalescesConcurrentMisses(t *testing.T) {
+	t.Parallel()
+
+	var runs atomic.Int64
+	release := make(chan struct{})
+	srv := newCachedHandlerServer(t, httpcache.MiddlewareConfig{}, func(w http.ResponseWriter, _ *http.Request) {
+		runs.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "shared")
+	})
+
+	const clients = 8
+	var started, wg sync.WaitGroup
+	bodies := make([]string, clients)
+	for i := range clients {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			resp, err := http.Get(srv.URL + "/slow")
+			if err != nil {
+				t.Errorf("Get error=%v", err)
+				return
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+			b, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(b)
+		}()
+	}
+	started.Wait()
+	// Give every request time to reach the middleware before the leader
+	// finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := runs.Load(); got != 1 {
+		t.Fatalf("handler runs=%d want=1", got)
+	}
+	for i, body := range bodies {
+		if body != "shared" {
+			t.Fatalf("body[%d]=%q want=%q", i, body, "shared")
+		}
+	}
+}
+
+func TestMiddlewareStreamsUncacheableResponses(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		header map[string]string
+	}{
+		{name: "event stream", header: map[string]string{"Content-Type": "text/event-stream", "Cache-Control": "max-age=60"}},
+		{name: "no-store", header: map[string]string{"Cache-Control": "no-store"}},
+		{name: "private", header: map[string]string{"Cache-Control": "private, max-age=60"}},
+		{name: "flushed", header: map[string]string{"Cache-Control": "max-age=60"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var runs atomic.Int64
+			release := make(chan struct{})
+			srv := newCachedHandlerServer(t, httpcache.MiddlewareConfig{}, func(w http.ResponseWriter, _ *http.Request) {
+				runs.Add(1)
+				for name, value := range tc.header {
+					w.Header().Set(name, value)
+				}
+				_, _ = io.WriteString(w, "data: 1\n\n")
+				w.(http.Flusher).Flush()
+				<-release
+				_, _ = io.WriteString(w, "data: 2\n\n")
+			})
+
+			resp, err := http.Get(srv.URL + "/events")
+			if err != nil {
+				t.Fatalf("Get error=%v", err)
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+
+			// The first event has to arrive while the handler is still running.
+			first := make(chan string, 1)
+			go func() {
+				line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+				first <- line
+			}()
+			select {
+			case line := <-first:
+				if line != "data: 1\n" {
+					t.Fatalf("first line=%q want=%q", line, "data: 1\n")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("first event not streamed before the handler finished")
+			}
+			close(release)
+
+			// Nothing was stored, so the next request runs the handler too.
+			resp2, err := http.Get(srv.URL + "/events")
+			if err != nil {
+				t.Fatalf("Get error=%v", err)
+			}
+			_, _ = io.Copy(io.Discard, resp2.Body)
+			_ = resp2.Body.Close()
+			if got := runs.Load(); got != 2 {
+				t.Fatalf("handler runs=%d want=2", got)
+			}
+		})
+	}
+}
+
+func TestMiddlewareWaiterHonoursContext(t *testing.T) {
+	t.Parallel()
+
+	client, err := nim.New(nim.Config{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	entered, release := make(chan struct{}), make(chan struct{})
+	handler := httpcache.Middleware(client, httpcache.MiddlewareConfig{})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(entered)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "slow")
+	}))
+
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-entered
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter did not return after its context was cancelled")
+	}
+	close(release)
+	<-leaderDone
+}
+
+func TestMiddlewarePassesThroughBodiesTooLargeToStore(t *testing.T) {
+	t.Parallel()
+
+	client, err := nim.New(nim.Config{RootPath: t.TempDir(), MaxBytes: 1024})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	var runs atomic.Int64
+	chunk := strings.Repeat("x", 512)
+	srv := httptest.NewServer(httpcache.Middleware(client, httpcache.MiddlewareConfig{})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		runs.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		for range 8 {
+			_, _ = io.WriteString(w, chunk)
+		}
+	})))
+	t.Cleanup(srv.Close)
+
+	for range 2 {
+		resp, body := doRequest(t, http.MethodGet, srv.URL+"/big", "", nil)
+		if resp.StatusCode != http.StatusOK || body != strings.Repeat(chunk, 8) {
+			t.Fatalf("status=%d body length=%d want %d", resp.StatusCode, len(body), 8*len(chunk))
+		}
+	}
+	if got := runs.Load(); got != 2 {
+		t.Fatalf("handler runs=%d want=2", got)
+	}
+}
+
+func TestMiddlewareKeysOnHost(t *testing.T) {
+	t.Parallel()
+
+	srv := newCachedHandlerServer(t, httpcache.MiddlewareConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "page of "+r.Host)
+	})
+
+	// Host names are case-insensitive, so A.example gets a.example's page.
+	cases := []struct {
+		host string
+		want string
+	}{
+		{host: "a.example", want: "page of a.example"},
+		{host: "b.example", want: "page of b.example"},
+		{host: "A.example", want: "page of a.example"},
+	}
+	for _, tc := range cases {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/", http.NoBody)
+		if err != nil {
+			t.Fatalf("NewRequest error=%v", err)
+		}
+		req.Host = tc.host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Get error=%v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != tc.want {
+			t.Fatalf("Host %s body=%q want=%q", tc.host, body, tc.want)
+		}
+	}
+}
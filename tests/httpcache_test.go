@@ -0,0 +1,415 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/httpcache"
+)
+
+type originServer struct {
+	*httptest.Server
+	hits        atomic.Int64
+	conditional atomic.Int64
+}
+
+func newOriginServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, hit int64)) *originServer {
+	t.Helper()
+
+	o := &originServer{}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit := o.hits.Add(1)
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			o.conditional.Add(1)
+		}
+		handler(w, r, hit)
+	}))
+	t.Cleanup(o.Close)
+	return o
+}
+
+func newCachingClient(t *testing.T, rootPath string) *http.Client {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{RootPath: rootPath})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	return &http.Client{Transport: httpcache.NewTransport(client, nil)}
+}
+
+func fetch(t *testing.T, client *http.Client, method, url string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, http.NoBody)
+	if err != nil {
+		t.Fatalf("NewRequest error=%v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error=%v", method, url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll error=%v", err)
+	}
+	return resp, string(b)
+}
+
+func TestHTTPCacheFreshnessTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		header   func(h http.Header)
+		reqExtra map[string]string
+		second   map[string]string
+		name     string
+		wantHits int64
+	}{
+		{
+			name:     "max-age is served from cache",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			wantHits: 1,
+		},
+		{
+			name: "expires is served from cache",
+			header: func(h http.Header) {
+				h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+				h.Set("Expires", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+			},
+			wantHits: 1,
+		},
+		{
+			name:     "no-store is not cached",
+			header:   func(h http.Header) { h.Set("Cache-Control", "no-store, max-age=60") },
+			wantHits: 2,
+		},
+		{
+			name:     "no explicit freshness is not cached",
+			header:   func(http.Header) {},
+			wantHits: 2,
+		},
+		{
+			name:     "max-age=0 without validators is refetched",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=0") },
+			wantHits: 2,
+		},
+		{
+			name:     "request no-cache goes to origin",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			reqExtra: map[string]string{"Cache-Control": "no-cache"},
+			wantHits: 2,
+		},
+		{
+			name: "vary mismatch is a miss",
+			header: func(h http.Header) {
+				h.Set("Cache-Control", "max-age=60")
+				h.Set("Vary", "Accept-Language")
+			},
+			reqExtra: map[string]string{"Accept-Language": "de"},
+			second:   map[string]string{"Accept-Language": "fr"},
+			wantHits: 2,
+		},
+		{
+			name:     "authorization without public is not cached",
+			header:   func(h http.Header) { h.Set("Cache-Control", "max-age=60") },
+			reqExtra: map[string]string{"Authorization": "Bearer x"},
+			wantHits: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			origin := newOriginServer(t, func(w http.ResponseWriter, _ *http.Request, hit int64) {
+				tc.header(w.Header())
+				_, _ = io.WriteString(w, "body-"+strconv.FormatInt(hit, 10))
+			})
+			client := newCachingClient(t, t.TempDir())
+
+			_, first := fetch(t, client, http.MethodGet, origin.URL, tc.reqExtra)
+			if first != "body-1" {
+				t.Fatalf("first body=%q want=%q", first, "body-1")
+			}
+			second := tc.reqExtra
+			if tc.second != nil {
+				second = tc.second
+			}
+			fetch(t, client, http.MethodGet, origin.URL, second)
+
+			if got := origin.hits.Load(); got != tc.wantHits {
+				t.Fatalf("origin hits=%d want=%d", got, tc.wantHits)
+			}
+		})
+	}
+}
+
+func TestHTTPCacheStoresOnlyCacheableStatuses(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		request map[string]string
+		name    string
+		status  int
+	}{
+		{
+			name:    "not modified without a stored response",
+			status:  http.StatusNotModified,
+			request: map[string]string{"If-None-Match": `"v1"`},
+		},
+		{
+			name:   "partial content",
+			status: http.StatusPartialContent,
+		},
+		{
+			name:   "internal server error",
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			origin := newOriginServer(t, func(w http.ResponseWriter, _ *http.Request, hit int64) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("ETag", `"v1"`)
+				if hit == 1 {
+					w.WriteHeader(tc.status)
+					return
+				}
+				_, _ = io.WriteString(w, "full")
+			})
+			client := newCachingClient(t, t.TempDir())
+
+			if resp, _ := fetch(t, client, http.MethodGet, origin.URL, tc.request); resp.StatusCode != tc.status {
+				t.Fatalf("first status=%d want=%d", resp.StatusCode, tc.status)
+			}
+			resp, body := fetch(t, client, http.MethodGet, origin.URL, nil)
+			if resp.StatusCode != http.StatusOK || body != "full" {
+				t.Fatalf("second status=%d body=%q want 200 full", resp.StatusCode, body)
+			}
+			if got := origin.hits.Load(); got != 2 {
+				t.Fatalf("origin hits=%d want=2", got)
+			}
+		})
+	}
+}
+
+func TestHTTPCacheRevalidationTable(t *testing.T) {
+	t.Parallel()
+
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	cases := []struct {
+		notModified func(r *http.Request) bool
+		validator   func(h http.Header)
+		name        string
+	}{
+		{
+			name:      "etag",
+			validator: func(h http.Header) { h.Set("ETag", `"v1"`) },
+			notModified: func(r *http.Request) bool {
+				return r.Header.Get("If-None-Match") == `"v1"`
+			},
+		},
+		{
+			name:      "last-modified",
+			validator: func(h http.Header) { h.Set("Last-Modified", lastModified) },
+			notModified: func(r *http.Request) bool {
+				return r.Header.Get("If-Modified-Since") == lastModified
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			origin := newOriginServer(t, func(w http.ResponseWriter, r *http.Request, _ int64) {
+				w.Header().Set("Cache-Control", "no-cache")
+				tc.validator(w.Header())
+				if tc.notModified(r) {
+					w.Header().Set("X-Revalidated", "yes")
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				_, _ = io.WriteString(w, "payload")
+			})
+			client := newCachingClient(t, t.TempDir())
+
+			fetch(t, client, http.MethodGet, origin.URL, nil)
+			resp, body := fetch(t, client, http.MethodGet, origin.URL, nil)
+
+			if body != "payload" || resp.StatusCode != http.StatusOK {
+				t.Fatalf("revalidated response status=%d body=%q", resp.StatusCode, body)
+			}
+			if resp.Header.Get(httpcache.XFromCache) != "1" || resp.Header.Get("X-Revalidated") != "yes" {
+				t.Fatalf("revalidated headers=%v", resp.Header)
+			}
+			if hits, cond := origin.hits.Load(), origin.conditional.Load(); hits != 2 || cond != 1 {
+				t.Fatalf("origin hits=%d conditional=%d want 2 and 1", hits, cond)
+			}
+		})
+	}
+}
+
+func TestHTTPCacheSurvivesRestart(t *testing.T) {
+	t.Parallel()
+
+	origin := newOriginServer(t, func(w http.ResponseWriter, _ *http.Request, hit int64) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "body-"+strconv.FormatInt(hit, 10))
+	})
+	rootPath := t.TempDir()
+
+	fetch(t, newCachingClient(t, rootPath), http.MethodGet, origin.URL, nil)
+	resp, body := fetch(t, newCachingClient(t, rootPath), http.MethodGet, origin.URL, nil)
+
+	if body != "body-1" || origin.hits.Load() != 1 {
+		t.Fatalf("body=%q origin hits=%d want cached body-1", body, origin.hits.Load())
+	}
+	if age, err := strconv.Atoi(resp.Header.Get("Age")); err != nil || age < 0 {
+		t.Fatalf("Age=%q want non-negative seconds", resp.Header.Get("Age"))
+	}
+}
+
+func TestHTTPCacheUnsafeMethodInvalidates(t *testing.T) {
+	t.Parallel()
+
+	origin := newOriginServer(t, func(w http.ResponseWriter, _ *http.Request, hit int64) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "body-"+strconv.FormatInt(hit, 10))
+	})
+	client := newCachingClient(t, t.TempDir())
+
+	fetch(t, client, http.MethodGet, origin.URL, nil)
+	fetch(t, client, http.MethodPost, origin.URL, nil)
+	_, body := fetch(t, client, http.MethodGet, origin.URL, nil)
+
+	if body != "body-3" {
+		t.Fatalf("body after POST=%q want=%q", body, "body-3")
+	}
+}
+
+func TestHTTPCacheOnlyIfCached(t *testing.T) {
+	t.Parallel()
+
+	origin := newOriginServer(t, func(w http.ResponseWriter, _ *http.Request, _ int64) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "body")
+	})
+	client := newCachingClient(t, t.TempDir())
+	onlyCached := map[string]string{"Cache-Control": "only-if-cached"}
+
+	resp, _ := fetch(t, client, http.MethodGet, origin.URL, onlyCached)
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("status=%d want=%d", resp.StatusCode, http.StatusGatewayTimeout)
+	}
+
+	fetch(t, client, http.MethodGet, origin.URL, nil)
+	resp, body := fetch(t, client, http.MethodGet, origin.URL, onlyCached)
+	if resp.StatusCode != http.StatusOK || body != "body" || origin.hits.Load() != 1 {
+		t.Fatalf("status=%d body=%q hits=%d want cached response", resp.StatusCode, body, origin.hits.Load())
+	}
+}
+
+func TestHTTPCacheStreamsBodiesTooLargeToStore(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		chunked bool
+	}{
+		{name: "content length"},
+		{name: "chunked", chunked: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			body := strings.Repeat("x", 4096)
+			origin := newOriginServer(t, func(w http.ResponseWriter, _ *http.Request, _ int64) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				if !tc.chunked {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				}
+				_, _ = io.WriteString(w, body[:1024])
+				w.(http.Flusher).Flush()
+				_, _ = io.WriteString(w, body[1024:])
+			})
+
+			client, err := nim.New(nim.Config{RootPath: t.TempDir(), MaxBytes: 1024})
+			if err != nil {
+				t.Fatalf("New error=%v", err)
+			}
+			httpClient := &http.Client{Transport: httpcache.NewTransport(client, nil)}
+			for range 2 {
+				resp, got := fetch(t, httpClient, http.MethodGet, origin.URL, nil)
+				if got != body || resp.Header.Get(httpcache.XFromCache) != "" {
+					t.Fatalf("body length=%d from cache=%q want the origin's %d bytes", len(got), resp.Header.Get(httpcache.XFromCache), len(body))
+				}
+			}
+			if got := origin.hits.Load(); got != 2 {
+				t.Fatalf("origin hits=%d want=2", got)
+			}
+		})
+	}
+}
+
+func TestHTTPCacheStreamsResponseBeforeStoring(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	origin := newOriginServer(t, func(w http.ResponseWriter, _ *http.Request, hit int64) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		if hit == 1 {
+			<-release
+		}
+		_, _ = io.WriteString(w, "second\n")
+	})
+	client := newCachingClient(t, t.TempDir())
+
+	// The response has to arrive while the origin is still sending it.
+	timer := time.AfterFunc(5*time.Second, func() { close(release) })
+	resp, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatalf("Get error=%v", err)
+	}
+	if !timer.Stop() {
+		t.Fatal("response held back until the whole body was read")
+	}
+	r := bufio.NewReader(resp.Body)
+	if line, err := r.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf("first line=%q error=%v", line, err)
+	}
+	close(release)
+	if rest, err := io.ReadAll(r); err != nil || string(rest) != "second\n" {
+		t.Fatalf("rest=%q error=%v", rest, err)
+	}
+	_ = resp.Body.Close()
+
+	// Read to the end, the response was stored.
+	resp, body := fetch(t, client, http.MethodGet, origin.URL, nil)
+	if body != "first\nsecond\n" || resp.Header.Get(httpcache.XFromCache) != "1" {
+		t.Fatalf("body=%q from cache=%q want a cached copy", body, resp.Header.Get(httpcache.XFromCache))
+	}
+}
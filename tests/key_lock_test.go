@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+// deadPID is above the kernel's maximum pid_max, so it never names a live
+// process.
+const deadPID = 1 << 23
+
+func holdExternalLock(t *testing.T, lockPath, holder string) *os.File {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0o755); err != nil {
+		t.Fatalf("MkdirAll error=%v", err)
+	}
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile(lock) error=%v", err)
+	}
+	t.Cleanup(func() {
+		_ = f.Close()
+	})
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatalf("Flock(lock) error=%v", err)
+	}
+	if _, err := f.WriteString(holder); err != nil {
+		t.Fatalf("WriteString(holder) error=%v", err)
+	}
+	return f
+}
+
+func TestKeyLockBlocksWritersUntilUnlock(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "key lock blocks writers", 1024)
+	key := "cron::nightly"
+
+	lock, err := client.Lock(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Lock error=%v", err)
+	}
+	if lock.Key() != key {
+		t.Fatalf("Key()=%q want=%q", lock.Key(), key)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Set(key, "value", 0)
+	}()
+
+	select {
+	case opErr := <-done:
+		t.Fatalf("Set completed while key lock held, err=%v", opErr)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock error=%v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("second Unlock error=%v", err)
+	}
+
+	select {
+	case opErr := <-done:
+		if opErr != nil {
+			t.Fatalf("Set error=%v", opErr)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("Set did not complete after Unlock")
+	}
+}
+
+func TestKeyLockContentionTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		wantErr error
+		name    string
+		timeout time.Duration
+		useTry  bool
+	}{
+		{
+			name:   "try lock reports held",
+			useTry: true,
+		},
+		{
+			name:    "lock honours context deadline",
+			timeout: 30 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newClientForCase(t, "key lock "+tc.name, 1024)
+			key := "cron::hourly"
+
+			held, err := client.Lock(context.Background(), key)
+			if err != nil {
+				t.Fatalf("Lock error=%v", err)
+			}
+			defer func() {
+				_ = held.Unlock()
+			}()
+
+			if tc.useTry {
+				lock, ok, err := client.TryLock(key)
+				if err != nil {
+					t.Fatalf("TryLock error=%v", err)
+				}
+				if ok || lock != nil {
+					t.Fatalf("TryLock ok=%v lock=%v want held", ok, lock)
+				}
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			if _, err := client.Lock(ctx, key); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Lock error=%v wantErr=%v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyLockBreaksStaleHolderTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		holder    string
+		name      string
+		wantTaken bool
+	}{
+		{
+			name:      "dead holder pid",
+			holder:    fmt.Sprintf("%d 0\n", deadPID),
+			wantTaken: true,
+		},
+		{
+			name:      "expired lease",
+			holder:    fmt.Sprintf("%d %d\n", os.Getpid(), time.Now().Add(-time.Second).UnixNano()),
+			wantTaken: true,
+		},
+		{
+			name:      "live holder with valid lease",
+			holder:    fmt.Sprintf("%d %d\n", os.Getpid(), time.Now().Add(time.Hour).UnixNano()),
+			wantTaken: false,
+		},
+		{
+			name:      "holder without metadata",
+			holder:    "",
+			wantTaken: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			caseName := "key lock stale " + tc.name
+			client := newClientForCase(t, caseName, 1024)
+			key := "cron::daily"
+
+			lockPath := cacheKeyDir(caseRootPath(t, caseName), key) + ".lock"
+			holdExternalLock(t, lockPath, tc.holder)
+
+			lock, ok, err := client.TryLock(key)
+			if err != nil {
+				t.Fatalf("TryLock error=%v", err)
+			}
+			if ok != tc.wantTaken {
+				t.Fatalf("TryLock ok=%v want=%v", ok, tc.wantTaken)
+			}
+			if ok {
+				if err := lock.Refresh(); err != nil {
+					t.Fatalf("Refresh error=%v", err)
+				}
+				if err := lock.Unlock(); err != nil {
+					t.Fatalf("Unlock error=%v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyLockLeaseLostAfterBreak(t *testing.T) {
+	t.Parallel()
+
+	rootPath := t.TempDir()
+	holder, err := nim.New(nim.Config{RootPath: rootPath, LockLease: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	contender, err := nim.New(nim.Config{RootPath: rootPath})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	key := "cron::lease"
+	lock, err := holder.Lock(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Lock error=%v", err)
+	}
+	if err := lock.Refresh(); err != nil {
+		t.Fatalf("Refresh error=%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	taken, err := contender.Lock(ctx, key)
+	if err != nil {
+		t.Fatalf("contender Lock error=%v", err)
+	}
+	defer func() {
+		_ = taken.Unlock()
+	}()
+
+	if err := lock.Refresh(); !errors.Is(err, nim.ErrCacheLockLost) {
+		t.Fatalf("Refresh error=%v wantErr=%v", err, nim.ErrCacheLockLost)
+	}
+	_ = lock.Unlock()
+}
@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+func newL1Client(t *testing.T, rootPath string, entries int, verify bool) *nim.Client {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{
+		RootPath:  rootPath,
+		MaxBytes:  1024,
+		L1Entries: entries,
+		L1Verify:  verify,
+	})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	return client
+}
+
+func overwriteCacheFile(t *testing.T, rootPath, key, value string) {
+	t.Helper()
+
+	cachePath := filepath.Join(cacheKeyDir(rootPath, key), "cache")
+	if err := os.WriteFile(cachePath, []byte(value), 0o644); err != nil {
+		t.Fatalf("WriteFile(cache) error=%v", err)
+	}
+}
+
+func TestL1ServesFromMemoryTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		want   string
+		verify bool
+	}{
+		{name: "without verify serves memory copy", verify: false, want: "first"},
+		{name: "verify detects external change", verify: true, want: "second-longer"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootPath := t.TempDir()
+			client := newL1Client(t, rootPath, 8, tc.verify)
+			key := "l1::item"
+
+			if err := client.Set(key, "first", 0); err != nil {
+				t.Fatalf("Set error=%v", err)
+			}
+			assertGetStringValue(t, client, key, "first")
+
+			overwriteCacheFile(t, rootPath, key, "second-longer")
+			assertGetStringValue(t, client, key, tc.want)
+		})
+	}
+}
+
+func TestL1CrossProcessInvalidation(t *testing.T) {
+	t.Parallel()
+
+	rootPath := t.TempDir()
+	reader := newL1Client(t, rootPath, 8, true)
+	writer := newL1Client(t, rootPath, 8, true)
+	key := "l1::shared"
+
+	if err := writer.Set(key, "v1", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	assertGetStringValue(t, reader, key, "v1")
+
+	if err := writer.Set(key, "v2", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	assertGetStringValue(t, reader, key, "v2")
+
+	if err := writer.Remove(key); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	exists, err := reader.Exists(key)
+	if err != nil {
+		t.Fatalf("Exists error=%v", err)
+	}
+	if exists {
+		t.Fatalf("Exists=true want=false after remove by other client")
+	}
+}
+
+func TestL1LocalInvalidationTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		mutate func(client *nim.Client) error
+		name   string
+		key    string
+		want   string
+		wantOK bool
+	}{
+		{
+			name: "set replaces value",
+			key:  "l1::user::1",
+			mutate: func(client *nim.Client) error {
+				return client.Set("l1::user::1", "updated", 0)
+			},
+			want:   "updated",
+			wantOK: true,
+		},
+		{
+			name: "remove drops value",
+			key:  "l1::user::1",
+			mutate: func(client *nim.Client) error {
+				return client.Remove("l1::user::1")
+			},
+			wantOK: false,
+		},
+		{
+			name: "remove of parent drops nested value",
+			key:  "l1::user::1",
+			mutate: func(client *nim.Client) error {
+				return client.Remove("l1::user")
+			},
+			wantOK: false,
+		},
+		{
+			name: "txn set replaces value",
+			key:  "l1::user::1",
+			mutate: func(client *nim.Client) error {
+				return client.Txn([]string{"l1::user::1"}, func(tx *nim.Tx) error {
+					return tx.Set("l1::user::1", "from-txn", 0)
+				})
+			},
+			want:   "from-txn",
+			wantOK: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newL1Client(t, t.TempDir(), 8, false)
+			if err := client.Set(tc.key, "original", 0); err != nil {
+				t.Fatalf("Set error=%v", err)
+			}
+			assertGetStringValue(t, client, tc.key, "original")
+
+			if err := tc.mutate(client); err != nil {
+				t.Fatalf("mutate error=%v", err)
+			}
+
+			var out string
+			ok, err := client.Get(tc.key, &out)
+			if err != nil {
+				t.Fatalf("Get error=%v", err)
+			}
+			if ok != tc.wantOK || out != tc.want {
+				t.Fatalf("Get ok=%v value=%q want ok=%v value=%q", ok, out, tc.wantOK, tc.want)
+			}
+		})
+	}
+}
+
+func TestL1RespectsTTL(t *testing.T) {
+	t.Parallel()
+
+	client := newL1Client(t, t.TempDir(), 8, false)
+	key := "l1::ttl"
+	if err := client.Set(key, "value", 15*time.Millisecond); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	assertGetStringValue(t, client, key, "value")
+
+	time.Sleep(35 * time.Millisecond)
+	var out string
+	ok, err := client.Get(key, &out)
+	if err != nil {
+		t.Fatalf("Get error=%v", err)
+	}
+	if ok {
+		t.Fatalf("Get ok=%v value=%q want miss after ttl", ok, out)
+	}
+}
+
+func TestL1EvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	rootPath := t.TempDir()
+	client := newL1Client(t, rootPath, 2, false)
+	keys := []string{"l1::a", "l1::b", "l1::c"}
+	for _, key := range keys {
+		if err := client.Set(key, key+"-v1", 0); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+		assertGetStringValue(t, client, key, key+"-v1")
+	}
+
+	for _, key := range keys {
+		overwriteCacheFile(t, rootPath, key, key+"-v2")
+	}
+
+	assertGetStringValue(t, client, "l1::a", "l1::a-v2")
+	assertGetStringValue(t, client, "l1::c", "l1::c-v1")
+}
+
+func TestL1ReturnsIsolatedBytes(t *testing.T) {
+	t.Parallel()
+
+	client := newL1Client(t, t.TempDir(), 8, false)
+	key := "l1::bytes"
+	if err := client.Set(key, []byte("abc"), 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	var first []byte
+	if _, err := client.Get(key, &first); err != nil {
+		t.Fatalf("Get error=%v", err)
+	}
+	first[0] = 'x'
+
+	var second []byte
+	if _, err := client.Get(key, &second); err != nil {
+		t.Fatalf("Get error=%v", err)
+	}
+	if string(second) != "abc" {
+		t.Fatalf("Get value=%q want=%q", second, "abc")
+	}
+}
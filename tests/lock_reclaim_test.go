@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+func hashedLockPath(lockDir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(lockDir, name[:2], name+".lock")
+}
+
+func listLockFiles(t *testing.T, rootPath string) []string {
+	t.Helper()
+
+	var out []string
+	err := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".lock") {
+			out = append(out, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir(%s) error=%v", rootPath, err)
+	}
+	return out
+}
+
+func TestLockFileReclaimTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		op        func(client *nim.Client, key string) error
+		name      string
+		wantLocks int
+	}{
+		{
+			name: "set keeps lock file",
+			op: func(client *nim.Client, key string) error {
+				return client.Set(key, "value", 0)
+			},
+			wantLocks: 1,
+		},
+		{
+			name: "remove reclaims lock file",
+			op: func(client *nim.Client, key string) error {
+				if err := client.Set(key, "value", 0); err != nil {
+					return err
+				}
+				return client.Remove(key)
+			},
+			wantLocks: 0,
+		},
+		{
+			name: "lazy expiry reclaims lock file",
+			op: func(client *nim.Client, key string) error {
+				if err := client.Set(key, "value", 5*time.Millisecond); err != nil {
+					return err
+				}
+				time.Sleep(15 * time.Millisecond)
+				_, err := client.Exists(key)
+				return err
+			},
+			wantLocks: 0,
+		},
+		{
+			name: "key lock on missing key reclaims lock file",
+			op: func(client *nim.Client, key string) error {
+				lock, err := client.Lock(context.Background(), key)
+				if err != nil {
+					return err
+				}
+				return lock.Unlock()
+			},
+			wantLocks: 0,
+		},
+		{
+			name: "txn remove reclaims lock file",
+			op: func(client *nim.Client, key string) error {
+				if err := client.Set(key, "value", 0); err != nil {
+					return err
+				}
+				return client.Txn([]string{key}, func(tx *nim.Tx) error {
+					return tx.Remove(key)
+				})
+			},
+			wantLocks: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			caseName := "lock reclaim " + tc.name
+			client := newClientForCase(t, caseName, 1024)
+
+			if err := tc.op(client, "reclaim::item"); err != nil {
+				t.Fatalf("op error=%v", err)
+			}
+
+			locks := listLockFiles(t, caseRootPath(t, caseName))
+			if len(locks) != tc.wantLocks {
+				t.Fatalf("lock files=%v want count=%d", locks, tc.wantLocks)
+			}
+		})
+	}
+}
+
+func TestLockFileReclaimWaiterRetries(t *testing.T) {
+	t.Parallel()
+
+	const caseName = "lock reclaim waiter retries"
+	rootPath := caseRootPath(t, caseName)
+	remover := newClientForCase(t, caseName, 1024)
+	writer, err := nim.New(nim.Config{RootPath: rootPath, MaxBytes: 1024})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	key := "reclaim::race"
+	if err := remover.Set(key, "seed", 0); err != nil {
+		t.Fatalf("Set(seed) error=%v", err)
+	}
+
+	lock, err := remover.Lock(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Lock error=%v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- writer.Set(key, "after", 0)
+	}()
+	time.Sleep(30 * time.Millisecond)
+
+	if err := os.RemoveAll(cacheKeyDir(rootPath, key)); err != nil {
+		t.Fatalf("RemoveAll error=%v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock error=%v", err)
+	}
+
+	select {
+	case opErr := <-done:
+		if opErr != nil {
+			t.Fatalf("Set error=%v", opErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Set did not complete after reclaim")
+	}
+
+	assertGetStringValue(t, writer, key, "after")
+	locks := listLockFiles(t, rootPath)
+	if len(locks) != 1 {
+		t.Fatalf("lock files=%v want one fresh lock file", locks)
+	}
+}
+
+func TestLockDirKeepsKeyTreeClean(t *testing.T) {
+	t.Parallel()
+
+	rootPath := filepath.Join(t.TempDir(), "root")
+	lockDir := filepath.Join(t.TempDir(), "locks")
+	client, err := nim.New(nim.Config{RootPath: rootPath, LockDir: lockDir})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	key := "user::7::orders"
+	if err := client.Set(key, "value", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	if locks := listLockFiles(t, rootPath); len(locks) != 0 {
+		t.Fatalf("lock files in key tree=%v want none", locks)
+	}
+	lockPath := hashedLockPath(lockDir, key)
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Fatalf("Stat(hashed lock) error=%v", err)
+	}
+
+	f := holdExternalLock(t, lockPath, "")
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Set(key, "blocked", 0)
+	}()
+
+	select {
+	case opErr := <-done:
+		t.Fatalf("Set completed while hashed lock held, err=%v", opErr)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatalf("Flock(unlock) error=%v", err)
+	}
+	select {
+	case opErr := <-done:
+		if opErr != nil {
+			t.Fatalf("Set error=%v", opErr)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("Set did not complete after hashed lock release")
+	}
+
+	if err := client.Remove(key); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	if _, err := os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat(hashed lock) error=%v want not exist after Remove", err)
+	}
+}
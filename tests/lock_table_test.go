@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLockTableReleasesFlockTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		workers int
+		writes  int
+	}{
+		{name: "single writer", workers: 1, writes: 5},
+		{name: "contended writers", workers: 8, writes: 20},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			caseName := "lock table release " + tc.name
+			client := newClientForCase(t, caseName, 1024)
+			key := "lock::table"
+
+			var wg sync.WaitGroup
+			errCh := make(chan error, tc.workers*tc.writes)
+			for worker := range tc.workers {
+				wg.Go(func() {
+					for write := range tc.writes {
+						errCh <- client.Set(key, fmt.Sprintf("worker-%d-write-%d", worker, write), 0)
+					}
+				})
+			}
+			wg.Wait()
+			close(errCh)
+			for err := range errCh {
+				if err != nil {
+					t.Fatalf("Set error=%v", err)
+				}
+			}
+
+			lockPath := cacheKeyDir(caseRootPath(t, caseName), key) + ".lock"
+			f, err := os.OpenFile(lockPath, os.O_RDWR, 0o644)
+			if err != nil {
+				t.Fatalf("OpenFile(lock) error=%v", err)
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+				t.Fatalf("Flock(lock) after writers error=%v want lock released", err)
+			}
+		})
+	}
+}
+
+func TestLockTableDoesNotStarveOtherProcesses(t *testing.T) {
+	t.Parallel()
+
+	const caseName = "lock table no starvation"
+	client := newClientForCase(t, caseName, 1024)
+	key := "lock::starve"
+	if err := client.Set(key, "seed", 0); err != nil {
+		t.Fatalf("Set(seed) error=%v", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_ = client.Set(key, "busy", 0)
+			}
+		})
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	lockPath := cacheKeyDir(caseRootPath(t, caseName), key) + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile(lock) error=%v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	}()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("Flock(lock) error=%v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("external flock starved by in-process writers")
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatalf("Flock(unlock) error=%v", err)
+	}
+}
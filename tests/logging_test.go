@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+type logBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the JSON log lines with msg.
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]any
+	for line := range strings.Lines(b.buf.String()) {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if rec["msg"] == msg {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+func newLoggingClient(t *testing.T, cfg nim.Config) (*nim.Client, *logBuffer) {
+	t.Helper()
+
+	logs := &logBuffer{}
+	cfg.RootPath = filepath.Join(t.TempDir(), "cache")
+	cfg.Logger = slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client, err := nim.New(cfg)
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	return client, logs
+}
+
+func TestLoggingSlowLockAcquisition(t *testing.T) {
+	t.Parallel()
+
+	client, logs := newLoggingClient(t, nim.Config{})
+	locked := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Txn([]string{"users::1"}, func(*nim.Tx) error {
+			close(locked)
+			time.Sleep(150 * time.Millisecond)
+			return nil
+		})
+	}()
+	<-locked
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Txn error=%v", err)
+	}
+
+	recs := logs.records(t, "slow lock acquisition")
+	if len(recs) != 1 {
+		t.Fatalf("slow lock records=%v want 1", recs)
+	}
+	if recs[0]["level"] != "WARN" || recs[0]["op"] != "set" || recs[0]["key"] != "users::1" {
+		t.Fatalf("slow lock record=%v", recs[0])
+	}
+}
+
+func TestLoggingL1Eviction(t *testing.T) {
+	t.Parallel()
+
+	client, logs := newLoggingClient(t, nim.Config{L1Entries: 1})
+	var s string
+	for _, key := range []string{"users::1", "users::2"} {
+		if err := client.Set(key, "alice", 0); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+		if _, err := client.Get(key, &s); err != nil {
+			t.Fatalf("Get error=%v", err)
+		}
+	}
+
+	recs := logs.records(t, "evicted from memory tier")
+	if len(recs) != 1 || recs[0]["level"] != "DEBUG" || recs[0]["key"] != "users::1" {
+		t.Fatalf("eviction records=%v", recs)
+	}
+}
+
+func TestLoggingExpiredRemoval(t *testing.T) {
+	t.Parallel()
+
+	client, logs := newLoggingClient(t, nim.Config{})
+	if err := client.Set("users::1", "alice", time.Millisecond); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, err := client.Exists("users::1"); err != nil || ok {
+		t.Fatalf("Exists ok=%v error=%v", ok, err)
+	}
+
+	recs := logs.records(t, "removed expired entry")
+	if len(recs) != 1 || recs[0]["op"] != "exists" || recs[0]["key"] != "users::1" {
+		t.Fatalf("expired records=%v", recs)
+	}
+}
+
+func TestLoggingMemoizeStoreFailure(t *testing.T) {
+	t.Parallel()
+
+	client, logs := newLoggingClient(t, nim.Config{MaxBytes: 16})
+	get := nim.Memoize(client, nim.MemoizeConfig[int]{Key: userKey, TTL: time.Hour},
+		func(context.Context, int) (string, error) {
+			return strings.Repeat("x", 64), nil
+		})
+	if _, err := get(context.Background(), 1); err != nil {
+		t.Fatalf("get error=%v", err)
+	}
+
+	recs := logs.records(t, "failed to store memoized result")
+	if len(recs) != 1 || recs[0]["level"] != "WARN" || recs[0]["key"] != userKey(1) {
+		t.Fatalf("store records=%v", recs)
+	}
+	if err, _ := recs[0]["err"].(string); !strings.Contains(err, nim.ErrCacheValueTooLarge.Error()) {
+		t.Fatalf("store err=%q", err)
+	}
+}
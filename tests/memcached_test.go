@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/memcached"
+)
+
+type memcachedConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startMemcachedServer(t *testing.T) (*nim.Client, string) {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{RootPath: t.TempDir(), MaxBytes: 64})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error=%v", err)
+	}
+
+	srv := memcached.NewServer(client)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+		if err := <-done; !errors.Is(err, memcached.ErrServerClosed) {
+			t.Errorf("Serve error=%v want=%v", err, memcached.ErrServerClosed)
+		}
+	})
+	return client, l.Addr().String()
+}
+
+func dialMemcached(t *testing.T, addr string) *memcachedConn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial error=%v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return &memcachedConn{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends raw and reads reply lines until one that is neither a VALUE
+// header nor the data block following it.
+func (c *memcachedConn) do(t *testing.T, raw string) string {
+	t.Helper()
+
+	if _, err := io.WriteString(c.conn, raw); err != nil {
+		t.Fatalf("write error=%v", err)
+	}
+
+	var reply strings.Builder
+	inValue := false
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read reply to %q error=%v", raw, err)
+		}
+		reply.WriteString(line)
+
+		switch {
+		case inValue:
+			inValue = false
+		case strings.HasPrefix(line, "VALUE "):
+			inValue = true
+		default:
+			return reply.String()
+		}
+	}
+}
+
+func memcachedCAS(t *testing.T, reply string) string {
+	t.Helper()
+
+	fields := strings.Fields(strings.SplitN(reply, "\r\n", 2)[0])
+	if len(fields) != 5 || fields[0] != "VALUE" {
+		t.Fatalf("gets reply=%q want VALUE line with cas", reply)
+	}
+	return fields[4]
+}
+
+func TestMemcachedCommandTable(t *testing.T) {
+	t.Parallel()
+
+	client, addr := startMemcachedServer(t)
+	conn := dialMemcached(t, addr)
+
+	cases := []struct {
+		send string
+		want string
+	}{
+		{send: "get user:1\r\n", want: "END\r\n"},
+		{send: "set user:1 0 0 5\r\nalice\r\n", want: "STORED\r\n"},
+		{send: "get user:1\r\n", want: "VALUE user:1 0 5\r\nalice\r\nEND\r\n"},
+		{send: "add user:1 0 0 3\r\nbob\r\n", want: "NOT_STORED\r\n"},
+		{send: "add user:2 0 0 3\r\nbob\r\n", want: "STORED\r\n"},
+		{send: "replace user:3 0 0 3\r\neve\r\n", want: "NOT_STORED\r\n"},
+		{send: "replace user:2 0 0 5\r\nbobby\r\n", want: "STORED\r\n"},
+		{send: "get user:1 user:2 user:3\r\n", want: "VALUE user:1 0 5\r\nalice\r\nVALUE user:2 0 5\r\nbobby\r\nEND\r\n"},
+		{send: "set flagged 42 0 2\r\nhi\r\n", want: "STORED\r\n"},
+		{send: "get flagged\r\n", want: "VALUE flagged 42 2\r\nhi\r\nEND\r\n"},
+		{send: "set n 0 0 2\r\n10\r\n", want: "STORED\r\n"},
+		{send: "incr n 5\r\n", want: "15\r\n"},
+		{send: "decr n 100\r\n", want: "0\r\n"},
+		{send: "incr user:1 1\r\n", want: "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{send: "incr missing 1\r\n", want: "NOT_FOUND\r\n"},
+		{send: "set max 0 0 20\r\n18446744073709551615\r\n", want: "STORED\r\n"},
+		{send: "incr max 2\r\n", want: "1\r\n"},
+		{send: "touch user:1 100\r\n", want: "TOUCHED\r\n"},
+		{send: "touch missing 100\r\n", want: "NOT_FOUND\r\n"},
+		{send: "set user 0 0 4\r\nroot\r\n", want: "STORED\r\n"},
+		{send: "delete user\r\n", want: "DELETED\r\n"},
+		{send: "delete user\r\n", want: "NOT_FOUND\r\n"},
+		{send: "get user:1\r\n", want: "VALUE user:1 0 5\r\nalice\r\nEND\r\n"},
+		{send: "set gone 0 -1 1\r\nx\r\n", want: "STORED\r\n"},
+		{send: "get gone\r\n", want: "END\r\n"},
+		{send: "set noreply 0 0 1 noreply\r\nx\r\nget noreply\r\n", want: "VALUE noreply 0 1\r\nx\r\nEND\r\n"},
+		{send: "set big 0 0 65\r\n" + strings.Repeat("x", 65) + "\r\n", want: "SERVER_ERROR object too large for cache\r\n"},
+		{send: "set bad 0 0 1\r\nxyz\r\n", want: "CLIENT_ERROR bad data chunk\r\n"},
+		{send: "set a::b 0 0 1\r\nx\r\n", want: "CLIENT_ERROR bad command line format\r\n"},
+		{send: "set ..:..:evil 0 0 1\r\nx\r\n", want: "CLIENT_ERROR bad command line format\r\n"},
+		{send: "get ..:..:evil\r\n", want: "END\r\n"},
+		{send: "flush_all\r\n", want: "ERROR\r\n"},
+		{send: "version\r\n", want: "VERSION nim\r\n"},
+	}
+
+	for _, tc := range cases {
+		if got := conn.do(t, tc.send); got != tc.want {
+			t.Fatalf("%q reply=%q want=%q", tc.send, got, tc.want)
+		}
+	}
+
+	info, ok, err := client.Stat("user::1")
+	if err != nil || !ok {
+		t.Fatalf("Stat ok=%v error=%v", ok, err)
+	}
+	if remaining := time.Until(info.Expiry); remaining <= 0 || remaining > 100*time.Second {
+		t.Fatalf("Stat expiry in %v want within 100s after touch", remaining)
+	}
+}
+
+func TestMemcachedSkipsOversizedItems(t *testing.T) {
+	t.Parallel()
+
+	_, addr := startMemcachedServer(t)
+	conn := dialMemcached(t, addr)
+
+	// The data block is discarded, so the next command is read as one.
+	const size = 2 << 20
+	send := "set huge 0 0 " + strconv.Itoa(size) + "\r\n" + strings.Repeat("x", size) + "\r\n"
+	if got := conn.do(t, send); got != "SERVER_ERROR object too large for cache\r\n" {
+		t.Fatalf("set reply=%q", got)
+	}
+	if got := conn.do(t, "get huge\r\n"); got != "END\r\n" {
+		t.Fatalf("get reply=%q", got)
+	}
+}
+
+func TestMemcachedCAS(t *testing.T) {
+	t.Parallel()
+
+	_, addr := startMemcachedServer(t)
+	conn := dialMemcached(t, addr)
+	other := dialMemcached(t, addr)
+
+	if got := conn.do(t, "cas item 0 0 1 1\r\nx\r\n"); got != "NOT_FOUND\r\n" {
+		t.Fatalf("cas on missing reply=%q want=NOT_FOUND", got)
+	}
+	conn.do(t, "set item 0 0 2\r\nv1\r\n")
+	cas := memcachedCAS(t, conn.do(t, "gets item\r\n"))
+
+	wrong := "1"
+	if cas == wrong {
+		wrong = "2"
+	}
+	if got := conn.do(t, "cas item 0 0 2 "+wrong+"\r\nv2\r\n"); got != "EXISTS\r\n" {
+		t.Fatalf("cas with wrong unique reply=%q want=EXISTS", got)
+	}
+
+	// Another client writes in between; the stale unique must be rejected.
+	other.do(t, "set item 0 0 2\r\nv3\r\n")
+	if got := conn.do(t, "cas item 0 0 2 "+cas+"\r\nv2\r\n"); got != "EXISTS\r\n" {
+		t.Fatalf("cas after concurrent write reply=%q want=EXISTS", got)
+	}
+
+	cas = memcachedCAS(t, conn.do(t, "gets item\r\n"))
+	if got := conn.do(t, "cas item 0 0 2 "+cas+"\r\nv4\r\n"); got != "STORED\r\n" {
+		t.Fatalf("cas with current unique reply=%q want=STORED", got)
+	}
+	if got := conn.do(t, "get item\r\n"); got != "VALUE item 0 2\r\nv4\r\nEND\r\n" {
+		t.Fatalf("get reply=%q", got)
+	}
+}
+
+func TestMemcachedExptimeTable(t *testing.T) {
+	t.Parallel()
+
+	client, addr := startMemcachedServer(t)
+	conn := dialMemcached(t, addr)
+
+	cases := []struct {
+		name    string
+		exptime string
+		minTTL  time.Duration
+		maxTTL  time.Duration
+		noTTL   bool
+	}{
+		{name: "zero", exptime: "0", noTTL: true},
+		{name: "relative", exptime: "60", minTTL: 59 * time.Second, maxTTL: 60 * time.Second},
+		{
+			name:    "absolute",
+			exptime: strconv.FormatInt(time.Now().Add(2*time.Hour).Unix(), 10),
+			minTTL:  2*time.Hour - 2*time.Second,
+			maxTTL:  2 * time.Hour,
+		},
+	}
+
+	for _, tc := range cases {
+		key := "exp:" + tc.name
+		if got := conn.do(t, "set "+key+" 0 "+tc.exptime+" 1\r\nx\r\n"); got != "STORED\r\n" {
+			t.Fatalf("%s set reply=%q", tc.name, got)
+		}
+
+		info, ok, err := client.Stat("exp::" + tc.name)
+		if err != nil || !ok {
+			t.Fatalf("%s Stat ok=%v error=%v", tc.name, ok, err)
+		}
+		if tc.noTTL {
+			if !info.Expiry.IsZero() {
+				t.Fatalf("%s expiry=%v want none", tc.name, info.Expiry)
+			}
+			continue
+		}
+		if ttl := time.Until(info.Expiry); ttl < tc.minTTL || ttl > tc.maxTTL {
+			t.Fatalf("%s ttl=%v want between %v and %v", tc.name, ttl, tc.minTTL, tc.maxTTL)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+var errUpstream = errors.New("upstream failed")
+
+type memoUser struct {
+	Name string
+	ID   int
+}
+
+func userKey(id int) string {
+	return "memo::user::" + strconv.Itoa(id)
+}
+
+func TestMemoizeCachesResults(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "memoize caches results", 1024)
+	var calls atomic.Int32
+	get := nim.Memoize(client, nim.MemoizeConfig[int]{Key: userKey, TTL: time.Hour},
+		func(_ context.Context, id int) (memoUser, error) {
+			calls.Add(1)
+			return memoUser{ID: id, Name: "user" + strconv.Itoa(id)}, nil
+		})
+
+	for range 3 {
+		u, err := get(context.Background(), 7)
+		if err != nil {
+			t.Fatalf("get error=%v", err)
+		}
+		if u.ID != 7 || u.Name != "user7" {
+			t.Fatalf("get=%+v want id=7 name=user7", u)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("calls=%d want=1", n)
+	}
+
+	var stored struct {
+		Value memoUser
+	}
+	found, err := client.Get(userKey(7), &stored)
+	if err != nil || !found {
+		t.Fatalf("Get found=%v error=%v", found, err)
+	}
+}
+
+func TestMemoizeErrorPolicyTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err       error
+		name      string
+		errorTTL  time.Duration
+		wantCalls int32
+		wantCache bool
+	}{
+		{name: "errors not cached by default", err: errUpstream, wantCalls: 2},
+		{name: "errors cached with error ttl", err: errUpstream, errorTTL: time.Hour, wantCalls: 1, wantCache: true},
+		{name: "context errors never cached", err: context.Canceled, errorTTL: time.Hour, wantCalls: 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newClientForCase(t, "memoize "+tc.name, 1024)
+			var calls atomic.Int32
+			get := nim.Memoize(client, nim.MemoizeConfig[int]{Key: userKey, TTL: time.Hour, ErrorTTL: tc.errorTTL},
+				func(context.Context, int) (string, error) {
+					calls.Add(1)
+					return "", tc.err
+				})
+
+			if _, err := get(context.Background(), 1); !errors.Is(err, tc.err) {
+				t.Fatalf("first get error=%v wantErr=%v", err, tc.err)
+			}
+			_, err := get(context.Background(), 1)
+			if tc.wantCache {
+				if !errors.Is(err, nim.ErrCacheMemoizedError) {
+					t.Fatalf("second get error=%v wantErr=%v", err, nim.ErrCacheMemoizedError)
+				}
+			} else if !errors.Is(err, tc.err) {
+				t.Fatalf("second get error=%v wantErr=%v", err, tc.err)
+			}
+			if n := calls.Load(); n != tc.wantCalls {
+				t.Fatalf("calls=%d want=%d", n, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMemoizeErrorTTLExpires(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "memoize error ttl expires", 1024)
+	var calls atomic.Int32
+	get := nim.Memoize(client, nim.MemoizeConfig[int]{Key: userKey, TTL: time.Hour, ErrorTTL: 20 * time.Millisecond},
+		func(context.Context, int) (string, error) {
+			if calls.Add(1) == 1 {
+				return "", errUpstream
+			}
+			return "ok", nil
+		})
+
+	if _, err := get(context.Background(), 1); !errors.Is(err, errUpstream) {
+		t.Fatalf("get error=%v wantErr=%v", err, errUpstream)
+	}
+	time.Sleep(40 * time.Millisecond)
+	v, err := get(context.Background(), 1)
+	if err != nil || v != "ok" {
+		t.Fatalf("get=%q error=%v want=ok", v, err)
+	}
+}
+
+func TestMemoizeCoalescesConcurrentCalls(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "memoize coalesces", 1024)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	get := nim.Memoize(client, nim.MemoizeConfig[int]{Key: userKey, TTL: time.Hour},
+		func(context.Context, int) (string, error) {
+			calls.Add(1)
+			<-release
+			return "shared", nil
+		})
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			v, err := get(context.Background(), 1)
+			if err != nil || v != "shared" {
+				t.Errorf("get=%q error=%v want=shared", v, err)
+			}
+		})
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("calls=%d want=1", n)
+	}
+}
+
+func TestMemoizePanicReachesWaiters(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "memoize panic", 1024)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	get := nim.Memoize(client, nim.MemoizeConfig[int]{Key: userKey, TTL: time.Hour, ErrorTTL: time.Hour},
+		func(context.Context, int) (string, error) {
+			if calls.Add(1) == 1 {
+				<-release
+				panic("boom")
+			}
+			return "recovered", nil
+		})
+
+	leader := make(chan any, 1)
+	go func() {
+		defer func() {
+			leader <- recover()
+		}()
+		_, _ = get(context.Background(), 1)
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	const waiters = 4
+	errs := make(chan error, waiters)
+	for range waiters {
+		go func() {
+			_, err := get(context.Background(), 1)
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if r := <-leader; r != "boom" {
+		t.Fatalf("leader recovered=%v want=boom", r)
+	}
+	for range waiters {
+		if err := <-errs; !errors.Is(err, nim.ErrCacheMemoizedPanic) {
+			t.Fatalf("waiter error=%v want=%v", err, nim.ErrCacheMemoizedPanic)
+		}
+	}
+
+	// The panic is not cached.
+	if v, err := get(context.Background(), 1); err != nil || v != "recovered" {
+		t.Fatalf("get=%q error=%v want=recovered", v, err)
+	}
+}
+
+func TestMemoizeRejectsInvalidKey(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "memoize invalid key", 1024)
+	get := nim.Memoize(client, nim.MemoizeConfig[int]{Key: func(int) string { return "" }},
+		func(context.Context, int) (string, error) {
+			t.Fatalf("fn called for invalid key")
+			return "", nil
+		})
+
+	if _, err := get(context.Background(), 1); !errors.Is(err, nim.ErrCacheKeyEmpty) {
+		t.Fatalf("get error=%v wantErr=%v", err, nim.ErrCacheKeyEmpty)
+	}
+}
@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurgeRemovesExpiredEntries(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "purge removes expired entries", 1024)
+	if err := client.Set("users::1", "alice", time.Millisecond); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.Set("users::1::avatar", "png", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.Set("users::2", "bob", time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.Set("orders::1", "book", time.Millisecond); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	removed, err := client.Purge("users::")
+	if err != nil {
+		t.Fatalf("Purge error=%v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("Purge removed=%d want=1", removed)
+	}
+	if _, ok, err := client.Stat("users::1"); err != nil || ok {
+		t.Fatalf("Stat(users::1) ok=%v error=%v want miss", ok, err)
+	}
+	assertKeys(t, client, "users::", []string{"users::1::avatar", "users::2"})
+
+	removed, err = client.Purge("")
+	if err != nil || removed != 1 {
+		t.Fatalf("Purge removed=%d error=%v want 1", removed, err)
+	}
+}
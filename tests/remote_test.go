@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/nimtest"
+)
+
+func newRemoteClient(t *testing.T, remote nim.RemoteTier, writeThrough bool) *nim.Client {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{
+		RootPath:           t.TempDir(),
+		MaxBytes:           1024,
+		Remote:             remote,
+		RemoteWriteThrough: writeThrough,
+	})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	return client
+}
+
+func remoteValue(t *testing.T, srv *nimtest.RemoteServer, key string) (string, time.Time, bool) {
+	t.Helper()
+
+	b, info, ok, err := srv.Store.Get(key)
+	if err != nil {
+		t.Fatalf("Store.Get error=%v", err)
+	}
+	return string(b), info.Expiry, ok
+}
+
+func TestRemoteReadThroughFillsLocal(t *testing.T) {
+	t.Parallel()
+
+	srv := nimtest.NewRemoteServer(t)
+	expiry := time.Now().Add(time.Hour)
+	if err := srv.Tier().Set("shared::user::1", []byte("alice"), expiry); err != nil {
+		t.Fatalf("Tier.Set error=%v", err)
+	}
+
+	client := newRemoteClient(t, srv.Tier(), false)
+	assertGetStringValue(t, client, "shared::user::1", "alice")
+
+	// Once filled, the local copy is served even if the remote loses it.
+	if err := srv.Store.Delete("shared::user::1"); err != nil {
+		t.Fatalf("Store.Delete error=%v", err)
+	}
+	assertGetStringValue(t, client, "shared::user::1", "alice")
+
+	keys, err := client.Keys("shared::")
+	if err != nil {
+		t.Fatalf("Keys error=%v", err)
+	}
+	if len(keys) != 1 || keys[0] != "shared::user::1" {
+		t.Fatalf("Keys=%v want=[shared::user::1]", keys)
+	}
+}
+
+func TestRemoteReadThroughTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		expiry     time.Time
+		name       string
+		remote     string
+		wantExists bool
+	}{
+		{name: "no ttl", remote: "value", wantExists: true},
+		{name: "live ttl", remote: "value", expiry: time.Now().Add(time.Hour), wantExists: true},
+		{name: "expired ttl", remote: "value", expiry: time.Now().Add(-time.Second), wantExists: false},
+		{name: "missing", wantExists: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := nimtest.NewRemoteServer(t)
+			if tc.remote != "" {
+				if err := srv.Store.Put("rt::key", []byte(tc.remote), tc.expiry); err != nil {
+					t.Fatalf("Store.Put error=%v", err)
+				}
+			}
+
+			client := newRemoteClient(t, srv.Tier(), false)
+			exists, err := client.Exists("rt::key")
+			if err != nil {
+				t.Fatalf("Exists error=%v", err)
+			}
+			if exists != tc.wantExists {
+				t.Fatalf("Exists=%v want=%v", exists, tc.wantExists)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteThroughTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		mutate       func(client *nim.Client) error
+		name         string
+		want         string
+		writeThrough bool
+		wantRemote   bool
+	}{
+		{
+			name:         "set with write-through",
+			writeThrough: true,
+			mutate: func(client *nim.Client) error {
+				return client.Set("wt::key", "local", time.Hour)
+			},
+			want:       "local",
+			wantRemote: true,
+		},
+		{
+			name: "set without write-through",
+			mutate: func(client *nim.Client) error {
+				return client.Set("wt::key", "local", time.Hour)
+			},
+			want:       "remote",
+			wantRemote: true,
+		},
+		{
+			name:         "remove with write-through",
+			writeThrough: true,
+			mutate: func(client *nim.Client) error {
+				return client.Remove("wt")
+			},
+			wantRemote: false,
+		},
+		{
+			name:         "txn with write-through",
+			writeThrough: true,
+			mutate: func(client *nim.Client) error {
+				return client.Txn([]string{"wt::key"}, func(tx *nim.Tx) error {
+					return tx.Set("wt::key", "from-txn", 0)
+				})
+			},
+			want:       "from-txn",
+			wantRemote: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := nimtest.NewRemoteServer(t)
+			if err := srv.Store.Put("wt::key", []byte("remote"), time.Time{}); err != nil {
+				t.Fatalf("Store.Put error=%v", err)
+			}
+
+			client := newRemoteClient(t, srv.Tier(), tc.writeThrough)
+			if err := tc.mutate(client); err != nil {
+				t.Fatalf("mutate error=%v", err)
+			}
+
+			got, _, ok := remoteValue(t, srv, "wt::key")
+			if ok != tc.wantRemote || got != tc.want {
+				t.Fatalf("remote ok=%v value=%q want ok=%v value=%q", ok, got, tc.wantRemote, tc.want)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteThroughKeepsExpiry(t *testing.T) {
+	t.Parallel()
+
+	srv := nimtest.NewRemoteServer(t)
+	client := newRemoteClient(t, srv.Tier(), true)
+
+	before := time.Now()
+	if err := client.Set("wt::ttl", "v", time.Minute); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	_, expiry, ok := remoteValue(t, srv, "wt::ttl")
+	if !ok {
+		t.Fatalf("remote ok=false want=true")
+	}
+	if expiry.Before(before.Add(time.Minute)) || expiry.After(time.Now().Add(time.Minute)) {
+		t.Fatalf("remote expiry=%v want about %v", expiry, before.Add(time.Minute))
+	}
+}
+
+func TestRemoteUnavailableReturnsError(t *testing.T) {
+	t.Parallel()
+
+	srv := nimtest.NewRemoteServer(t)
+	tier := srv.Tier()
+	srv.Close()
+
+	client := newRemoteClient(t, tier, true)
+	var out string
+	if _, err := client.Get("down::key", &out); !errors.Is(err, nim.ErrCacheRemote) {
+		t.Fatalf("Get error=%v want=%v", err, nim.ErrCacheRemote)
+	}
+	if err := client.Set("down::key", "v", 0); !errors.Is(err, nim.ErrCacheRemote) {
+		t.Fatalf("Set error=%v want=%v", err, nim.ErrCacheRemote)
+	}
+
+	// The local write still happened.
+	assertGetStringValue(t, client, "down::key", "v")
+}
+
+func TestRemoteOversizedBodiesAreMisses(t *testing.T) {
+	t.Parallel()
+
+	chunk := strings.Repeat("x", 32<<10)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/keys/small":
+			_, _ = io.WriteString(w, "ok")
+		case "/keys/sized":
+			w.Header().Set("Content-Length", strconv.Itoa(len(chunk)))
+			_, _ = io.WriteString(w, chunk)
+		default:
+			// An endless body, cut off when the tier stops reading.
+			for r.Context().Err() == nil {
+				if _, err := io.WriteString(w, chunk); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(remote.Close)
+
+	tier := nim.NewHTTPTier(remote.URL, nil)
+	tier.MaxBytes = 1024
+	for _, key := range []string{"sized", "endless"} {
+		if b, _, ok, err := tier.Get(key); err != nil || ok {
+			t.Fatalf("Get(%s) len=%d ok=%v error=%v want a miss", key, len(b), ok, err)
+		}
+	}
+	if b, _, ok, err := tier.Get("small"); err != nil || !ok || string(b) != "ok" {
+		t.Fatalf("Get(small)=%q ok=%v error=%v", b, ok, err)
+	}
+}
@@ -0,0 +1,341 @@
+package tests
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/resp"
+)
+
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type respError string
+
+func startRESPServer(t *testing.T) (*nim.Client, string) {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{RootPath: t.TempDir(), MaxBytes: 1024})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	// Unix socket paths are limited to ~108 bytes, too short for t.TempDir.
+	dir, err := os.MkdirTemp("", "nim-resp")
+	if err != nil {
+		t.Fatalf("MkdirTemp error=%v", err)
+	}
+	socketPath := filepath.Join(dir, "nim.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listen error=%v", err)
+	}
+
+	srv := resp.NewServer(client)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+		if err := <-done; !errors.Is(err, resp.ErrServerClosed) {
+			t.Errorf("Serve error=%v want=%v", err, resp.ErrServerClosed)
+		}
+		_ = os.RemoveAll(dir)
+	})
+	return client, socketPath
+}
+
+func dialRESP(t *testing.T, socketPath string) *respConn {
+	t.Helper()
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Dial error=%v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return &respConn{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *respConn) do(t *testing.T, args ...string) any {
+	t.Helper()
+
+	msg := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		msg += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	if _, err := io.WriteString(c.conn, msg); err != nil {
+		t.Fatalf("write error=%v", err)
+	}
+
+	reply, err := readRESPReply(c.r)
+	if err != nil {
+		t.Fatalf("read reply to %v error=%v", args, err)
+	}
+	return reply
+}
+
+func readRESPReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]any, 0, n)
+		for range n {
+			item, err := readRESPReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+}
+
+func TestRESPCommandTable(t *testing.T) {
+	t.Parallel()
+
+	client, socketPath := startRESPServer(t)
+	conn := dialRESP(t, socketPath)
+
+	cases := []struct {
+		want any
+		args []string
+	}{
+		{args: []string{"PING"}, want: "PONG"},
+		{args: []string{"GET", "user:1"}, want: nil},
+		{args: []string{"SET", "user:1", "alice"}, want: "OK"},
+		{args: []string{"GET", "user:1"}, want: "alice"},
+		{args: []string{"SET", "user", "root"}, want: "OK"},
+		{args: []string{"EXISTS", "user:1", "user", "user:2"}, want: int64(2)},
+		{args: []string{"TTL", "user:1"}, want: int64(-1)},
+		{args: []string{"TTL", "missing"}, want: int64(-2)},
+		{args: []string{"SET", "session", "s", "EX", "100"}, want: "OK"},
+		{args: []string{"TTL", "session"}, want: int64(100)},
+		{args: []string{"PEXPIRE", "session", "5000"}, want: int64(1)},
+		{args: []string{"TTL", "session"}, want: int64(5)},
+		{args: []string{"PEXPIRE", "missing", "5000"}, want: int64(0)},
+		{args: []string{"SET", "token", "t", "PX", "1500"}, want: "OK"},
+		{args: []string{"TTL", "token"}, want: int64(2)},
+		{args: []string{"INCR", "hits"}, want: int64(1)},
+		{args: []string{"INCRBY", "hits", "41"}, want: int64(42)},
+		{args: []string{"DECR", "hits"}, want: int64(41)},
+		{args: []string{"INCR", "user:1"}, want: respError("ERR value is not an integer or out of range")},
+		{args: []string{"DEL", "user", "missing"}, want: int64(1)},
+		{args: []string{"GET", "user:1"}, want: "alice"},
+		{args: []string{"KEYS", "user*"}, want: []any{"user:1"}},
+		{args: []string{"KEYS", "*"}, want: []any{"hits", "session", "token", "user:1"}},
+		{args: []string{"KEYS", "[st]*"}, want: []any{"session", "token"}},
+		{args: []string{"SET", "a::b", "v"}, want: respError("ERR invalid key: " + nim.ErrCacheKeyEmptySegment.Error())},
+		{args: []string{"SET", "..:..:x", "v"}, want: respError("ERR invalid key: " + nim.ErrCacheKeyInvalidSegment.Error())},
+		{args: []string{"SET", "views", "1", "EX", "100"}, want: "OK"},
+		{args: []string{"INCR", "views"}, want: int64(2)},
+		{args: []string{"TTL", "views"}, want: int64(100)},
+		{args: []string{"SET", "k", "v", "EX", "0"}, want: respError("ERR invalid expire time in 'set' command")},
+		{args: []string{"GET"}, want: respError("ERR wrong number of arguments for 'get' command")},
+		{args: []string{"FLUSHALL"}, want: respError("ERR unknown command 'flushall'")},
+		{args: []string{"PEXPIRE", "hits", "0"}, want: int64(1)},
+		{args: []string{"EXISTS", "hits"}, want: int64(0)},
+	}
+
+	for _, tc := range cases {
+		if got := conn.do(t, tc.args...); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%v=%#v want=%#v", tc.args, got, tc.want)
+		}
+	}
+
+	var out string
+	ok, err := client.Get("user::1", &out)
+	if err != nil || !ok || out != "alice" {
+		t.Fatalf("Get(user::1) ok=%v value=%q error=%v", ok, out, err)
+	}
+}
+
+func TestRESPScanPagesThroughKeys(t *testing.T) {
+	t.Parallel()
+
+	client, socketPath := startRESPServer(t)
+	want := make([]string, 0, 25)
+	for i := range 25 {
+		key := fmt.Sprintf("item::%02d", i)
+		if err := client.Set(key, "v", 0); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+		want = append(want, fmt.Sprintf("item:%02d", i))
+	}
+	if err := client.Set("other", "v", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	conn := dialRESP(t, socketPath)
+	var got []string
+	cursor := "0"
+	for {
+		reply, ok := conn.do(t, "SCAN", cursor, "MATCH", "item:*", "COUNT", "10").([]any)
+		if !ok || len(reply) != 2 {
+			t.Fatalf("SCAN reply=%#v", reply)
+		}
+		items, _ := reply[1].([]any)
+		for _, item := range items {
+			got = append(got, item.(string))
+		}
+		cursor, _ = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("SCAN keys=%v want=%v", got, want)
+	}
+}
+
+func TestRESPKeysHostilePattern(t *testing.T) {
+	t.Parallel()
+
+	client, socketPath := startRESPServer(t)
+	key := strings.Repeat("a", 100)
+	if err := client.Set(key, "v", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	conn := dialRESP(t, socketPath)
+	start := time.Now()
+	pattern := strings.Repeat("a*", 30) + "b"
+	if got := conn.do(t, "KEYS", pattern); !reflect.DeepEqual(got, []any{}) {
+		t.Fatalf("KEYS %s=%#v want none", pattern, got)
+	}
+	if got := conn.do(t, "KEYS", strings.Repeat("a*", 30)+"\\a"); !reflect.DeepEqual(got, []any{key}) {
+		t.Fatalf("KEYS escaped=%#v want %s", got, key)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("KEYS took %v", elapsed)
+	}
+}
+
+func TestRESPConcurrentIncr(t *testing.T) {
+	t.Parallel()
+
+	_, socketPath := startRESPServer(t)
+	const workers, perWorker = 4, 25
+
+	var wg sync.WaitGroup
+	for range workers {
+		conn := dialRESP(t, socketPath)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				if _, err := io.WriteString(conn.conn, "INCR counter\r\n"); err != nil {
+					t.Errorf("write error=%v", err)
+					return
+				}
+				if reply, err := readRESPReply(conn.r); err != nil {
+					t.Errorf("INCR reply=%#v error=%v", reply, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	conn := dialRESP(t, socketPath)
+	if got := conn.do(t, "GET", "counter"); got != strconv.Itoa(workers*perWorker) {
+		t.Fatalf("GET counter=%#v want=%d", got, workers*perWorker)
+	}
+}
+
+func TestRESPInlineAndPipelinedCommands(t *testing.T) {
+	t.Parallel()
+
+	_, socketPath := startRESPServer(t)
+	conn := dialRESP(t, socketPath)
+
+	if _, err := io.WriteString(conn.conn, "SET greeting hello\r\nGET greeting\r\nQUIT\r\n"); err != nil {
+		t.Fatalf("write error=%v", err)
+	}
+	for _, want := range []any{"OK", "hello", "OK"} {
+		got, err := readRESPReply(conn.r)
+		if err != nil {
+			t.Fatalf("read error=%v", err)
+		}
+		if got != want {
+			t.Fatalf("reply=%#v want=%#v", got, want)
+		}
+	}
+
+	_ = conn.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.r.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Fatalf("read after QUIT error=%v want=%v", err, io.EOF)
+	}
+}
+
+func TestRESPSkipsOversizedCommands(t *testing.T) {
+	t.Parallel()
+
+	_, socketPath := startRESPServer(t)
+	conn := dialRESP(t, socketPath)
+
+	reply := conn.do(t, "SET", "big", strings.Repeat("x", 2048))
+	if msg, ok := reply.(respError); !ok || !strings.Contains(string(msg), nim.ErrCacheValueTooLarge.Error()) {
+		t.Fatalf("SET big=%#v want a value too large error", reply)
+	}
+
+	// Many arguments within the value limit still add up past the command
+	// limit.
+	keys := make([]string, 0, 1200)
+	for i := range cap(keys) {
+		keys = append(keys, fmt.Sprintf("%04d%s", i, strings.Repeat("k", 1000)))
+	}
+	reply = conn.do(t, append([]string{"DEL"}, keys...)...)
+	if _, ok := reply.(respError); !ok {
+		t.Fatalf("DEL with %d long keys=%#v want an error", len(keys), reply)
+	}
+
+	// Both commands were read past, so the connection keeps working.
+	if got := conn.do(t, "GET", "big"); got != nil {
+		t.Fatalf("GET big=%#v want nil", got)
+	}
+	if got := conn.do(t, "SET", "small", "v"); got != "OK" {
+		t.Fatalf("SET small=%#v want OK", got)
+	}
+}
@@ -0,0 +1,298 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/server"
+)
+
+func newTestServer(t *testing.T, maxBytes int) (*httptest.Server, *server.Handler) {
+	t.Helper()
+
+	client, err := nim.New(nim.Config{RootPath: t.TempDir(), MaxBytes: maxBytes})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	handler := server.NewHandler(client)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv, handler
+}
+
+func doRequest(t *testing.T, method, url, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest error=%v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error=%v", method, url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll error=%v", err)
+	}
+	return resp, string(b)
+}
+
+func TestServerKeyRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	srv, handler := newTestServer(t, 1024)
+	url := srv.URL + "/keys/users::1"
+
+	resp, _ := doRequest(t, http.MethodPut, url, "alice", map[string]string{server.TTLHeader: "60"})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT status=%d want=%d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	resp, body := doRequest(t, http.MethodGet, url, "", nil)
+	if resp.StatusCode != http.StatusOK || body != "alice" {
+		t.Fatalf("GET status=%d body=%q want 200 %q", resp.StatusCode, body, "alice")
+	}
+	ttl, err := strconv.Atoi(resp.Header.Get(server.TTLHeader))
+	if err != nil || ttl <= 0 || ttl > 60 {
+		t.Fatalf("GET %s=%q want 1..60", server.TTLHeader, resp.Header.Get(server.TTLHeader))
+	}
+	if resp.Header.Get(nim.RemoteExpiresHeader) == "" {
+		t.Fatalf("GET %s missing", nim.RemoteExpiresHeader)
+	}
+
+	resp, body = doRequest(t, http.MethodHead, url, "", nil)
+	if resp.StatusCode != http.StatusOK || body != "" || resp.ContentLength != 5 {
+		t.Fatalf("HEAD status=%d body=%q length=%d want 200 empty 5", resp.StatusCode, body, resp.ContentLength)
+	}
+
+	resp, _ = doRequest(t, http.MethodDelete, srv.URL+"/keys/users", "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE status=%d want=%d", resp.StatusCode, http.StatusNoContent)
+	}
+	resp, _ = doRequest(t, http.MethodGet, url, "", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET after DELETE status=%d want=%d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	stats := handler.Stats()
+	if stats.Gets != 3 || stats.Hits != 2 || stats.Misses != 1 || stats.Sets != 1 || stats.Deletes != 1 {
+		t.Fatalf("Stats=%+v", stats)
+	}
+}
+
+func TestServerStatusCodeTable(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newTestServer(t, 8)
+
+	cases := []struct {
+		header map[string]string
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "empty segment", method: http.MethodPut, path: "/keys/a::::b", body: "v", want: http.StatusBadRequest},
+		{name: "reserved key", method: http.MethodGet, path: "/keys/.nim::txn", want: http.StatusBadRequest},
+		{name: "too large", method: http.MethodPut, path: "/keys/big", body: "0123456789", want: http.StatusRequestEntityTooLarge},
+		{name: "invalid ttl", method: http.MethodPut, path: "/keys/a", body: "v", header: map[string]string{server.TTLHeader: "soon"}, want: http.StatusBadRequest},
+		{name: "missing key", method: http.MethodGet, path: "/keys/missing", want: http.StatusNotFound},
+		{name: "duration ttl", method: http.MethodPut, path: "/keys/a", body: "v", header: map[string]string{server.TTLHeader: "90s"}, want: http.StatusNoContent},
+		{name: "unknown route", method: http.MethodGet, path: "/other", want: http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := doRequest(t, tc.method, srv.URL+tc.path, tc.body, tc.header)
+			if resp.StatusCode != tc.want {
+				t.Fatalf("status=%d body=%q want=%d", resp.StatusCode, body, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerStatusCodeMapping(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{err: nim.ErrCacheValueTooLarge, want: http.StatusRequestEntityTooLarge},
+		{err: nim.ErrCacheKeyEmptySegment, want: http.StatusBadRequest},
+		{err: nim.ErrCacheKeyEmpty, want: http.StatusBadRequest},
+		{err: nim.ErrCacheKeyInvalidSegment, want: http.StatusBadRequest},
+		{err: nim.ErrCachePathIsDir, want: http.StatusConflict},
+		{err: nim.ErrCacheRemote, want: http.StatusBadGateway},
+		{err: errors.New("disk on fire"), want: http.StatusInternalServerError},
+	}
+
+	for _, tc := range cases {
+		if got := server.StatusCode(tc.err); got != tc.want {
+			t.Fatalf("StatusCode(%v)=%d want=%d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestServerRejectsPathTraversal(t *testing.T) {
+	t.Parallel()
+
+	base := t.TempDir()
+	rootPath := filepath.Join(base, "a", "root")
+	victim := filepath.Join(base, "victim")
+	if err := os.MkdirAll(filepath.Join(victim, "keep"), 0o755); err != nil {
+		t.Fatalf("MkdirAll error=%v", err)
+	}
+	client, err := nim.New(nim.Config{RootPath: rootPath})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	srv := httptest.NewServer(server.NewHandler(client))
+	t.Cleanup(srv.Close)
+
+	cases := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodPut, path: "/keys/..::..::victim::evil"},
+		{method: http.MethodDelete, path: "/keys/..::..::victim"},
+		{method: http.MethodGet, path: "/keys/..::..::victim::keep"},
+		{method: http.MethodPut, path: "/keys/..%2F..%2Fvictim%2Fevil"},
+	}
+	for _, tc := range cases {
+		resp, body := doRequest(t, tc.method, srv.URL+tc.path, "payload", nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s %s status=%d body=%q want=%d", tc.method, tc.path, resp.StatusCode, body, http.StatusBadRequest)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(victim, "keep")); err != nil {
+		t.Fatalf("victim dir damaged: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(victim, "evil")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file written outside root: %v", err)
+	}
+}
+
+func TestServerExpiredPutRemovesKey(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newTestServer(t, 1024)
+	url := srv.URL + "/keys/session"
+
+	doRequest(t, http.MethodPut, url, "v", nil)
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixNano(), 10)
+	resp, _ := doRequest(t, http.MethodPut, url, "v2", map[string]string{nim.RemoteExpiresHeader: past})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT status=%d want=%d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	resp, _ = doRequest(t, http.MethodGet, url, "", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET status=%d want=%d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func TestServerRejectsOversizedBodiesEarly(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newTestServer(t, 1024)
+	const size = 64 << 20
+
+	for _, chunked := range []bool{false, true} {
+		body := &countingReader{r: io.LimitReader(zeroReader{}, size)}
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/keys/big", body)
+		if err != nil {
+			t.Fatalf("NewRequest error=%v", err)
+		}
+		if !chunked {
+			req.ContentLength = size
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			// The server may close the connection while the body is still
+			// being sent.
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("chunked=%t status=%d want=%d", chunked, resp.StatusCode, http.StatusRequestEntityTooLarge)
+		}
+		if sent := body.n.Load(); sent >= size {
+			t.Fatalf("chunked=%t sent the whole %d byte body", chunked, sent)
+		}
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestServerListsKeysByPrefix(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newTestServer(t, 1024)
+	for _, key := range []string{"app::b", "app::a", "other"} {
+		doRequest(t, http.MethodPut, srv.URL+"/keys/"+key, "v", nil)
+	}
+
+	cases := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "app::", want: []string{"app::a", "app::b"}},
+		{prefix: "", want: []string{"app::a", "app::b", "other"}},
+		{prefix: "none", want: []string{}},
+	}
+
+	for _, tc := range cases {
+		resp, body := doRequest(t, http.MethodGet, srv.URL+"/keys?prefix="+tc.prefix, "", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /keys status=%d", resp.StatusCode)
+		}
+		var keys []string
+		if err := json.Unmarshal([]byte(body), &keys); err != nil {
+			t.Fatalf("Unmarshal error=%v body=%q", err, body)
+		}
+		if !slices.Equal(keys, tc.want) {
+			t.Fatalf("keys(%q)=%v want=%v", tc.prefix, keys, tc.want)
+		}
+	}
+}
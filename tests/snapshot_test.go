@@ -0,0 +1,227 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+func TestSnapshotIsolatedFromLaterWrites(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	client, err := nim.New(nim.Config{RootPath: filepath.Join(dir, "root"), SnapshotBarrier: true})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if err := client.Set("users::1", "alice", time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.Set("users::1::avatar", "png", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	snapPath := filepath.Join(dir, "snap")
+	if err := client.Snapshot(snapPath); err != nil {
+		t.Fatalf("Snapshot error=%v", err)
+	}
+	if err := client.Set("users::1", "bob", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.Remove("users::1::avatar"); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+
+	snap, err := nim.New(nim.Config{RootPath: snapPath})
+	if err != nil {
+		t.Fatalf("New(snapshot) error=%v", err)
+	}
+	assertGetStringValue(t, snap, "users::1", "alice")
+	assertGetStringValue(t, snap, "users::1::avatar", "png")
+	info, ok, err := snap.Stat("users::1")
+	if err != nil || !ok || info.Expiry.IsZero() {
+		t.Fatalf("Stat(snapshot) info=%+v ok=%v error=%v want expiry kept", info, ok, err)
+	}
+	if locks := listLockFiles(t, snapPath); len(locks) != 0 {
+		t.Fatalf("snapshot lock files=%v want none", locks)
+	}
+
+	if err := client.Snapshot(snapPath); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("Snapshot(existing) error=%v want ErrExist", err)
+	}
+}
+
+func TestSnapshotConsistentWithConcurrentTxns(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	client, err := nim.New(nim.Config{RootPath: filepath.Join(dir, "root"), SnapshotBarrier: true})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	// Both keys always hold the same counter; a torn snapshot would show
+	// them apart.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			err := client.Txn([]string{"pair::a", "pair::b"}, func(tx *nim.Tx) error {
+				if err := tx.Set("pair::a", strconv.Itoa(i), 0); err != nil {
+					return err
+				}
+				return tx.Set("pair::b", strconv.Itoa(i), 0)
+			})
+			if err != nil {
+				t.Errorf("Txn error=%v", err)
+				return
+			}
+		}
+	}()
+
+	for i := range 20 {
+		snapPath := filepath.Join(dir, "snap-"+strconv.Itoa(i))
+		if err := client.Snapshot(snapPath); err != nil {
+			t.Fatalf("Snapshot error=%v", err)
+		}
+		snap, err := nim.New(nim.Config{RootPath: snapPath})
+		if err != nil {
+			t.Fatalf("New(snapshot) error=%v", err)
+		}
+		var a, b string
+		okA, errA := snap.Get("pair::a", &a)
+		okB, errB := snap.Get("pair::b", &b)
+		if errA != nil || errB != nil || okA != okB || a != b {
+			t.Fatalf("snapshot a=%q(%v) b=%q(%v) errors=%v,%v want equal", a, okA, b, okB, errA, errB)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestRestoreSwapsRoot(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	rootPath := filepath.Join(dir, "root")
+	client, err := nim.New(nim.Config{RootPath: rootPath, L1Entries: 16, SnapshotBarrier: true})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	other, err := nim.New(nim.Config{RootPath: rootPath, SnapshotBarrier: true})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	snapPath := filepath.Join(dir, "snap")
+	if err := client.Snapshot(snapPath); err != nil {
+		t.Fatalf("Snapshot error=%v", err)
+	}
+	if err := client.Set("users::1", "bob", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.Set("users::2", "carol", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	assertGetStringValue(t, client, "users::1", "bob")
+
+	if err := client.Restore(snapPath); err != nil {
+		t.Fatalf("Restore error=%v", err)
+	}
+	assertGetStringValue(t, client, "users::1", "alice")
+	assertKeys(t, client, "", []string{"users::1"})
+	if _, err := os.Stat(snapPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat(snapshot) error=%v want moved", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ReadDir=%v error=%v want only the root", entries, err)
+	}
+
+	// A client that wrote to the previous root keeps working on the new one.
+	if err := other.Set("users::3", "dave", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	assertGetStringValue(t, client, "users::3", "dave")
+}
+
+func TestSnapshotUnsupportedBackend(t *testing.T) {
+	t.Parallel()
+
+	client, err := nim.New(nim.Config{Backend: nim.NewMemoryBackend()})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if err := client.Snapshot(filepath.Join(t.TempDir(), "snap")); !errors.Is(err, nim.ErrCacheBackendUnsupported) {
+		t.Fatalf("Snapshot error=%v want ErrCacheBackendUnsupported", err)
+	}
+	if err := client.Restore(t.TempDir()); !errors.Is(err, nim.ErrCacheBackendUnsupported) {
+		t.Fatalf("Restore error=%v want ErrCacheBackendUnsupported", err)
+	}
+}
+
+func TestSnapshotRequiresBarrier(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	client, err := nim.New(nim.Config{RootPath: filepath.Join(dir, "root")})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "root", ".nim", "barrier")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("barrier file stat error=%v want ErrNotExist", err)
+	}
+	if err := client.Snapshot(filepath.Join(dir, "snap")); !errors.Is(err, nim.ErrCacheBackendUnsupported) {
+		t.Fatalf("Snapshot error=%v want ErrCacheBackendUnsupported", err)
+	}
+	if err := client.Restore(t.TempDir()); !errors.Is(err, nim.ErrCacheBackendUnsupported) {
+		t.Fatalf("Restore error=%v want ErrCacheBackendUnsupported", err)
+	}
+}
+
+func TestSnapshotRefusesPathsInsideRoot(t *testing.T) {
+	t.Parallel()
+
+	rootPath := filepath.Join(t.TempDir(), "root")
+	client, err := nim.New(nim.Config{RootPath: rootPath, SnapshotBarrier: true})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	for _, dst := range []string{rootPath, filepath.Join(rootPath, "snap"), filepath.Join(rootPath, "users", "snap")} {
+		if err := client.Snapshot(dst); !errors.Is(err, nim.ErrCacheSnapshotInRoot) {
+			t.Fatalf("Snapshot(%s) error=%v want=%v", dst, err, nim.ErrCacheSnapshotInRoot)
+		}
+	}
+	if err := client.Restore(filepath.Join(rootPath, "users")); !errors.Is(err, nim.ErrCacheSnapshotInRoot) {
+		t.Fatalf("Restore error=%v want=%v", err, nim.ErrCacheSnapshotInRoot)
+	}
+
+	// A sibling whose name merely starts with the root's is fine.
+	if err := client.Snapshot(rootPath + "..snap"); err != nil {
+		t.Fatalf("Snapshot(sibling) error=%v", err)
+	}
+	assertGetStringValue(t, client, "users::1", "alice")
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+type recordingMetrics struct {
+	errors map[nim.ErrorKind]int
+	hits   int
+	misses int
+	sets   int
+	bytes  int
+	waits  int
+	mu     sync.Mutex
+}
+
+func (m *recordingMetrics) Hit()                     { m.mu.Lock(); m.hits++; m.mu.Unlock() }
+func (m *recordingMetrics) Miss()                    { m.mu.Lock(); m.misses++; m.mu.Unlock() }
+func (m *recordingMetrics) ExpiredOnRead()           {}
+func (m *recordingMetrics) Remove()                  {}
+func (m *recordingMetrics) LockWait(time.Duration)   { m.mu.Lock(); m.waits++; m.mu.Unlock() }
+func (m *recordingMetrics) Set(n int)                { m.mu.Lock(); m.sets++; m.bytes += n; m.mu.Unlock() }
+func (m *recordingMetrics) Error(kind nim.ErrorKind) { m.mu.Lock(); m.errors[kind]++; m.mu.Unlock() }
+
+func TestStatsCountOperations(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "stats count operations", 16)
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.Set("users::2", "bob", time.Millisecond); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var s string
+	_, _ = client.Get("users::1", &s)
+	_, _ = client.Get("users::2", &s)
+	_, _ = client.Exists("users::3")
+	_ = client.Remove("users::1")
+	_ = client.Set("", "x", 0)
+	_ = client.Set("big", make([]byte, 17), 0)
+	var n int
+	_, _ = client.Get("users::9", &n)
+	_ = client.Set("users::9", "not an int", 0)
+	_, _ = client.Get("users::9", &n)
+	err := client.Txn([]string{"a", "b"}, func(tx *nim.Tx) error {
+		if err := tx.Set("a", "1", 0); err != nil {
+			return err
+		}
+		return tx.Remove("b")
+	})
+	if err != nil {
+		t.Fatalf("Txn error=%v", err)
+	}
+
+	st := client.Stats()
+	// b was never stored, so removing it in the Txn is not counted.
+	want := nim.Stats{Hits: 2, Misses: 3, ExpiredOnRead: 1, Sets: 4, Removes: 1, BytesWritten: 5 + 3 + 10 + 1}
+	if st.Hits != want.Hits || st.Misses != want.Misses || st.ExpiredOnRead != want.ExpiredOnRead ||
+		st.Sets != want.Sets || st.Removes != want.Removes || st.BytesWritten != want.BytesWritten {
+		t.Fatalf("Stats=%+v want counters of %+v", st, want)
+	}
+	wantErrors := map[nim.ErrorKind]uint64{nim.ErrorKindKey: 1, nim.ErrorKindTooLarge: 1, nim.ErrorKindCodec: 1}
+	if len(st.Errors) != len(wantErrors) {
+		t.Fatalf("Stats.Errors=%v want=%v", st.Errors, wantErrors)
+	}
+	for kind, n := range wantErrors {
+		if st.Errors[kind] != n {
+			t.Fatalf("Stats.Errors=%v want=%v", st.Errors, wantErrors)
+		}
+	}
+
+	// Three Sets, the Txn, the Remove and dropping the expired users::2.
+	var waits uint64
+	for _, n := range st.LockWait.Counts {
+		waits += n
+	}
+	if len(st.LockWait.Counts) != len(st.LockWait.Bounds)+1 || waits != 6 {
+		t.Fatalf("LockWait=%+v want 6 waits", st.LockWait)
+	}
+}
+
+func TestStatsLockWaitHistogram(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "stats lock wait histogram", 1024)
+	lock, err := client.Lock(context.Background(), "jobs::nightly")
+	if err != nil {
+		t.Fatalf("Lock error=%v", err)
+	}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = lock.Unlock()
+	}()
+	if err := client.Set("jobs::nightly", "done", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	st := client.Stats().LockWait
+	if st.Sum < 30*time.Millisecond {
+		t.Fatalf("LockWait.Sum=%v want at least 30ms", st.Sum)
+	}
+	// Buckets past the 10ms bound only hold the blocked Set.
+	slow := 0
+	for i, bound := range st.Bounds {
+		if bound >= 10*time.Millisecond {
+			slow += int(st.Counts[i+1])
+		}
+	}
+	if slow != 1 {
+		t.Fatalf("LockWait=%+v want one wait above 10ms", st)
+	}
+}
+
+func TestMetricsHook(t *testing.T) {
+	t.Parallel()
+
+	m := &recordingMetrics{errors: make(map[nim.ErrorKind]int)}
+	client, err := nim.New(nim.Config{RootPath: filepath.Join(t.TempDir(), "root"), Metrics: m})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	var s string
+	_, _ = client.Get("users::1", &s)
+	_, _ = client.Get("users::2", &s)
+	_, _ = client.Get("", &s)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hits != 1 || m.misses != 1 || m.sets != 1 || m.bytes != 5 || m.waits != 1 || m.errors[nim.ErrorKindKey] != 1 {
+		t.Fatalf("metrics=%+v", m)
+	}
+}
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+	"github.com/brownhounds/nim/nimtest"
+	"github.com/brownhounds/nim/server"
+)
+
+func assertLookup(t *testing.T, client *nim.Client, key string, want nim.Presence) {
+	t.Helper()
+
+	var got string
+	presence, err := client.Lookup(key, &got)
+	if err != nil {
+		t.Fatalf("Lookup error=%v", err)
+	}
+	if presence != want {
+		t.Fatalf("Lookup(%q)=%v want=%v", key, presence, want)
+	}
+}
+
+func TestTombstoneLookupTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		setup func(t *testing.T, client *nim.Client)
+		name  string
+		want  nim.Presence
+	}{
+		{
+			name:  "missing key is unknown",
+			setup: func(*testing.T, *nim.Client) {},
+			want:  nim.PresenceUnknown,
+		},
+		{
+			name: "stored value is found",
+			setup: func(t *testing.T, client *nim.Client) {
+				if err := client.Set("users::1", "alice", 0); err != nil {
+					t.Fatalf("Set error=%v", err)
+				}
+			},
+			want: nim.PresenceFound,
+		},
+		{
+			name: "tombstone is absent",
+			setup: func(t *testing.T, client *nim.Client) {
+				if err := client.SetAbsent("users::1", time.Hour); err != nil {
+					t.Fatalf("SetAbsent error=%v", err)
+				}
+			},
+			want: nim.PresenceAbsent,
+		},
+		{
+			name: "set replaces tombstone",
+			setup: func(t *testing.T, client *nim.Client) {
+				if err := client.SetAbsent("users::1", time.Hour); err != nil {
+					t.Fatalf("SetAbsent error=%v", err)
+				}
+				if err := client.Set("users::1", "alice", 0); err != nil {
+					t.Fatalf("Set error=%v", err)
+				}
+			},
+			want: nim.PresenceFound,
+		},
+		{
+			name: "expired tombstone is unknown",
+			setup: func(t *testing.T, client *nim.Client) {
+				if err := client.SetAbsent("users::1", 10*time.Millisecond); err != nil {
+					t.Fatalf("SetAbsent error=%v", err)
+				}
+				time.Sleep(30 * time.Millisecond)
+			},
+			want: nim.PresenceUnknown,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, bc := range backendCases() {
+				t.Run(bc.name, func(t *testing.T) {
+					client := bc.newClient(t)
+					tc.setup(t, client)
+					assertLookup(t, client, "users::1", tc.want)
+				})
+			}
+		})
+	}
+}
+
+func TestTombstoneHiddenFromReads(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "tombstone hidden from reads", 1024)
+	if err := client.SetAbsent("users::404", time.Hour); err != nil {
+		t.Fatalf("SetAbsent error=%v", err)
+	}
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	var got string
+	if found, err := client.Get("users::404", &got); err != nil || found {
+		t.Fatalf("Get found=%v error=%v want miss", found, err)
+	}
+	if ok, err := client.Exists("users::404"); err != nil || ok {
+		t.Fatalf("Exists=%v error=%v want false", ok, err)
+	}
+	if _, _, ok, err := client.Entry("users::404"); err != nil || ok {
+		t.Fatalf("Entry ok=%v error=%v want miss", ok, err)
+	}
+	assertKeys(t, client, "users::", []string{"users::1"})
+}
+
+func TestTombstoneTravelsThroughRemote(t *testing.T) {
+	t.Parallel()
+
+	srv := nimtest.NewRemoteServer(t)
+	writer := newRemoteClient(t, srv.Tier(), true)
+	reader := newRemoteClient(t, srv.Tier(), false)
+
+	if err := writer.SetAbsent("users::404", time.Hour); err != nil {
+		t.Fatalf("SetAbsent error=%v", err)
+	}
+	assertLookup(t, reader, "users::404", nim.PresenceAbsent)
+}
+
+func TestTombstoneReportsExpiry(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "tombstone reports expiry", 1024)
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if _, ok, err := client.Tombstone("users::1"); err != nil || ok {
+		t.Fatalf("Tombstone(value) ok=%v error=%v want false", ok, err)
+	}
+
+	before := time.Now()
+	if err := client.SetAbsent("users::404", time.Hour); err != nil {
+		t.Fatalf("SetAbsent error=%v", err)
+	}
+	info, ok, err := client.Tombstone("users::404")
+	if err != nil || !ok {
+		t.Fatalf("Tombstone ok=%v error=%v want true", ok, err)
+	}
+	if info.Expiry.Before(before.Add(time.Hour)) || info.Expiry.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("Tombstone expiry=%v want about one hour from now", info.Expiry)
+	}
+}
+
+func TestTombstonePayloadIsRefusedAsValue(t *testing.T) {
+	t.Parallel()
+
+	sentinel := "\x00nim-tombstone\x00"
+	client := newClientForCase(t, "tombstone payload refused", 1024)
+	for _, v := range []any{sentinel, []byte(sentinel)} {
+		if err := client.Set("users::1", v, 0); !errors.Is(err, nim.ErrCacheValueReserved) {
+			t.Fatalf("Set(%T) error=%v want=%v", v, err, nim.ErrCacheValueReserved)
+		}
+	}
+	err := client.Txn([]string{"users::1"}, func(tx *nim.Tx) error {
+		return tx.Set("users::1", []byte(sentinel), 0)
+	})
+	if !errors.Is(err, nim.ErrCacheValueReserved) {
+		t.Fatalf("Tx.Set error=%v want=%v", err, nim.ErrCacheValueReserved)
+	}
+	assertLookup(t, client, "users::1", nim.PresenceUnknown)
+
+	srv := httptest.NewServer(server.NewHandler(client))
+	t.Cleanup(srv.Close)
+	resp, _ := doRequest(t, http.MethodPut, srv.URL+"/keys/users::1", sentinel, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("PUT status=%d want=%d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// A remote answering with the payload instead of RemoteAbsentHeader is
+	// misbehaving.
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, sentinel)
+	}))
+	t.Cleanup(remote.Close)
+	if _, _, _, err := nim.NewHTTPTier(remote.URL, nil).Get("users::1"); !errors.Is(err, nim.ErrCacheValueReserved) {
+		t.Fatalf("HTTPTier.Get error=%v want=%v", err, nim.ErrCacheValueReserved)
+	}
+}
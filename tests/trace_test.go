@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+type traceSpanKey struct{}
+
+type traceSpan struct {
+	parent *traceSpan
+	op     nim.Op
+}
+
+type tracedOp struct {
+	parent nim.Op
+	end    nim.OpEnd
+}
+
+type recordingTracer struct {
+	ops []tracedOp
+	mu  sync.Mutex
+}
+
+func (r *recordingTracer) TraceStart(ctx context.Context, start nim.OpStart) context.Context {
+	parent, _ := ctx.Value(traceSpanKey{}).(*traceSpan)
+	return context.WithValue(ctx, traceSpanKey{}, &traceSpan{parent: parent, op: start.Op})
+}
+
+func (r *recordingTracer) TraceEnd(ctx context.Context, end nim.OpEnd) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op := tracedOp{end: end}
+	if sp, _ := ctx.Value(traceSpanKey{}).(*traceSpan); sp != nil && sp.parent != nil {
+		op.parent = sp.parent.op
+	}
+	r.ops = append(r.ops, op)
+}
+
+func (r *recordingTracer) take() []tracedOp {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ops := r.ops
+	r.ops = nil
+	return ops
+}
+
+func newTracedClient(t *testing.T) (*nim.Client, *recordingTracer) {
+	t.Helper()
+
+	tracer := &recordingTracer{}
+	client, err := nim.New(nim.Config{
+		RootPath: filepath.Join(t.TempDir(), "cache"),
+		MaxBytes: 64,
+		Tracer:   tracer,
+	})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	return client, tracer
+}
+
+func TestTraceSetNestsLock(t *testing.T) {
+	t.Parallel()
+
+	client, tracer := newTracedClient(t)
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	ops := tracer.take()
+	if len(ops) != 2 {
+		t.Fatalf("ops=%+v want 2", ops)
+	}
+	lock, set := ops[0], ops[1]
+	if lock.end.Op != nim.OpLock || lock.parent != nim.OpSet || lock.end.Outcome != nim.OutcomeOK {
+		t.Fatalf("lock op=%+v", lock)
+	}
+	if set.end.Op != nim.OpSet || set.parent != "" || set.end.Outcome != nim.OutcomeOK {
+		t.Fatalf("set op=%+v", set)
+	}
+	if set.end.Key != "users::1" || set.end.Namespace != "users" || set.end.Size == 0 {
+		t.Fatalf("set op=%+v", set)
+	}
+	if set.end.Duration < lock.end.Duration {
+		t.Fatalf("set duration=%v below lock duration=%v", set.end.Duration, lock.end.Duration)
+	}
+
+	if err := client.Remove("users::1"); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	ops = tracer.take()
+	if len(ops) != 2 {
+		t.Fatalf("ops=%+v want 2", ops)
+	}
+	lock, remove := ops[0], ops[1]
+	if lock.end.Op != nim.OpLock || lock.parent != nim.OpRemove || lock.end.Key != "users::1" {
+		t.Fatalf("lock op=%+v", lock)
+	}
+	if remove.end.Op != nim.OpRemove || remove.parent != "" || remove.end.Outcome != nim.OutcomeOK {
+		t.Fatalf("remove op=%+v", remove)
+	}
+	var waits uint64
+	for _, n := range client.Stats().LockWait.Counts {
+		waits += n
+	}
+	if waits != 2 {
+		t.Fatalf("lock waits=%d want 2", waits)
+	}
+}
+
+func TestTraceOutcomes(t *testing.T) {
+	t.Parallel()
+
+	client, tracer := newTracedClient(t)
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.SetAbsent("users::2", time.Hour); err != nil {
+		t.Fatalf("SetAbsent error=%v", err)
+	}
+	tracer.take()
+
+	var s string
+	_, _ = client.Get("users::1", &s)
+	_, _ = client.Get("users::2", &s)
+	_, _ = client.Get("users::3", &s)
+	_, _ = client.Exists("users::1")
+	_, _ = client.Expire("users::3", time.Hour)
+	_ = client.Remove("users::1")
+	_ = client.Set("users::4", make([]byte, 128), 0)
+	_, _ = client.Get("solo", &s)
+
+	want := []struct {
+		op        nim.Op
+		outcome   nim.Outcome
+		namespace string
+		sized     bool
+	}{
+		{nim.OpGet, nim.OutcomeHit, "users", true},
+		{nim.OpGet, nim.OutcomeAbsent, "users", false},
+		{nim.OpGet, nim.OutcomeMiss, "users", false},
+		{nim.OpExists, nim.OutcomeHit, "users", true},
+		{nim.OpExpire, nim.OutcomeMiss, "users", false},
+		{nim.OpRemove, nim.OutcomeOK, "users", false},
+		{nim.OpSet, nim.OutcomeError, "users", false},
+		{nim.OpGet, nim.OutcomeMiss, "", false},
+	}
+	var got []nim.OpEnd
+	for _, op := range tracer.take() {
+		if op.parent == "" {
+			got = append(got, op.end)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ops=%+v want %d", got, len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Op != w.op || g.Outcome != w.outcome || g.Namespace != w.namespace || (g.Size > 0) != w.sized {
+			t.Fatalf("op %d=%+v want %+v", i, g, w)
+		}
+	}
+	if !errors.Is(got[6].Err, nim.ErrCacheValueTooLarge) {
+		t.Fatalf("set err=%v want %v", got[6].Err, nim.ErrCacheValueTooLarge)
+	}
+}
+
+func TestTraceLockUsesCallerContext(t *testing.T) {
+	t.Parallel()
+
+	client, tracer := newTracedClient(t)
+	ctx := context.WithValue(context.Background(), traceSpanKey{}, &traceSpan{op: "request"})
+	lock, err := client.Lock(ctx, "users::1")
+	if err != nil {
+		t.Fatalf("Lock error=%v", err)
+	}
+	_ = lock.Unlock()
+
+	ops := tracer.take()
+	if len(ops) != 1 || ops[0].end.Op != nim.OpLock || ops[0].parent != "request" {
+		t.Fatalf("ops=%+v", ops)
+	}
+}
+
+func TestTraceContextVariantsUseCallerContext(t *testing.T) {
+	t.Parallel()
+
+	client, tracer := newTracedClient(t)
+	if err := client.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	ctx := context.WithValue(context.Background(), traceSpanKey{}, &traceSpan{op: "request"})
+
+	cases := []struct {
+		call func() error
+		op   nim.Op
+	}{
+		{op: nim.OpSet, call: func() error { return client.SetContext(ctx, "users::2", "bob", 0) }},
+		{op: nim.OpSetAbsent, call: func() error { return client.SetAbsentContext(ctx, "users::404", time.Hour) }},
+		{op: nim.OpGet, call: func() error {
+			var out string
+			_, err := client.GetContext(ctx, "users::1", &out)
+			return err
+		}},
+		{op: nim.OpLookup, call: func() error {
+			var out string
+			_, err := client.LookupContext(ctx, "users::1", &out)
+			return err
+		}},
+		{op: nim.OpEntry, call: func() error {
+			_, _, _, err := client.EntryContext(ctx, "users::1")
+			return err
+		}},
+		{op: nim.OpStat, call: func() error {
+			_, _, err := client.StatContext(ctx, "users::1")
+			return err
+		}},
+		{op: nim.OpExists, call: func() error {
+			_, err := client.ExistsContext(ctx, "users::1")
+			return err
+		}},
+		{op: nim.OpExpire, call: func() error {
+			_, err := client.ExpireContext(ctx, "users::1", time.Hour)
+			return err
+		}},
+		{op: nim.OpRemove, call: func() error { return client.RemoveContext(ctx, "users::2") }},
+	}
+
+	tracer.take()
+	for _, tc := range cases {
+		if err := tc.call(); err != nil {
+			t.Fatalf("%s error=%v", tc.op, err)
+		}
+		ops := tracer.take()
+		found := false
+		for _, op := range ops {
+			if op.end.Op == tc.op {
+				found = true
+				if op.parent != "request" {
+					t.Fatalf("%s parent=%q want=request", tc.op, op.parent)
+				}
+			}
+		}
+		if !found {
+			t.Fatalf("%s not traced, ops=%+v", tc.op, ops)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+var errTxnAbort = errors.New("txn abort")
+
+func TestTxnCommitTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		fnErr       error
+		want        map[string]string
+		name        string
+		seed        map[string]string
+		keys        []string
+		set         map[string]string
+		remove      []string
+		wantMissing []string
+	}{
+		{
+			name: "sets across namespaces",
+			keys: []string{"order::1", "user::7::orders"},
+			set: map[string]string{
+				"order::1":        "pending",
+				"user::7::orders": "1",
+			},
+			want: map[string]string{
+				"order::1":        "pending",
+				"user::7::orders": "1",
+			},
+		},
+		{
+			name:   "set and remove",
+			keys:   []string{"order::1", "user::7::orders"},
+			seed:   map[string]string{"order::1": "pending", "user::7::orders": "1"},
+			set:    map[string]string{"user::7::orders": ""},
+			remove: []string{"order::1"},
+			want: map[string]string{
+				"user::7::orders": "",
+			},
+			wantMissing: []string{"order::1"},
+		},
+		{
+			name:  "fn error discards staged writes",
+			keys:  []string{"order::1", "user::7::orders"},
+			seed:  map[string]string{"order::1": "pending"},
+			set:   map[string]string{"order::1": "paid", "user::7::orders": "1"},
+			fnErr: errTxnAbort,
+			want: map[string]string{
+				"order::1": "pending",
+			},
+			wantMissing: []string{"user::7::orders"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newClientForCase(t, "txn "+tc.name, 1024)
+			for key, val := range tc.seed {
+				if err := client.Set(key, val, 0); err != nil {
+					t.Fatalf("Set(%s) error=%v", key, err)
+				}
+			}
+
+			err := client.Txn(tc.keys, func(tx *nim.Tx) error {
+				for key, val := range tc.set {
+					if err := tx.Set(key, val, 0); err != nil {
+						return err
+					}
+				}
+				for _, key := range tc.remove {
+					if err := tx.Remove(key); err != nil {
+						return err
+					}
+				}
+				return tc.fnErr
+			})
+			if !errors.Is(err, tc.fnErr) {
+				t.Fatalf("Txn error=%v wantErr=%v", err, tc.fnErr)
+			}
+
+			for key, val := range tc.want {
+				assertGetStringValue(t, client, key, val)
+			}
+			for _, key := range tc.wantMissing {
+				exists, err := client.Exists(key)
+				if err != nil {
+					t.Fatalf("Exists(%s) error=%v", key, err)
+				}
+				if exists {
+					t.Fatalf("Exists(%s)=true want=false", key)
+				}
+			}
+		})
+	}
+}
+
+func TestTxnStagedViewTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		op        string
+		wantValue string
+		wantOK    bool
+	}{
+		{name: "reads staged set", op: "set", wantValue: "staged", wantOK: true},
+		{name: "reads staged remove", op: "remove", wantOK: false},
+		{name: "reads committed value", op: "none", wantValue: "seed", wantOK: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newClientForCase(t, "txn view "+tc.name, 1024)
+			key := "txn::view"
+			if err := client.Set(key, "seed", 0); err != nil {
+				t.Fatalf("Set error=%v", err)
+			}
+
+			err := client.Txn([]string{key}, func(tx *nim.Tx) error {
+				switch tc.op {
+				case "set":
+					if err := tx.Set(key, "staged", 0); err != nil {
+						return err
+					}
+				case "remove":
+					if err := tx.Remove(key); err != nil {
+						return err
+					}
+				}
+
+				assertGetStringValue(t, client, key, "seed")
+
+				var out string
+				ok, err := tx.Get(key, &out)
+				if err != nil {
+					return err
+				}
+				if ok != tc.wantOK || out != tc.wantValue {
+					t.Fatalf("tx.Get ok=%v value=%q want ok=%v value=%q", ok, out, tc.wantOK, tc.wantValue)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Txn error=%v", err)
+			}
+		})
+	}
+}
+
+func TestTxnKeyErrorsTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		wantErr error
+		name    string
+		useKey  string
+		keys    []string
+		useTx   bool
+	}{
+		{
+			name:    "key outside transaction",
+			keys:    []string{"txn::a"},
+			useKey:  "txn::b",
+			wantErr: nim.ErrCacheTxnKeyNotLocked,
+		},
+		{
+			name:    "invalid key",
+			keys:    []string{"txn::::a"},
+			wantErr: nim.ErrCacheKeyEmptySegment,
+		},
+		{
+			name:    "reserved key",
+			keys:    []string{".nim::txn"},
+			wantErr: nim.ErrCacheKeyReserved,
+		},
+		{
+			name:    "tx used after return",
+			keys:    []string{"txn::a"},
+			useKey:  "txn::a",
+			useTx:   true,
+			wantErr: nim.ErrCacheTxnClosed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newClientForCase(t, "txn errors "+tc.name, 1024)
+
+			var leaked *nim.Tx
+			err := client.Txn(tc.keys, func(tx *nim.Tx) error {
+				leaked = tx
+				if tc.useKey == "" || tc.useTx {
+					return nil
+				}
+				return tx.Set(tc.useKey, "value", 0)
+			})
+			if tc.useTx {
+				if err != nil {
+					t.Fatalf("Txn error=%v", err)
+				}
+				err = leaked.Set(tc.useKey, "value", 0)
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error=%v wantErr=%v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTxnTTL(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "txn ttl", 1024)
+	err := client.Txn([]string{"txn::ttl"}, func(tx *nim.Tx) error {
+		return tx.Set("txn::ttl", "value", 15*time.Millisecond)
+	})
+	if err != nil {
+		t.Fatalf("Txn error=%v", err)
+	}
+
+	assertFilesystemSetLayout(t, caseRootPath(t, "txn ttl"), "txn::ttl", true, true)
+
+	time.Sleep(35 * time.Millisecond)
+	exists, err := client.Exists("txn::ttl")
+	if err != nil {
+		t.Fatalf("Exists error=%v", err)
+	}
+	if exists {
+		t.Fatalf("Exists=true want=false after ttl")
+	}
+}
+
+func TestTxnStatSeesStagedWrites(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "txn stat", 1024)
+	if err := client.Set("txn::stat", "value", time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	err := client.Txn([]string{"txn::stat", "txn::new"}, func(tx *nim.Tx) error {
+		info, ok, err := tx.Stat("txn::stat")
+		if err != nil || !ok || info.Expiry.IsZero() {
+			t.Fatalf("Stat(txn::stat) info=%+v ok=%v error=%v", info, ok, err)
+		}
+		if _, ok, err := tx.Stat("txn::new"); err != nil || ok {
+			t.Fatalf("Stat(txn::new) ok=%v error=%v want missing", ok, err)
+		}
+
+		if err := tx.Set("txn::new", "value", 0); err != nil {
+			return err
+		}
+		if err := tx.Remove("txn::stat"); err != nil {
+			return err
+		}
+		if info, ok, err := tx.Stat("txn::new"); err != nil || !ok || !info.Expiry.IsZero() {
+			t.Fatalf("staged Stat(txn::new) info=%+v ok=%v error=%v", info, ok, err)
+		}
+		if _, ok, err := tx.Stat("txn::stat"); err != nil || ok {
+			t.Fatalf("staged Stat(txn::stat) ok=%v error=%v want missing", ok, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Txn error=%v", err)
+	}
+}
+
+func TestTxnConcurrentTransfersKeepInvariant(t *testing.T) {
+	t.Parallel()
+
+	client := newClientForCase(t, "txn concurrent transfers", 1024)
+	keys := []string{"account::a", "account::b"}
+	if err := client.Set(keys[0], 100, 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := client.Set(keys[1], 100, 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	const workers, transfers = 6, 10
+	errCh := make(chan error, workers*transfers)
+	var wg sync.WaitGroup
+	for worker := range workers {
+		wg.Go(func() {
+			from, to := keys[worker%2], keys[(worker+1)%2]
+			for range transfers {
+				errCh <- client.Txn([]string{to, from}, func(tx *nim.Tx) error {
+					var a, b int
+					if _, err := tx.Get(from, &a); err != nil {
+						return err
+					}
+					if _, err := tx.Get(to, &b); err != nil {
+						return err
+					}
+					if err := tx.Set(from, a-1, 0); err != nil {
+						return err
+					}
+					return tx.Set(to, b+1, 0)
+				})
+			}
+		})
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			t.Fatalf("Txn error=%v", err)
+		}
+	}
+
+	var a, b int
+	if _, err := client.Get(keys[0], &a); err != nil {
+		t.Fatalf("Get error=%v", err)
+	}
+	if _, err := client.Get(keys[1], &b); err != nil {
+		t.Fatalf("Get error=%v", err)
+	}
+	if a+b != 200 {
+		t.Fatalf("balance sum=%d want=200 (a=%d b=%d)", a+b, a, b)
+	}
+}
+
+func TestTxnRecoveryTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		intent      bool
+		wantApplied bool
+	}{
+		{name: "rolls forward committed intent", intent: true, wantApplied: true},
+		{name: "discards txn without intent", intent: false, wantApplied: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootPath := filepath.Join(t.TempDir(), "root")
+			workDir := filepath.Join(rootPath, ".nim", "txn", "txn-crashed")
+			if err := os.MkdirAll(workDir, 0o755); err != nil {
+				t.Fatalf("MkdirAll error=%v", err)
+			}
+			if err := os.WriteFile(filepath.Join(workDir, "lock"), nil, 0o644); err != nil {
+				t.Fatalf("WriteFile(lock) error=%v", err)
+			}
+			if err := os.WriteFile(filepath.Join(workDir, "0"), []byte("recovered"), 0o644); err != nil {
+				t.Fatalf("WriteFile(payload) error=%v", err)
+			}
+
+			// Simulate a crash after the first key was already applied.
+			applied := cacheKeyDir(rootPath, "order::1")
+			if err := os.MkdirAll(applied, 0o755); err != nil {
+				t.Fatalf("MkdirAll error=%v", err)
+			}
+			if err := os.WriteFile(filepath.Join(applied, "cache"), []byte("applied"), 0o644); err != nil {
+				t.Fatalf("WriteFile(cache) error=%v", err)
+			}
+
+			if tc.intent {
+				expiry := time.Now().Add(time.Hour).UnixNano()
+				intent, err := json.Marshal([]map[string]any{
+					{"key": "order::1", "payload": "missing"},
+					{"key": "user::7::orders", "payload": "0", "expiry": expiry},
+				})
+				if err != nil {
+					t.Fatalf("Marshal error=%v", err)
+				}
+				if err := os.WriteFile(filepath.Join(workDir, "intent"), intent, 0o644); err != nil {
+					t.Fatalf("WriteFile(intent) error=%v", err)
+				}
+			}
+
+			client, err := nim.New(nim.Config{RootPath: rootPath})
+			if err != nil {
+				t.Fatalf("New error=%v", err)
+			}
+
+			if _, err := os.Stat(workDir); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("Stat(workDir) error=%v want not exist", err)
+			}
+
+			assertGetStringValue(t, client, "order::1", "applied")
+			exists, err := client.Exists("user::7::orders")
+			if err != nil {
+				t.Fatalf("Exists error=%v", err)
+			}
+			if exists != tc.wantApplied {
+				t.Fatalf("Exists=%v want=%v", exists, tc.wantApplied)
+			}
+			if tc.wantApplied {
+				assertGetStringValue(t, client, "user::7::orders", "recovered")
+				names := listSymlinkNames(t, cacheKeyDir(rootPath, "user::7::orders"))
+				if len(names) != 1 {
+					t.Fatalf("symlink names=%v want one expiry symlink", names)
+				}
+				if _, err := strconv.ParseInt(names[0], 10, 64); err != nil {
+					t.Fatalf("symlink name=%q parseErr=%v", names[0], err)
+				}
+			}
+		})
+	}
+}
+
+func TestTxnRecoverySkipsKeysRewrittenAfterCrash(t *testing.T) {
+	t.Parallel()
+
+	rootPath := filepath.Join(t.TempDir(), "root")
+	writer, err := nim.New(nim.Config{RootPath: rootPath})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if err := writer.Set("order::1", "before crash", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	// A commit that crashed before applying anything, recorded while
+	// order::1 held an older value and user::7 held none.
+	workDir := filepath.Join(rootPath, ".nim", "txn", "txn-crashed")
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll error=%v", err)
+	}
+	for name, data := range map[string]string{"lock": "", "0": "stale", "1": "stale", "2": "recovered"} {
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("WriteFile(%s) error=%v", name, err)
+		}
+	}
+	intent, err := json.Marshal([]map[string]any{
+		{"key": "order::1", "payload": "0", "before": "1:1:1"},
+		{"key": "order::2", "payload": "1"},
+		{"key": "user::7", "payload": "2"},
+		{"key": "user::8", "remove": true, "before": "1:1:1"},
+	})
+	if err != nil {
+		t.Fatalf("Marshal error=%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "intent"), intent, 0o644); err != nil {
+		t.Fatalf("WriteFile(intent) error=%v", err)
+	}
+
+	// Other writers keep going until the next New recovers the commit.
+	if err := writer.Set("order::1", "newer", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := writer.Set("order::2", "newer", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := writer.Set("user::8", "newer", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+
+	client, err := nim.New(nim.Config{RootPath: rootPath})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if _, err := os.Stat(workDir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat(workDir) error=%v want not exist", err)
+	}
+	assertGetStringValue(t, client, "order::1", "newer")
+	assertGetStringValue(t, client, "order::2", "newer")
+	assertGetStringValue(t, client, "user::8", "newer")
+	assertGetStringValue(t, client, "user::7", "recovered")
+}
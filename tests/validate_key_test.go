@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/brownhounds/nim"
@@ -47,6 +49,31 @@ func TestValidateKey(t *testing.T) {
 			key:     "hello::::resource",
 			wantErr: true,
 		},
+		{
+			name:    "parent segment",
+			key:     "..::..::victim",
+			wantErr: true,
+		},
+		{
+			name:    "single parent",
+			key:     "..",
+			wantErr: true,
+		},
+		{
+			name:    "current segment",
+			key:     "hello::.::resource",
+			wantErr: true,
+		},
+		{
+			name:    "slash in segment",
+			key:     "hello::a/../../b",
+			wantErr: true,
+		},
+		{
+			name:    "dots inside segment",
+			key:     "hello::v1..2::.hidden",
+			wantErr: false,
+		},
 	}
 
 	for _, tc := range cases {
@@ -58,3 +85,24 @@ func TestValidateKey(t *testing.T) {
 		})
 	}
 }
+
+func TestKeysIgnoresPrefixOutsideRoot(t *testing.T) {
+	t.Parallel()
+
+	base := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(base, "outside", "x"), 0o755); err != nil {
+		t.Fatalf("MkdirAll error=%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "outside", "x", "cache"), []byte("v"), 0o644); err != nil {
+		t.Fatalf("WriteFile error=%v", err)
+	}
+	client, err := nim.New(nim.Config{RootPath: filepath.Join(base, "root")})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+
+	keys, err := client.Keys("..::outside::")
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("Keys=%v error=%v want none", keys, err)
+	}
+}
@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brownhounds/nim"
+)
+
+func newWatchClients(t *testing.T) (watcher, writer *nim.Client) {
+	t.Helper()
+
+	rootPath := filepath.Join(t.TempDir(), "cache")
+	for _, c := range []**nim.Client{&watcher, &writer} {
+		client, err := nim.New(nim.Config{RootPath: rootPath})
+		if err != nil {
+			t.Fatalf("New error=%v", err)
+		}
+		*c = client
+	}
+	return watcher, writer
+}
+
+func watchEvent(t *testing.T, events <-chan nim.Event) nim.Event {
+	t.Helper()
+
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return ev
+	case <-time.After(3 * time.Second):
+		t.Fatal("no watch event")
+		return nim.Event{}
+	}
+}
+
+func expectWatch(t *testing.T, events <-chan nim.Event, kind nim.EventKind, key string) nim.Event {
+	t.Helper()
+
+	ev := watchEvent(t, events)
+	if ev.Kind != kind || ev.Key != key || ev.Reason != nim.ReasonWatch {
+		t.Fatalf("event=%+v want %s %s", ev, kind, key)
+	}
+	return ev
+}
+
+func TestWatchReportsChangesFromOtherClients(t *testing.T) {
+	t.Parallel()
+
+	watcher, writer := newWatchClients(t)
+	if err := writer.Set("users::1", "alice", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := watcher.Watch(ctx, "users::")
+	if err != nil {
+		t.Fatalf("Watch error=%v", err)
+	}
+
+	if err := writer.Set("orders::1", "x", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	if err := writer.Set("users::2", "bob", time.Hour); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	ev := expectWatch(t, events, nim.EventSet, "users::2")
+	if ev.Expiry.IsZero() {
+		t.Fatalf("set event=%+v want expiry", ev)
+	}
+
+	if err := writer.Set("users::1", "carol", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	expectWatch(t, events, nim.EventSet, "users::1")
+	if err := writer.Remove("users::1"); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	expectWatch(t, events, nim.EventRemove, "users::1")
+
+	// Namespaces created after Watch started are followed too.
+	if err := writer.Set("users::eu::west::3", "dave", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	expectWatch(t, events, nim.EventSet, "users::eu::west::3")
+
+	if _, err := writer.Expire("users::2", time.Hour); err != nil {
+		t.Fatalf("Expire error=%v", err)
+	}
+	if err := writer.Set("users::4", "erin", time.Millisecond); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	expectWatch(t, events, nim.EventSet, "users::4")
+	time.Sleep(5 * time.Millisecond)
+	if n, err := writer.Purge("users"); err != nil || n != 1 {
+		t.Fatalf("Purge n=%d error=%v", n, err)
+	}
+	expectWatch(t, events, nim.EventExpire, "users::4")
+
+	cancel()
+	for ev := range events {
+		t.Fatalf("unexpected event=%+v", ev)
+	}
+}
+
+func TestWatchFollowsRemovedNamespaces(t *testing.T) {
+	t.Parallel()
+
+	watcher, writer := newWatchClients(t)
+	for _, key := range []string{"users::1", "users::1::posts::1"} {
+		if err := writer.Set(key, "x", 0); err != nil {
+			t.Fatalf("Set error=%v", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := watcher.Watch(ctx, "users")
+	if err != nil {
+		t.Fatalf("Watch error=%v", err)
+	}
+
+	if err := writer.Remove("users::1"); err != nil {
+		t.Fatalf("Remove error=%v", err)
+	}
+	got := map[string]nim.EventKind{}
+	for range 2 {
+		ev := watchEvent(t, events)
+		got[ev.Key] = ev.Kind
+	}
+	if got["users::1"] != nim.EventRemove || got["users::1::posts::1"] != nim.EventRemove {
+		t.Fatalf("events=%v", got)
+	}
+
+	if err := writer.Set("users::1::posts::2", "y", 0); err != nil {
+		t.Fatalf("Set error=%v", err)
+	}
+	expectWatch(t, events, nim.EventSet, "users::1::posts::2")
+}
+
+func TestWatchRequiresFileBackend(t *testing.T) {
+	t.Parallel()
+
+	client, err := nim.New(nim.Config{Backend: nim.NewMemoryBackend()})
+	if err != nil {
+		t.Fatalf("New error=%v", err)
+	}
+	if _, err := client.Watch(context.Background(), ""); !errors.Is(err, nim.ErrCacheBackendUnsupported) {
+		t.Fatalf("Watch error=%v want %v", err, nim.ErrCacheBackendUnsupported)
+	}
+}
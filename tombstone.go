@@ -0,0 +1,125 @@
+package nim
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// Presence is the outcome of Lookup.
+type Presence int
+
+const (
+	// PresenceUnknown means nothing is cached for the key.
+	PresenceUnknown Presence = iota
+	// PresenceFound means a value is cached for the key.
+	PresenceFound
+	// PresenceAbsent means the key was recorded as absent with SetAbsent.
+	PresenceAbsent
+)
+
+func (p Presence) String() string {
+	switch p {
+	case PresenceFound:
+		return "found"
+	case PresenceAbsent:
+		return "absent"
+	default:
+		return "unknown"
+	}
+}
+
+// tombstoneValue is the payload of an entry recorded by SetAbsent. It is
+// stored in-band so tombstones need no backend support and travel through
+// the remote tier and the servers like any other entry. Set refuses it as a
+// value, so only SetAbsent writes it.
+var tombstoneValue = []byte("\x00nim-tombstone\x00")
+
+func isTombstone(b []byte) bool {
+	return bytes.Equal(b, tombstoneValue)
+}
+
+// checkValue refuses a payload that would read back as a tombstone.
+func checkValue(data []byte) error {
+	if isTombstone(data) {
+		return ErrCacheValueReserved
+	}
+	return nil
+}
+
+// SetAbsent records that key is known not to exist upstream, for ttl. Get,
+// Exists, Stat, Entry and Keys treat the key as missing, while Lookup
+// reports PresenceAbsent. A later Set replaces the tombstone.
+func (c *Client) SetAbsent(key string, ttl time.Duration) error {
+	return c.SetAbsentContext(context.Background(), key, ttl)
+}
+
+// SetAbsentContext is SetAbsent with a context that is passed to Config.Tracer.
+func (c *Client) SetAbsentContext(ctx context.Context, key string, ttl time.Duration) (err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpSetAbsent, key)
+	defer sp.end(&err)
+
+	return c.setBytes(&sp, ttl, tombstoneValue)
+}
+
+// Tombstone reports whether key holds a live tombstone in the local backend,
+// with its expiry.
+func (c *Client) Tombstone(key string) (EntryInfo, bool, error) {
+	if err := ValidateKey(key); err != nil {
+		return EntryInfo{}, false, err
+	}
+
+	info, ok, err := c.backend.Stat(key)
+	if err != nil || !ok || info.expired(time.Now()) {
+		return EntryInfo{}, false, err
+	}
+	absent, err := c.tombstoned(key, info)
+	if err != nil || !absent {
+		return EntryInfo{}, false, err
+	}
+	return info, true, nil
+}
+
+// Lookup is Get distinguishing a key recorded as absent from one that is not
+// cached at all. out is only written when the result is PresenceFound.
+func (c *Client) Lookup(key string, out any) (Presence, error) {
+	return c.LookupContext(context.Background(), key, out)
+}
+
+// LookupContext is Lookup with a context that is passed to Config.Tracer.
+func (c *Client) LookupContext(ctx context.Context, key string, out any) (_ Presence, err error) {
+	defer c.stats.fail(&err)
+	sp := c.startSpan(ctx, OpLookup, key)
+	defer sp.end(&err)
+
+	if err := ValidateKey(key); err != nil {
+		return PresenceUnknown, err
+	}
+
+	b, _, ok, err := c.getEntry(&sp)
+	if err != nil || !ok {
+		return PresenceUnknown, err
+	}
+	if isTombstone(b) {
+		return PresenceAbsent, nil
+	}
+
+	if err := decodeValue(b, out); err != nil {
+		return PresenceUnknown, err
+	}
+	return PresenceFound, nil
+}
+
+// tombstoned reports whether a local entry with info is a tombstone, reading
+// the payload only when its size matches.
+func (c *Client) tombstoned(key string, info EntryInfo) (bool, error) {
+	if info.Size != int64(len(tombstoneValue)) {
+		return false, nil
+	}
+	b, _, ok, err := c.backend.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return isTombstone(b), nil
+}
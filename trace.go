@@ -0,0 +1,155 @@
+package nim
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Op names an operation reported to a Tracer.
+type Op string
+
+const (
+	OpGet       Op = "get"
+	OpEntry     Op = "entry"
+	OpLookup    Op = "lookup"
+	OpStat      Op = "stat"
+	OpExists    Op = "exists"
+	OpSet       Op = "set"
+	OpSetAbsent Op = "set-absent"
+	OpRemove    Op = "remove"
+	OpExpire    Op = "expire"
+	// OpLock is Client.Lock, or a key lock taken inside another operation.
+	OpLock Op = "lock"
+
+	opImport Op = "import"
+	opTxn    Op = "txn"
+)
+
+// Outcome is how a traced operation ended.
+type Outcome string
+
+const (
+	// OutcomeOK is a write or lock that succeeded.
+	OutcomeOK Outcome = "ok"
+	// OutcomeHit is a read answered with a value.
+	OutcomeHit Outcome = "hit"
+	// OutcomeMiss is a read, or an Expire, that found nothing.
+	OutcomeMiss Outcome = "miss"
+	// OutcomeAbsent is a read that found a tombstone left by SetAbsent.
+	OutcomeAbsent Outcome = "absent"
+	// OutcomeError is an operation that returned an error.
+	OutcomeError Outcome = "error"
+)
+
+// Tracer receives the start and end of cache operations, so they can be
+// recorded as spans without nim depending on a tracing library. Key locks
+// taken by Set, Expire and remote fills are reported as OpLock operations
+// nested in the operation taking them. Methods are called on the goroutine
+// of the operation and must be safe for concurrent use.
+type Tracer interface {
+	// TraceStart is called as an operation begins. The context it returns
+	// is passed to the matching TraceEnd and to TraceStart of nested
+	// operations. It derives from the context given to Client.Lock, or
+	// from context.Background for operations that take none.
+	TraceStart(ctx context.Context, start OpStart) context.Context
+	TraceEnd(ctx context.Context, end OpEnd)
+}
+
+// OpStart describes an operation as it begins. Namespace is the key without
+// its last segment, empty for single-segment keys.
+type OpStart struct {
+	Time      time.Time
+	Op        Op
+	Key       string
+	Namespace string
+}
+
+// OpEnd describes a finished operation. Size is the payload written, or read
+// by a hit, and zero otherwise.
+type OpEnd struct {
+	Err       error
+	Op        Op
+	Outcome   Outcome
+	Key       string
+	Namespace string
+	Size      int64
+	Duration  time.Duration
+}
+
+// span follows one operation. Without a tracer it only carries op and key
+// for logging.
+type span struct {
+	ctx     context.Context
+	tracer  Tracer
+	start   time.Time
+	op      Op
+	outcome Outcome
+	key     string
+	size    int64
+}
+
+func (c *Client) startSpan(ctx context.Context, op Op, key string) span {
+	return startSpan(ctx, c.tracer, op, key)
+}
+
+func startSpan(ctx context.Context, tracer Tracer, op Op, key string) span {
+	sp := span{op: op, key: key, tracer: tracer}
+	if tracer == nil {
+		return sp
+	}
+	sp.start = time.Now()
+	sp.ctx = tracer.TraceStart(ctx, OpStart{
+		Time:      sp.start,
+		Op:        op,
+		Key:       key,
+		Namespace: namespace(key),
+	})
+	return sp
+}
+
+// child starts a nested operation on the same key.
+func (sp *span) child(op Op) span {
+	return startSpan(sp.ctx, sp.tracer, op, sp.key)
+}
+
+// read records the outcome of a read.
+func (sp *span) read(b []byte, size int64, ok bool) {
+	switch {
+	case !ok:
+		sp.outcome = OutcomeMiss
+	case isTombstone(b):
+		sp.outcome = OutcomeAbsent
+	default:
+		sp.outcome, sp.size = OutcomeHit, size
+	}
+}
+
+// end reports the operation, with *errp as its error. It is deferred by the
+// traced operations.
+func (sp *span) end(errp *error) {
+	if sp.tracer == nil {
+		return
+	}
+	end := OpEnd{
+		Op:        sp.op,
+		Outcome:   sp.outcome,
+		Key:       sp.key,
+		Namespace: namespace(sp.key),
+		Size:      sp.size,
+		Duration:  time.Since(sp.start),
+	}
+	if *errp != nil {
+		end.Err, end.Outcome, end.Size = *errp, OutcomeError, 0
+	} else if end.Outcome == "" {
+		end.Outcome = OutcomeOK
+	}
+	sp.tracer.TraceEnd(sp.ctx, end)
+}
+
+func namespace(key string) string {
+	if i := strings.LastIndex(key, "::"); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
@@ -0,0 +1,579 @@
+package nim
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Tx is a staged view over the keys locked by Client.Txn. Reads observe
+// staged writes; nothing reaches the backend until the transaction commits.
+type Tx struct {
+	client *Client
+	keys   map[string]struct{}
+	staged map[string]TxnOp
+	closed bool
+}
+
+type txnRecord struct {
+	Key     string `json:"key"`
+	Payload string `json:"payload,omitempty"`
+	// Before and Written identify the cache file of the key when the intent
+	// was recorded and the one the commit puts there, "" meaning none.
+	// Recovery leaves keys holding neither alone.
+	Before  string `json:"before,omitempty"`
+	Written string `json:"written,omitempty"`
+	Expiry  int64  `json:"expiry,omitempty"`
+	Remove  bool   `json:"remove,omitempty"`
+}
+
+// Txn locks every key in sorted order, runs fn against a staged view and
+// commits all staged changes atomically. If fn returns an error nothing is
+// written. With the default file backend a commit interrupted by a crash is
+// rolled forward by the next New on the same RootPath. The backend must
+// implement Committer.
+func (c *Client) Txn(keys []string, fn func(tx *Tx) error) error {
+	return c.txn(keys, ReasonTxn, fn)
+}
+
+// txn runs Txn, reporting its changes with reason.
+func (c *Client) txn(keys []string, reason EventReason, fn func(tx *Tx) error) error {
+	committer, ok := c.backend.(Committer)
+	if !ok {
+		return fmt.Errorf("%w: Txn", ErrCacheBackendUnsupported)
+	}
+
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	tx := &Tx{
+		client: c,
+		keys:   make(map[string]struct{}, len(keys)),
+		staged: make(map[string]TxnOp, len(keys)),
+	}
+	for _, key := range keys {
+		if err := ValidateKey(key); err != nil {
+			c.stats.fail(&err)
+			return err
+		}
+		tx.keys[key] = struct{}{}
+	}
+
+	start := time.Now()
+	locks, err := lockAll(c.log, c.backend, keys)
+	if err != nil {
+		c.stats.fail(&err)
+		return err
+	}
+	defer unlockAll(c.log, locks)
+	c.waited(opTxn, strings.Join(keys, ","), start)
+
+	err = fn(tx)
+	tx.closed = true
+	if err != nil {
+		return err
+	}
+
+	ops := tx.ops()
+	if len(ops) == 0 {
+		return nil
+	}
+	defer func() {
+		for _, op := range ops {
+			c.l1.invalidate(op.Key)
+		}
+	}()
+
+	changed, err := c.changedOps(ops)
+	if err != nil {
+		c.stats.fail(&err)
+		return err
+	}
+	if err := committer.Commit(ops); err != nil {
+		c.stats.fail(&err)
+		return err
+	}
+	for _, op := range changed {
+		if op.Remove {
+			c.stats.remove()
+		} else {
+			c.stats.set(len(op.Data))
+		}
+	}
+	c.events.emitTxn(changed, reason)
+	if c.writeThrough {
+		err := writeThroughTxn(c.remote, ops)
+		c.stats.fail(&err)
+		return err
+	}
+	return nil
+}
+
+func (tx *Tx) Get(key string, out any) (bool, error) {
+	b, ok, err := tx.read(key)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	if err := decodeValue(b, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (tx *Tx) Exists(key string) (bool, error) {
+	_, ok, err := tx.read(key)
+	return ok, err
+}
+
+// Stat reports the expiry and size of key as the transaction sees it,
+// including its staged writes.
+func (tx *Tx) Stat(key string) (EntryInfo, bool, error) {
+	if err := tx.check(key); err != nil {
+		return EntryInfo{}, false, err
+	}
+
+	now := time.Now()
+	op, ok := tx.staged[key]
+	if !ok {
+		info, ok, err := tx.client.backend.Stat(key)
+		if err != nil || !ok || info.expired(now) {
+			return EntryInfo{}, false, err
+		}
+		absent, err := tx.client.tombstoned(key, info)
+		if err != nil || absent {
+			return EntryInfo{}, false, err
+		}
+		return info, true, nil
+	}
+	if op.Remove || (!op.Expiry.IsZero() && now.After(op.Expiry)) {
+		return EntryInfo{}, false, nil
+	}
+	return EntryInfo{Expiry: op.Expiry, Size: int64(len(op.Data))}, true, nil
+}
+
+func (tx *Tx) Set(key string, v any, ttl time.Duration) error {
+	if err := tx.check(key); err != nil {
+		return err
+	}
+
+	data, err := encodeValue(v)
+	if err != nil {
+		return err
+	}
+	if err := checkValue(data); err != nil {
+		return err
+	}
+	if err := tx.client.validateCacheSize(len(data)); err != nil {
+		return err
+	}
+
+	tx.staged[key] = TxnOp{Key: key, Data: bytes.Clone(data), Expiry: expiryFromTTL(ttl)}
+	return nil
+}
+
+// Remove stages the deletion of the entry stored at key. Unlike
+// Client.Remove, nested namespaces below key are left untouched.
+func (tx *Tx) Remove(key string) error {
+	if err := tx.check(key); err != nil {
+		return err
+	}
+
+	tx.staged[key] = TxnOp{Key: key, Remove: true}
+	return nil
+}
+
+func (tx *Tx) check(key string) error {
+	if tx.closed {
+		return ErrCacheTxnClosed
+	}
+	if _, ok := tx.keys[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrCacheTxnKeyNotLocked, key)
+	}
+	return nil
+}
+
+func (tx *Tx) read(key string) ([]byte, bool, error) {
+	if err := tx.check(key); err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	op, ok := tx.staged[key]
+	if !ok {
+		b, info, ok, err := tx.client.backend.Get(key)
+		if err != nil || !ok || info.expired(now) || isTombstone(b) {
+			return nil, false, err
+		}
+		return b, true, nil
+	}
+	if op.Remove || (!op.Expiry.IsZero() && now.After(op.Expiry)) {
+		return nil, false, nil
+	}
+	return bytes.Clone(op.Data), true, nil
+}
+
+func (tx *Tx) ops() []TxnOp {
+	ops := make([]TxnOp, 0, len(tx.staged))
+	for _, op := range tx.staged {
+		ops = append(ops, op)
+	}
+	slices.SortFunc(ops, func(a, b TxnOp) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return ops
+}
+
+// changedOps drops the removals of keys that are not stored, which are
+// neither counted nor reported. The locks of all keys in ops are held.
+func (c *Client) changedOps(ops []TxnOp) ([]TxnOp, error) {
+	changed := make([]TxnOp, 0, len(ops))
+	for _, op := range ops {
+		if op.Remove {
+			_, ok, err := c.backend.Stat(op.Key)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		changed = append(changed, op)
+	}
+	return changed, nil
+}
+
+// writeThroughTxn forwards committed ops to the remote tier one by one; the
+// remote tier gives no atomicity across keys.
+func writeThroughTxn(remote RemoteTier, ops []TxnOp) error {
+	for _, op := range ops {
+		var err error
+		if op.Remove {
+			err = remote.Delete(op.Key)
+		} else {
+			err = remote.Set(op.Key, op.Data, op.Expiry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lockAll(log *slog.Logger, backend Backend, keys []string) ([]Unlocker, error) {
+	locks := make([]Unlocker, 0, len(keys))
+	for _, key := range keys {
+		lock, err := backend.Lock(key)
+		if err != nil {
+			unlockAll(log, locks)
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+func unlockAll(log *slog.Logger, locks []Unlocker) {
+	for i := len(locks) - 1; i >= 0; i-- {
+		if err := locks[i].Unlock(); err != nil {
+			log.Warn("failed to release key lock", "op", "txn", "err", err)
+		}
+	}
+}
+
+// Commit writes every payload into a private work directory, then records an
+// intent log there before touching any key. Once the intent is durable the
+// transaction is rolled forward by recovery if applying it is interrupted.
+func (f *fileBackend) Commit(ops []TxnOp) error {
+	if err := f.barrier.enter(); err != nil {
+		return err
+	}
+	defer f.barrier.leave()
+
+	txnRoot := filepath.Join(f.rootPath, internalDirName, txnDirName)
+	if err := os.MkdirAll(txnRoot, 0o755); err != nil {
+		return err
+	}
+
+	workDir, lock, err := createTxnDir(txnRoot)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = lock.unlock()
+	}()
+
+	records, err := f.prepareTxn(workDir, ops)
+	if err != nil {
+		_ = os.RemoveAll(workDir)
+		return err
+	}
+	if err := writeTxnIntent(workDir, records); err != nil {
+		_ = os.RemoveAll(workDir)
+		return err
+	}
+
+	// From here on the intent is durable: on failure the work directory is
+	// left in place so that recovery can roll the transaction forward.
+	if err := f.applyTxn(workDir, records); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(workDir)
+}
+
+func (f *fileBackend) prepareTxn(workDir string, ops []TxnOp) ([]txnRecord, error) {
+	records := make([]txnRecord, 0, len(ops))
+	for _, op := range ops {
+		dirPath, err := f.keyDir(op.Key)
+		if err != nil {
+			return nil, err
+		}
+		rec := txnRecord{Key: op.Key, Remove: op.Remove}
+		if rec.Before, err = cacheFileID(filepath.Join(dirPath, cacheFileName)); err != nil {
+			return nil, err
+		}
+		if !op.Remove {
+			rec.Payload = strconv.Itoa(len(records))
+			payloadPath := filepath.Join(workDir, rec.Payload)
+			if err := writeFileSync(payloadPath, op.Data); err != nil {
+				return nil, err
+			}
+			// The rename into the key directory keeps the identity.
+			if rec.Written, err = cacheFileID(payloadPath); err != nil {
+				return nil, err
+			}
+			if !op.Expiry.IsZero() {
+				rec.Expiry = op.Expiry.UnixNano()
+			}
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+func (f *fileBackend) applyTxn(workDir string, records []txnRecord) error {
+	for _, rec := range records {
+		dirPath, err := f.keyDir(rec.Key)
+		if err != nil {
+			return err
+		}
+
+		if rec.Remove {
+			if err := f.removeEntry(dirPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(dirPath, 0o755); err != nil {
+			return err
+		}
+		payloadPath := filepath.Join(workDir, rec.Payload)
+		cachePath := filepath.Join(dirPath, cacheFileName)
+		if err := os.Rename(payloadPath, cachePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		var expiry time.Time
+		if rec.Expiry != 0 {
+			expiry = time.Unix(0, rec.Expiry)
+		}
+		if err := f.writeExpirySymlink(dirPath, expiry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *fileBackend) removeEntry(dirPath string) error {
+	cachePath := filepath.Join(dirPath, cacheFileName)
+	if err := os.Remove(cachePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := f.removeTTLSymlinks(dirPath); err != nil {
+		return err
+	}
+	// Only succeeds when no nested namespaces live below the entry.
+	_ = os.Remove(dirPath)
+	return nil
+}
+
+func (f *fileBackend) recoverTxns() error {
+	txnRoot := filepath.Join(f.rootPath, internalDirName, txnDirName)
+	entries, err := os.ReadDir(txnRoot)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), txnNamePrefix) {
+			continue
+		}
+		if err := f.recoverTxn(filepath.Join(txnRoot, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *fileBackend) recoverTxn(workDir string) error {
+	lock, ok, err := tryLockFile(filepath.Join(workDir, txnLockFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !ok {
+		// The owning process is still committing.
+		return nil
+	}
+	defer func() {
+		_ = lock.unlock()
+	}()
+
+	records, err := readTxnIntent(workDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return os.RemoveAll(workDir)
+		}
+		return err
+	}
+
+	keys := make([]string, 0, len(records))
+	for _, rec := range records {
+		keys = append(keys, rec.Key)
+	}
+	slices.Sort(keys)
+
+	locks, err := lockAll(f.log, f, keys)
+	if err != nil {
+		return err
+	}
+	defer unlockAll(f.log, locks)
+
+	if err := f.barrier.enter(); err != nil {
+		return err
+	}
+	defer f.barrier.leave()
+
+	if records, err = f.pendingRecords(records); err != nil {
+		return err
+	}
+	if err := f.applyTxn(workDir, records); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(workDir)
+}
+
+// pendingRecords drops the records of keys written since the intent was
+// recorded, so a late recovery does not overwrite newer data. A key already
+// holding the committed payload is kept to finish its expiry.
+func (f *fileBackend) pendingRecords(records []txnRecord) ([]txnRecord, error) {
+	pending := records[:0]
+	for _, rec := range records {
+		dirPath, err := f.keyDir(rec.Key)
+		if err != nil {
+			return nil, err
+		}
+		current, err := cacheFileID(filepath.Join(dirPath, cacheFileName))
+		if err != nil {
+			return nil, err
+		}
+		if current != rec.Before && (rec.Remove || current != rec.Written) {
+			f.log.Warn("skipping transaction record of a key changed since", "op", "txn", "key", rec.Key)
+			continue
+		}
+		pending = append(pending, rec)
+	}
+	return pending, nil
+}
+
+// cacheFileID identifies the file at path by inode, size and modification
+// time, returning "" when there is none.
+func cacheFileID(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	var ino uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		ino = st.Ino
+	}
+	return fmt.Sprintf("%d:%d:%d", ino, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+func createTxnDir(txnRoot string) (string, *keyLock, error) {
+	tmpDir, err := os.MkdirTemp(txnRoot, txnTempPattern)
+	if err != nil {
+		return "", nil, err
+	}
+
+	lock, err := lockFile(filepath.Join(tmpDir, txnLockFileName))
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return "", nil, err
+	}
+
+	// The directory only becomes visible to recovery once its lock is held.
+	suffix := strings.TrimPrefix(filepath.Base(tmpDir), strings.TrimSuffix(txnTempPattern, "*"))
+	workDir := filepath.Join(txnRoot, txnNamePrefix+suffix)
+	if err := os.Rename(tmpDir, workDir); err != nil {
+		_ = lock.unlock()
+		_ = os.RemoveAll(tmpDir)
+		return "", nil, err
+	}
+
+	return workDir, lock, nil
+}
+
+func writeTxnIntent(workDir string, records []txnRecord) error {
+	b, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(workDir, txnIntentTempName)
+	if err := writeFileSync(tmpPath, b); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(workDir, txnIntentFileName)); err != nil {
+		return err
+	}
+
+	return syncDir(workDir)
+}
+
+func readTxnIntent(workDir string) ([]txnRecord, error) {
+	b, err := os.ReadFile(filepath.Join(workDir, txnIntentFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []txnRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction intent %s: %w", workDir, err)
+	}
+	return records, nil
+}
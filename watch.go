@@ -0,0 +1,226 @@
+package nim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Watch reports changes to the entries under prefix made by any process
+// sharing the root, until ctx is done; the channel is closed then. Sets,
+// removals and expirations are sent with ReasonWatch; a removal is an
+// expiration when the entry had expired by then, and changes of TTL alone
+// are not reported. Entries present when Watch returns are not reported.
+//
+// On Linux changes are followed with inotify, including directories created
+// later. Elsewhere, or once the inotify watch limit is reached, the tree is
+// polled every second instead. Only the default file backend supports it.
+func (c *Client) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	if c.files == nil {
+		return nil, fmt.Errorf("%w: Watch", ErrCacheBackendUnsupported)
+	}
+
+	out := make(chan Event)
+	w := &watcher{
+		files:  c.files,
+		known:  make(map[string]watchedEntry),
+		out:    out,
+		prefix: prefix,
+	}
+	if err := w.start(); err != nil {
+		return nil, err
+	}
+	if err := w.sync(ctx); err != nil {
+		w.stop()
+		return nil, err
+	}
+	w.primed = true
+
+	go w.run(ctx)
+	return out, nil
+}
+
+// watcher tracks the entries under a prefix and reports how they change.
+type watcher struct {
+	files  *fileBackend
+	in     *inotify
+	known  map[string]watchedEntry
+	out    chan<- Event
+	prefix string
+	primed bool
+}
+
+// watchedEntry identifies the cache file of an entry, so a rewrite is told
+// apart from a change of expiry.
+type watchedEntry struct {
+	modTime time.Time
+	expiry  time.Time
+	ino     uint64
+	size    int64
+}
+
+func (w *watcher) run(ctx context.Context) {
+	defer close(w.out)
+
+	if w.in != nil {
+		err := w.notify(ctx)
+		w.stop()
+		if err == nil {
+			return
+		}
+		w.files.log.Warn("falling back to polling", "op", "watch", "key", w.prefix, "err", err)
+	}
+	w.poll(ctx)
+}
+
+func (w *watcher) poll(ctx context.Context) {
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.sync(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.files.log.Warn("failed to scan cache root", "op", "watch", "key", w.prefix, "err", err)
+		}
+	}
+}
+
+// sync compares every entry under the prefix with what is known.
+func (w *watcher) sync(ctx context.Context) error {
+	found := make(map[string]struct{})
+	err := w.walk(w.files.rootPath, func(dirPath string) error {
+		key, ok := w.files.keyFromDir(dirPath)
+		if !ok || !strings.HasPrefix(key, w.prefix) {
+			return nil
+		}
+		found[key] = struct{}{}
+		return w.reconcile(ctx, key)
+	})
+	if err != nil {
+		return err
+	}
+	for key := range w.known {
+		if _, ok := found[key]; !ok {
+			if err := w.reconcile(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walk calls fn for each directory below root that may hold keys under the
+// prefix.
+func (w *watcher) walk(root string, fn func(dirPath string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if !w.descend(path) {
+			return filepath.SkipDir
+		}
+		return fn(path)
+	})
+}
+
+// descend reports whether dirPath may hold keys under the prefix.
+func (w *watcher) descend(dirPath string) bool {
+	if dirPath == w.files.rootPath {
+		return true
+	}
+	key, ok := w.files.keyFromDir(dirPath)
+	if !ok || key == internalDirName || strings.HasPrefix(key, internalDirName+"::") {
+		return false
+	}
+	return strings.HasPrefix(key, w.prefix) || strings.HasPrefix(w.prefix, key+"::")
+}
+
+// reconcile reads the entry at key and reports how it changed. It only fails
+// when ctx is done or the entry cannot be read.
+func (w *watcher) reconcile(ctx context.Context, key string) error {
+	entry, ok, err := w.stat(key)
+	if err != nil {
+		return err
+	}
+	old, had := w.known[key]
+
+	switch {
+	case ok && had && entry.sameFile(old):
+		w.known[key] = entry
+		return nil
+	case ok:
+		w.known[key] = entry
+		return w.send(ctx, Event{Kind: EventSet, Key: key, Expiry: entry.expiry})
+	case had:
+		delete(w.known, key)
+		kind := EventRemove
+		if !old.expiry.IsZero() && !time.Now().Before(old.expiry) {
+			kind = EventExpire
+		}
+		return w.send(ctx, Event{Kind: kind, Key: key})
+	}
+	return nil
+}
+
+func (w *watcher) stat(key string) (watchedEntry, bool, error) {
+	dirPath, err := w.files.keyDir(key)
+	if err != nil {
+		return watchedEntry{}, false, err
+	}
+	info, err := os.Stat(filepath.Join(dirPath, cacheFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return watchedEntry{}, false, nil
+		}
+		return watchedEntry{}, false, err
+	}
+	if !info.Mode().IsRegular() {
+		return watchedEntry{}, false, nil
+	}
+	expiry, _, err := readExpiryFromSymlink(dirPath)
+	if err != nil {
+		return watchedEntry{}, false, err
+	}
+
+	entry := watchedEntry{modTime: info.ModTime(), expiry: expiry, size: info.Size()}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.ino = st.Ino
+	}
+	return entry, true, nil
+}
+
+func (e watchedEntry) sameFile(o watchedEntry) bool {
+	return e.ino == o.ino && e.size == o.size && e.modTime.Equal(o.modTime)
+}
+
+func (w *watcher) send(ctx context.Context, ev Event) error {
+	if !w.primed {
+		return nil
+	}
+	ev.Time = time.Now()
+	ev.Reason = ReasonWatch
+	select {
+	case w.out <- ev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,305 @@
+package nim
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	inotifyDirMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+		syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+	// The parent of the root is watched only to see a restored root
+	// renamed into place.
+	inotifyParentMask = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+)
+
+// inotify is an inotify instance watching the directories of a key tree.
+type inotify struct {
+	file   *os.File
+	dirs   map[int32]string
+	wds    map[string]int32
+	fd     int
+	parent int32
+}
+
+type inotifyEvent struct {
+	name string
+	wd   int32
+	mask uint32
+}
+
+func openInotify() (*inotify, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking descriptor goes through the runtime poller, so Close
+	// interrupts a pending Read. File.Fd would make it blocking again, so fd
+	// is kept for the watch calls.
+	return &inotify{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int32]string),
+		wds:    make(map[string]int32),
+		fd:     fd,
+		parent: -1,
+	}, nil
+}
+
+// start sets up inotify for the tree, leaving w.in nil to poll instead when
+// inotify is unavailable or its limits are reached.
+func (w *watcher) start() error {
+	in, err := openInotify()
+	if err != nil {
+		w.files.log.Warn("falling back to polling", "op", "watch", "key", w.prefix, "err", err)
+		return nil
+	}
+	w.in = in
+
+	// Without a watch on the parent a restored root is not followed, which
+	// is no reason to fail.
+	root := w.files.rootPath
+	wd, err := in.add(filepath.Dir(root), inotifyParentMask)
+	if err == nil {
+		in.parent = wd
+	}
+	if err == nil || !errors.Is(err, errWatchLimit) {
+		err = w.addTree(root, nil)
+	}
+	if err != nil {
+		w.stop()
+		if errors.Is(err, errWatchLimit) {
+			w.files.log.Warn("falling back to polling", "op", "watch", "key", w.prefix, "err", err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (w *watcher) stop() {
+	if w.in != nil {
+		_ = w.in.file.Close()
+		w.in = nil
+	}
+}
+
+// notify follows inotify events until ctx is done, when it returns nil, or
+// the watch limit is reached.
+func (w *watcher) notify(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	batches := make(chan []inotifyEvent)
+	errc := make(chan error, 1)
+	go w.in.read(batches, errc, done)
+
+	// Touched directories are read once writes to them have settled, so a
+	// payload and its expiry symlink are seen together. The value marks
+	// directories whose whole subtree may have changed.
+	dirty := make(map[string]bool)
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errc:
+			return err
+		case batch := <-batches:
+			if err := w.handle(batch, dirty); err != nil {
+				return err
+			}
+			if settle == nil && len(dirty) > 0 {
+				settle = time.After(watchSettle)
+			}
+		case <-settle:
+			settle = nil
+			if err := w.flush(ctx, dirty); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				w.files.log.Warn("failed to read changed entry", "op", "watch", "key", w.prefix, "err", err)
+			}
+			clear(dirty)
+		}
+	}
+}
+
+func (w *watcher) handle(batch []inotifyEvent, dirty map[string]bool) error {
+	in, root := w.in, w.files.rootPath
+	for _, ev := range batch {
+		if ev.mask&syscall.IN_Q_OVERFLOW != 0 {
+			// Events were lost; read the whole tree again.
+			dirty[root] = true
+			if err := w.addTree(root, dirty); err != nil {
+				return err
+			}
+			continue
+		}
+		dir, ok := in.dirs[ev.wd]
+		if !ok {
+			continue
+		}
+		if ev.mask&syscall.IN_IGNORED != 0 {
+			in.forget(ev.wd)
+			continue
+		}
+
+		if ev.wd == in.parent {
+			if ev.name == filepath.Base(root) {
+				dirty[root] = true
+				if err := w.addTree(root, dirty); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if ev.mask&(syscall.IN_MOVE_SELF|syscall.IN_DELETE_SELF) != 0 {
+			// Moves and removals below the root are seen in the parent.
+			if dir == root {
+				// Replaced by Restore: the old watches now follow the old tree.
+				in.dropTree(root)
+				dirty[root] = true
+				if err := w.addTree(root, dirty); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		path := filepath.Join(dir, ev.name)
+		switch {
+		case ev.mask&syscall.IN_ISDIR == 0:
+			if !dirty[dir] {
+				dirty[dir] = false
+			}
+		case ev.mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			if w.descend(path) {
+				if err := w.addTree(path, dirty); err != nil {
+					return err
+				}
+			}
+		default:
+			in.dropTree(path)
+			dirty[path] = true
+		}
+	}
+	return nil
+}
+
+// addTree watches dirPath and the directories below it that may hold keys
+// under the prefix, marking them dirty when dirty is not nil.
+func (w *watcher) addTree(dirPath string, dirty map[string]bool) error {
+	return w.walk(dirPath, func(path string) error {
+		if _, err := w.in.add(path, inotifyDirMask); err != nil {
+			if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+				return nil
+			}
+			return err
+		}
+		if dirty != nil && !dirty[path] {
+			dirty[path] = false
+		}
+		return nil
+	})
+}
+
+// flush reconciles the entries of the dirty directories.
+func (w *watcher) flush(ctx context.Context, dirty map[string]bool) error {
+	for dirPath, tree := range dirty {
+		key, ok := w.files.keyFromDir(dirPath)
+		if ok && strings.HasPrefix(key, w.prefix) {
+			if err := w.reconcile(ctx, key); err != nil {
+				return err
+			}
+		}
+		if !tree {
+			continue
+		}
+		for known := range w.known {
+			if ok && !strings.HasPrefix(known, key+"::") {
+				continue
+			}
+			if err := w.reconcile(ctx, known); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (in *inotify) add(dirPath string, mask uint32) (int32, error) {
+	wd, err := syscall.InotifyAddWatch(in.fd, dirPath, mask)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return 0, errWatchLimit
+		}
+		return 0, os.NewSyscallError("inotify_add_watch", err)
+	}
+	w := int32(wd)
+	if old, ok := in.wds[dirPath]; ok && old != w {
+		delete(in.dirs, old)
+	}
+	in.dirs[w] = dirPath
+	in.wds[dirPath] = w
+	return w, nil
+}
+
+func (in *inotify) forget(wd int32) {
+	if dirPath, ok := in.dirs[wd]; ok {
+		delete(in.dirs, wd)
+		if in.wds[dirPath] == wd {
+			delete(in.wds, dirPath)
+		}
+	}
+}
+
+// dropTree stops watching dirPath and everything below it.
+func (in *inotify) dropTree(dirPath string) {
+	for wd, path := range in.dirs {
+		if wd == in.parent || (path != dirPath && !strings.HasPrefix(path, dirPath+string(filepath.Separator))) {
+			continue
+		}
+		_, _ = syscall.InotifyRmWatch(in.fd, uint32(wd))
+		in.forget(wd)
+	}
+}
+
+func (in *inotify) read(batches chan<- []inotifyEvent, errc chan<- error, done <-chan struct{}) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := in.file.Read(buf)
+		if err != nil {
+			errc <- err
+			return
+		}
+		select {
+		case batches <- parseInotify(buf[:n]):
+		case <-done:
+			return
+		}
+	}
+}
+
+func parseInotify(b []byte) []inotifyEvent {
+	var events []inotifyEvent
+	for len(b) >= syscall.SizeofInotifyEvent {
+		nameLen := int(binary.NativeEndian.Uint32(b[12:16]))
+		end := syscall.SizeofInotifyEvent + nameLen
+		if end > len(b) {
+			break
+		}
+		events = append(events, inotifyEvent{
+			wd:   int32(binary.NativeEndian.Uint32(b[0:4])),
+			mask: binary.NativeEndian.Uint32(b[4:8]),
+			name: string(bytes.TrimRight(b[syscall.SizeofInotifyEvent:end], "\x00")),
+		})
+		b = b[end:]
+	}
+	return events
+}
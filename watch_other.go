@@ -0,0 +1,18 @@
+//go:build !linux
+
+package nim
+
+import "context"
+
+// inotify is only available on Linux; elsewhere Watch polls.
+type inotify struct{}
+
+func (w *watcher) start() error {
+	return nil
+}
+
+func (w *watcher) stop() {}
+
+func (w *watcher) notify(context.Context) error {
+	return nil
+}